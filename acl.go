@@ -0,0 +1,260 @@
+package mtree
+
+import (
+	"archive/tar"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/vbatts/go-mtree/xattr"
+)
+
+// The extended attributes in which Linux stores POSIX ACLs.
+const (
+	aclAccessXattr  = "system.posix_acl_access"
+	aclDefaultXattr = "system.posix_acl_default"
+)
+
+// The tags of ACL entries, as in <linux/posix_acl.h>.
+const (
+	aclUserObj  = 0x01
+	aclUser     = 0x02
+	aclGroupObj = 0x04
+	aclGroup    = 0x08
+	aclMask     = 0x10
+	aclOther    = 0x20
+)
+
+const (
+	aclXattrVersion = 2
+	aclUndefinedID  = 0xffffffff
+)
+
+// aclEntry is one entry of a POSIX ACL.
+type aclEntry struct {
+	tag  uint16
+	perm uint16
+	id   uint32
+}
+
+var aclTagNames = map[uint16]string{
+	aclUserObj:  "user",
+	aclUser:     "user",
+	aclGroupObj: "group",
+	aclGroup:    "group",
+	aclMask:     "mask",
+	aclOther:    "other",
+}
+
+// sortACL puts the entries in the order used by the kernel and getfacl(1).
+func sortACL(entries []aclEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].tag != entries[j].tag {
+			return entries[i].tag < entries[j].tag
+		}
+		return entries[i].id < entries[j].id
+	})
+}
+
+// decodeACL decodes the value of a system.posix_acl_* extended attribute.
+func decodeACL(buf []byte) ([]aclEntry, error) {
+	if len(buf) < 4 || (len(buf)-4)%8 != 0 {
+		return nil, fmt.Errorf("invalid acl: bad length %d", len(buf))
+	}
+	if v := binary.LittleEndian.Uint32(buf); v != aclXattrVersion {
+		return nil, fmt.Errorf("invalid acl: unsupported version %d", v)
+	}
+	var entries []aclEntry
+	for buf = buf[4:]; len(buf) > 0; buf = buf[8:] {
+		e := aclEntry{
+			tag:  binary.LittleEndian.Uint16(buf[0:]),
+			perm: binary.LittleEndian.Uint16(buf[2:]),
+			id:   binary.LittleEndian.Uint32(buf[4:]),
+		}
+		if _, ok := aclTagNames[e.tag]; !ok {
+			return nil, fmt.Errorf("invalid acl: unknown tag %#x", e.tag)
+		}
+		if e.tag != aclUser && e.tag != aclGroup {
+			e.id = aclUndefinedID
+		}
+		e.perm &= 0o7
+		entries = append(entries, e)
+	}
+	sortACL(entries)
+	return entries, nil
+}
+
+// encodeACL encodes entries as the value of a system.posix_acl_* extended
+// attribute.
+func encodeACL(entries []aclEntry) []byte {
+	buf := make([]byte, 4, 4+8*len(entries))
+	binary.LittleEndian.PutUint32(buf, aclXattrVersion)
+	for _, e := range entries {
+		buf = binary.LittleEndian.AppendUint16(buf, e.tag)
+		buf = binary.LittleEndian.AppendUint16(buf, e.perm)
+		buf = binary.LittleEndian.AppendUint32(buf, e.id)
+	}
+	return buf
+}
+
+// formatACL returns the ACL in the short text form of getfacl(1), with
+// numeric IDs, such as "user::rw-,user:1000:r--,group::r--,mask::r--,other::---".
+func formatACL(entries []aclEntry) string {
+	parts := make([]string, len(entries))
+	for i, e := range entries {
+		qualifier := ""
+		if e.tag == aclUser || e.tag == aclGroup {
+			qualifier = strconv.FormatUint(uint64(e.id), 10)
+		}
+		perm := []byte("---")
+		if e.perm&0o4 != 0 {
+			perm[0] = 'r'
+		}
+		if e.perm&0o2 != 0 {
+			perm[1] = 'w'
+		}
+		if e.perm&0o1 != 0 {
+			perm[2] = 'x'
+		}
+		parts[i] = aclTagNames[e.tag] + ":" + qualifier + ":" + string(perm)
+	}
+	return strings.Join(parts, ",")
+}
+
+// parseACL parses the text form of an ACL, as written by formatACL. As with
+// setfacl(1), the tags can be abbreviated ("u", "g", "m" and "o"), and the
+// permissions can be in any order, with or without "-". Only numeric user
+// and group IDs are accepted.
+func parseACL(s string) ([]aclEntry, error) {
+	var entries []aclEntry
+	for _, part := range strings.Split(s, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ":")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid acl entry %q", part)
+		}
+		var e aclEntry
+		named := fields[1] != ""
+		switch fields[0] {
+		case "user", "u":
+			e.tag = aclUserObj
+			if named {
+				e.tag = aclUser
+			}
+		case "group", "g":
+			e.tag = aclGroupObj
+			if named {
+				e.tag = aclGroup
+			}
+		case "mask", "m":
+			e.tag = aclMask
+		case "other", "o":
+			e.tag = aclOther
+		default:
+			return nil, fmt.Errorf("invalid acl entry %q: unknown tag %q", part, fields[0])
+		}
+		e.id = aclUndefinedID
+		if named {
+			if e.tag != aclUser && e.tag != aclGroup {
+				return nil, fmt.Errorf("invalid acl entry %q: unexpected qualifier", part)
+			}
+			id, err := strconv.ParseUint(fields[1], 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid acl entry %q: qualifier must be a numeric id", part)
+			}
+			e.id = uint32(id)
+		}
+		for _, c := range fields[2] {
+			switch c {
+			case 'r':
+				e.perm |= 0o4
+			case 'w':
+				e.perm |= 0o2
+			case 'x':
+				e.perm |= 0o1
+			case '-':
+			default:
+				return nil, fmt.Errorf("invalid acl entry %q: bad permissions %q", part, fields[2])
+			}
+		}
+		entries = append(entries, e)
+	}
+	sortACL(entries)
+	return entries, nil
+}
+
+// compareACL compares "acl" and "default_acl" values as ACLs, so that the
+// order of the entries and the abbreviations used don't matter.
+func compareACL(old, new KeyVal, opts *CompareOptions) bool {
+	a, errA := parseACL(old.Value())
+	b, errB := parseACL(new.Value())
+	if errA != nil || errB != nil {
+		return old.Value() == new.Value()
+	}
+	return formatACL(a) == formatACL(b)
+}
+
+// aclKeywordFunc returns a KeywordFunc for the ACL stored in the extended
+// attribute name. Files without an ACL (including those whose ACL is
+// equivalent to their mode) have no value. For tar archives, the extended
+// attribute is read from the "SCHILY.xattr." PAX records.
+func aclKeywordFunc(keyword Keyword, name string) KeywordFunc {
+	return func(path string, info os.FileInfo, r io.Reader) ([]KeyVal, error) {
+		var buf []byte
+		if hdr, ok := info.Sys().(*tar.Header); ok {
+			v, ok := hdr.PAXRecords["SCHILY.xattr."+name]
+			if !ok {
+				return nil, nil
+			}
+			buf = []byte(v)
+		} else {
+			if !info.Mode().IsRegular() && !info.IsDir() {
+				return nil, nil
+			}
+			if name == aclDefaultXattr && !info.IsDir() {
+				return nil, nil
+			}
+			// As with xattrKeywordFunc, filesystems without extended
+			// attributes have no ACLs.
+			names, err := xattr.List(path)
+			if err != nil || !slices.Contains(names, name) {
+				return nil, nil
+			}
+			if buf, err = xattr.Get(path, name); err != nil {
+				return nil, fmt.Errorf("%s for %q: %w", keyword, path, err)
+			}
+		}
+		entries, err := decodeACL(buf)
+		if err != nil {
+			return nil, fmt.Errorf("%s for %q: %w", keyword, path, err)
+		}
+		return []KeyVal{KeyVal(fmt.Sprintf("%s=%s", keyword, formatACL(entries)))}, nil
+	}
+}
+
+// aclUpdateKeywordFunc returns an UpdateKeywordFunc which sets the ACL stored
+// in the extended attribute name.
+func aclUpdateKeywordFunc(name string) UpdateKeywordFunc {
+	return func(path string, kv KeyVal) (os.FileInfo, error) {
+		entries, err := parseACL(kv.Value())
+		if err != nil {
+			return nil, err
+		}
+		info, err := os.Lstat(path)
+		if err != nil {
+			return nil, err
+		}
+		// ACLs can't be set on symlinks.
+		if info.Mode()&os.ModeSymlink != 0 {
+			return info, nil
+		}
+		if err := xattr.Set(path, name, encodeACL(entries)); err != nil {
+			return nil, err
+		}
+		return os.Lstat(path)
+	}
+}
@@ -0,0 +1,65 @@
+package mtree
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vbatts/go-mtree/xattr"
+)
+
+func TestACLUpdate(t *testing.T) {
+	// /tmp is often tmpfs, which may not support ACLs.
+	dir, err := os.MkdirTemp(".", "test.acl.")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "file")
+	require.NoError(t, os.WriteFile(file, []byte("data"), 0640))
+	if err := xattr.Set(file, aclAccessXattr, testACLXattr); err != nil {
+		t.Skipf("skipping: %q does not support ACLs: %v", dir, err)
+	}
+	defaultACL := "user::rwx,group::r-x,other::---"
+	if _, err := aclUpdateKeywordFunc(aclDefaultXattr)(dir, KeyVal("default_acl="+defaultACL)); err != nil {
+		t.Skipf("skipping: %q does not support default ACLs: %v", dir, err)
+	}
+
+	dh, err := Walk(dir, nil, []Keyword{"type", "mode", "acl", "default_acl"}, nil)
+	require.NoError(t, err)
+	var found int
+	for _, e := range dh.Entries {
+		switch e.Name {
+		case "file":
+			found++
+			assert.Equal(t, []KeyVal{KeyVal("acl=" + testACLText)}, HasKeyword(e.AllKeys(), "acl"))
+			assert.Empty(t, HasKeyword(e.AllKeys(), "default_acl"))
+		case ".":
+			found++
+			assert.Equal(t, []KeyVal{KeyVal("default_acl=" + defaultACL)}, HasKeyword(e.AllKeys(), "default_acl"))
+		}
+	}
+	require.Equal(t, 2, found)
+
+	res, err := Check(dir, dh, nil, nil)
+	require.NoError(t, err)
+	assert.Empty(t, res)
+
+	// Replace the ACL with a different one, which Update puts back.
+	_, err = aclUpdateKeywordFunc(aclAccessXattr)(file, "acl=u::rw,u:1000:r,g::r,m::r,o::")
+	require.NoError(t, err)
+	res, err = Check(dir, dh, nil, nil)
+	require.NoError(t, err)
+	if assert.Len(t, res, 1) {
+		assert.Equal(t, "file", res[0].Path())
+	}
+
+	res, err = Update(dir, dh, []Keyword{"mode", "acl", "default_acl"}, nil)
+	require.NoError(t, err)
+	assert.Empty(t, res)
+	res, err = Check(dir, dh, nil, nil)
+	require.NoError(t, err)
+	assert.Empty(t, res)
+}
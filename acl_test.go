@@ -0,0 +1,78 @@
+package mtree
+
+import (
+	"archive/tar"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testACLXattr is the system.posix_acl_access value written by
+// "setfacl -m u:1000:rx,g:50:w" on a file with mode 0640.
+var testACLXattr = []byte{
+	0x02, 0x00, 0x00, 0x00, // version
+	0x01, 0x00, 0x06, 0x00, 0xff, 0xff, 0xff, 0xff, // user::rw-
+	0x02, 0x00, 0x05, 0x00, 0xe8, 0x03, 0x00, 0x00, // user:1000:r-x
+	0x04, 0x00, 0x04, 0x00, 0xff, 0xff, 0xff, 0xff, // group::r--
+	0x08, 0x00, 0x02, 0x00, 0x32, 0x00, 0x00, 0x00, // group:50:-w-
+	0x10, 0x00, 0x07, 0x00, 0xff, 0xff, 0xff, 0xff, // mask::rwx
+	0x20, 0x00, 0x00, 0x00, 0xff, 0xff, 0xff, 0xff, // other::---
+}
+
+const testACLText = "user::rw-,user:1000:r-x,group::r--,group:50:-w-,mask::rwx,other::---"
+
+func TestACLEncoding(t *testing.T) {
+	entries, err := decodeACL(testACLXattr)
+	require.NoError(t, err)
+	assert.Equal(t, testACLText, formatACL(entries))
+	assert.Equal(t, testACLXattr, encodeACL(entries))
+
+	parsed, err := parseACL("o::,m::rwx,g:50:w,g::r,u:1000:xr,u::rw")
+	require.NoError(t, err)
+	assert.Equal(t, entries, parsed)
+
+	for _, bad := range [][]byte{
+		nil,
+		testACLXattr[:7],
+		append([]byte{0x01, 0, 0, 0}, testACLXattr[4:]...),
+		append(append([]byte{}, testACLXattr...), 0x40, 0, 0, 0, 0, 0, 0, 0),
+	} {
+		_, err := decodeACL(bad)
+		assert.Error(t, err, "decodeACL(%x)", bad)
+	}
+	for _, bad := range []string{
+		"",
+		"user:rw-",
+		"user:alice:rw-",
+		"mask:1:rwx",
+		"everyone::rwx",
+		"user::rwz",
+	} {
+		_, err := parseACL(bad)
+		assert.Error(t, err, "parseACL(%q)", bad)
+	}
+}
+
+func TestCompareACL(t *testing.T) {
+	assert.True(t, compareACL(KeyVal("acl="+testACLText), "acl=u::rw,g::r,o::,u:1000:rx,g:50:w,m::rwx", &CompareOptions{}))
+	assert.False(t, compareACL(KeyVal("acl="+testACLText), "acl=u::rw,g::r,o::,u:1000:rwx,g:50:w,m::rwx", &CompareOptions{}))
+}
+
+func TestACLKeywordTar(t *testing.T) {
+	hdr := &tar.Header{
+		Name:     "file",
+		Typeflag: tar.TypeReg,
+		Mode:     0o640,
+		PAXRecords: map[string]string{
+			"SCHILY.xattr." + aclAccessXattr: string(testACLXattr),
+		},
+	}
+	kvs, err := aclKeywordFunc("acl", aclAccessXattr)("file", hdr.FileInfo(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, []KeyVal{KeyVal("acl=" + testACLText)}, kvs)
+
+	kvs, err = aclKeywordFunc("default_acl", aclDefaultXattr)("file", hdr.FileInfo(), nil)
+	require.NoError(t, err)
+	assert.Empty(t, kvs)
+}
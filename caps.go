@@ -0,0 +1,328 @@
+package mtree
+
+import (
+	"archive/tar"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/vbatts/go-mtree/xattr"
+)
+
+// capsXattr is the extended attribute in which Linux stores file capabilities.
+const capsXattr = "security.capability"
+
+// The revisions of vfs_cap_data, as in <linux/capability.h>.
+const (
+	vfsCapRevisionMask = 0xff000000
+	vfsCapFlagsMask    = 0x00ffffff
+	vfsCapRevision1    = 0x01000000
+	vfsCapRevision2    = 0x02000000
+	vfsCapRevision3    = 0x03000000
+	vfsCapEffective    = 0x000001
+)
+
+// capNames are the names of the capabilities, indexed by number, as in
+// capabilities(7).
+var capNames = []string{
+	"cap_chown",
+	"cap_dac_override",
+	"cap_dac_read_search",
+	"cap_fowner",
+	"cap_fsetid",
+	"cap_kill",
+	"cap_setgid",
+	"cap_setuid",
+	"cap_setpcap",
+	"cap_linux_immutable",
+	"cap_net_bind_service",
+	"cap_net_broadcast",
+	"cap_net_admin",
+	"cap_net_raw",
+	"cap_ipc_lock",
+	"cap_ipc_owner",
+	"cap_sys_module",
+	"cap_sys_rawio",
+	"cap_sys_chroot",
+	"cap_sys_ptrace",
+	"cap_sys_pacct",
+	"cap_sys_admin",
+	"cap_sys_boot",
+	"cap_sys_nice",
+	"cap_sys_resource",
+	"cap_sys_time",
+	"cap_sys_tty_config",
+	"cap_mknod",
+	"cap_lease",
+	"cap_audit_write",
+	"cap_audit_control",
+	"cap_setfcap",
+	"cap_mac_override",
+	"cap_mac_admin",
+	"cap_syslog",
+	"cap_wake_alarm",
+	"cap_block_suspend",
+	"cap_audit_read",
+	"cap_perfmon",
+	"cap_bpf",
+	"cap_checkpoint_restore",
+}
+
+// fileCaps are the capabilities of a file, from its vfs_cap_data.
+type fileCaps struct {
+	permitted   uint64
+	inheritable uint64
+	effective   bool
+	// rootid is the uid of root in the user namespace which set the
+	// capabilities (only in revision 3). Zero is the same as revision 2.
+	rootid uint32
+}
+
+// decodeCaps decodes the value of a security.capability extended attribute.
+func decodeCaps(buf []byte) (fileCaps, error) {
+	var c fileCaps
+	if len(buf) < 4 {
+		return c, fmt.Errorf("invalid caps: bad length %d", len(buf))
+	}
+	magic := binary.LittleEndian.Uint32(buf)
+	c.effective = magic&vfsCapFlagsMask&vfsCapEffective != 0
+	var words int
+	switch magic & vfsCapRevisionMask {
+	case vfsCapRevision1:
+		if len(buf) != 12 {
+			return c, fmt.Errorf("invalid caps: bad length %d for revision 1", len(buf))
+		}
+		words = 1
+	case vfsCapRevision2:
+		if len(buf) != 20 {
+			return c, fmt.Errorf("invalid caps: bad length %d for revision 2", len(buf))
+		}
+		words = 2
+	case vfsCapRevision3:
+		if len(buf) != 24 {
+			return c, fmt.Errorf("invalid caps: bad length %d for revision 3", len(buf))
+		}
+		words = 2
+		c.rootid = binary.LittleEndian.Uint32(buf[20:])
+	default:
+		return c, fmt.Errorf("invalid caps: unknown revision %#x", magic&vfsCapRevisionMask)
+	}
+	for i := 0; i < words; i++ {
+		c.permitted |= uint64(binary.LittleEndian.Uint32(buf[4+8*i:])) << (32 * i)
+		c.inheritable |= uint64(binary.LittleEndian.Uint32(buf[8+8*i:])) << (32 * i)
+	}
+	return c, nil
+}
+
+// encodeCaps encodes c as the value of a security.capability extended
+// attribute, using revision 2, or revision 3 if c has a rootid.
+func encodeCaps(c fileCaps) []byte {
+	magic := uint32(vfsCapRevision2)
+	if c.rootid != 0 {
+		magic = vfsCapRevision3
+	}
+	if c.effective {
+		magic |= vfsCapEffective
+	}
+	buf := binary.LittleEndian.AppendUint32(nil, magic)
+	for i := 0; i < 2; i++ {
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(c.permitted>>(32*i)))
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(c.inheritable>>(32*i)))
+	}
+	if c.rootid != 0 {
+		buf = binary.LittleEndian.AppendUint32(buf, c.rootid)
+	}
+	return buf
+}
+
+func capName(n int) string {
+	if n < len(capNames) {
+		return capNames[n]
+	}
+	return strconv.Itoa(n)
+}
+
+// formatCaps returns the capabilities in the style of cap_to_text(3), such as
+// "cap_net_admin,cap_net_raw+ep". Capabilities with different flags are in
+// separate clauses, which are separated by ";" (rather than a space, which
+// can't be used in a manifest), and a non-zero rootid is written as a final
+// "rootid=<uid>" clause. A file with no capabilities is "none".
+func formatCaps(c fileCaps) string {
+	groups := map[string][]string{}
+	for n := 0; n < 64; n++ {
+		bit := uint64(1) << n
+		flags := ""
+		if c.effective && (c.permitted|c.inheritable)&bit != 0 {
+			flags += "e"
+		}
+		if c.inheritable&bit != 0 {
+			flags += "i"
+		}
+		if c.permitted&bit != 0 {
+			flags += "p"
+		}
+		if flags != "" {
+			groups[flags] = append(groups[flags], capName(n))
+		}
+	}
+	var clauses []string
+	for flags, names := range groups {
+		clauses = append(clauses, strings.Join(names, ",")+"+"+flags)
+	}
+	sort.Strings(clauses)
+	if c.rootid != 0 {
+		clauses = append(clauses, fmt.Sprintf("rootid=%d", c.rootid))
+	}
+	if len(clauses) == 0 {
+		return "none"
+	}
+	return strings.Join(clauses, ";")
+}
+
+// parseCaps parses capabilities written by formatCaps. Capabilities can be
+// named with or without the "cap_" prefix (in any case), or by number. Since
+// files have a single effective flag, "e" must be given for all or none of the
+// capabilities.
+func parseCaps(s string) (fileCaps, error) {
+	var c fileCaps
+	if s == "none" {
+		return c, nil
+	}
+	var effective, notEffective bool
+	for _, clause := range strings.Split(s, ";") {
+		if v, ok := strings.CutPrefix(clause, "rootid="); ok {
+			id, err := strconv.ParseUint(v, 10, 32)
+			if err != nil {
+				return c, fmt.Errorf("invalid caps %q: bad rootid %q", s, v)
+			}
+			c.rootid = uint32(id)
+			continue
+		}
+		names, flags, ok := strings.Cut(clause, "+")
+		if !ok || names == "" {
+			return c, fmt.Errorf("invalid caps %q: expected <caps>+<flags> in %q", s, clause)
+		}
+		var bits uint64
+		for _, name := range strings.Split(names, ",") {
+			n, err := parseCapName(name)
+			if err != nil {
+				return c, fmt.Errorf("invalid caps %q: %w", s, err)
+			}
+			bits |= uint64(1) << n
+		}
+		var e bool
+		for _, f := range flags {
+			switch f {
+			case 'e':
+				e = true
+			case 'i':
+				c.inheritable |= bits
+			case 'p':
+				c.permitted |= bits
+			default:
+				return c, fmt.Errorf("invalid caps %q: unknown flag %q", s, f)
+			}
+		}
+		if e {
+			effective = true
+		} else {
+			notEffective = true
+		}
+	}
+	if effective && notEffective {
+		return c, fmt.Errorf("invalid caps %q: the effective flag must be set for all capabilities or none", s)
+	}
+	c.effective = effective
+	return c, nil
+}
+
+func parseCapName(name string) (int, error) {
+	name = strings.ToLower(name)
+	if n, err := strconv.Atoi(name); err == nil && n >= 0 && n < 64 {
+		return n, nil
+	}
+	if !strings.HasPrefix(name, "cap_") {
+		name = "cap_" + name
+	}
+	if n := slices.Index(capNames, name); n >= 0 {
+		return n, nil
+	}
+	return -1, fmt.Errorf("unknown capability %q", name)
+}
+
+// compareCaps compares "caps" values by the capabilities they grant, so that
+// the order of the capabilities and clauses doesn't matter.
+func compareCaps(old, new KeyVal, opts *CompareOptions) bool {
+	a, errA := parseCaps(old.Value())
+	b, errB := parseCaps(new.Value())
+	if errA != nil || errB != nil {
+		return old.Value() == new.Value()
+	}
+	return a == b
+}
+
+// capsKeywordFunc returns the file capabilities of regular files. Files
+// without capabilities have no value. For tar archives, the extended attribute
+// is read from the "SCHILY.xattr." PAX records.
+func capsKeywordFunc(path string, info os.FileInfo, r io.Reader) ([]KeyVal, error) {
+	var buf []byte
+	if hdr, ok := info.Sys().(*tar.Header); ok {
+		v, ok := hdr.PAXRecords["SCHILY.xattr."+capsXattr]
+		if !ok {
+			return nil, nil
+		}
+		buf = []byte(v)
+	} else {
+		if !info.Mode().IsRegular() {
+			return nil, nil
+		}
+		names, err := xattr.List(path)
+		if err != nil || !slices.Contains(names, capsXattr) {
+			return nil, nil
+		}
+		if buf, err = xattr.Get(path, capsXattr); err != nil {
+			return nil, fmt.Errorf("caps for %q: %w", path, err)
+		}
+	}
+	c, err := decodeCaps(buf)
+	if err != nil {
+		return nil, fmt.Errorf("caps for %q: %w", path, err)
+	}
+	return []KeyVal{KeyVal("caps=" + formatCaps(c))}, nil
+}
+
+// capsUpdateKeywordFunc sets the file capabilities of a regular file. As
+// changing the owner of a file clears its capabilities, Update sets them after
+// the other keywords.
+func capsUpdateKeywordFunc(path string, kv KeyVal) (os.FileInfo, error) {
+	c, err := parseCaps(kv.Value())
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return info, nil
+	}
+	if c == (fileCaps{}) {
+		names, err := xattr.List(path)
+		if err != nil || !slices.Contains(names, capsXattr) {
+			return info, nil
+		}
+		if err := xattr.Remove(path, capsXattr); err != nil {
+			return nil, err
+		}
+		return os.Lstat(path)
+	}
+	if err := xattr.Set(path, capsXattr, encodeCaps(c)); err != nil {
+		return nil, err
+	}
+	return os.Lstat(path)
+}
@@ -0,0 +1,61 @@
+package mtree
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vbatts/go-mtree/xattr"
+)
+
+func TestCapsUpdate(t *testing.T) {
+	// /tmp is often tmpfs, which may not support security xattrs.
+	dir, err := os.MkdirTemp(".", "test.caps.")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "ping")
+	require.NoError(t, os.WriteFile(file, []byte("#!/bin/true\n"), 0755))
+	if err := xattr.Set(file, capsXattr, testCapsV3); err != nil {
+		t.Skipf("skipping: cannot set file capabilities in %q: %v", dir, err)
+	}
+
+	dh, err := Walk(dir, nil, []Keyword{"type", "uid", "caps"}, nil)
+	require.NoError(t, err)
+	var found bool
+	for _, e := range dh.Entries {
+		if e.Name == "ping" {
+			found = true
+			assert.Equal(t, []KeyVal{"caps=cap_net_raw+ep"}, HasKeyword(e.AllKeys(), "caps"))
+		}
+	}
+	require.True(t, found)
+
+	// Changing the owner clears the capabilities, so they must be restored
+	// after the uid.
+	require.NoError(t, xattr.Remove(file, capsXattr))
+	res, err := Check(dir, dh, nil, nil)
+	require.NoError(t, err)
+	assert.NotEmpty(t, res)
+
+	res, err = Update(dir, dh, []Keyword{"uid", "caps"}, nil)
+	require.NoError(t, err)
+	assert.Empty(t, res)
+	res, err = Check(dir, dh, nil, nil)
+	require.NoError(t, err)
+	assert.Empty(t, res)
+
+	// "none" removes the capabilities.
+	dh, err = ParseSpec(strings.NewReader(". type=dir\n    ping type=file caps=none\n..\n"))
+	require.NoError(t, err)
+	res, err = Update(dir, dh, []Keyword{"caps"}, nil)
+	require.NoError(t, err)
+	assert.Empty(t, res)
+	names, err := xattr.List(file)
+	require.NoError(t, err)
+	assert.NotContains(t, names, capsXattr)
+}
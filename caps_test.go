@@ -0,0 +1,108 @@
+package mtree
+
+import (
+	"archive/tar"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	// The security.capability of "setcap cap_net_raw+ep", in revision 2.
+	testCapsV2 = []byte{
+		0x01, 0x00, 0x00, 0x02,
+		0x00, 0x20, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	// The same capabilities in revision 3, with a rootid of 0.
+	testCapsV3 = []byte{
+		0x01, 0x00, 0x00, 0x03,
+		0x00, 0x20, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00,
+	}
+)
+
+func TestCapsEncoding(t *testing.T) {
+	v2, err := decodeCaps(testCapsV2)
+	require.NoError(t, err)
+	assert.Equal(t, "cap_net_raw+ep", formatCaps(v2))
+	assert.Equal(t, testCapsV2, encodeCaps(v2))
+
+	v3, err := decodeCaps(testCapsV3)
+	require.NoError(t, err)
+	assert.Equal(t, v2, v3, "revision 3 with rootid 0 is the same as revision 2")
+
+	v1, err := decodeCaps([]byte{0x00, 0x00, 0x00, 0x01, 0x00, 0x20, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
+	require.NoError(t, err)
+	assert.Equal(t, "cap_net_raw+p", formatCaps(v1))
+
+	ns := fileCaps{permitted: 1 << 13, effective: true, rootid: 100000}
+	assert.Equal(t, "cap_net_raw+ep;rootid=100000", formatCaps(ns))
+	decoded, err := decodeCaps(encodeCaps(ns))
+	require.NoError(t, err)
+	assert.Equal(t, ns, decoded)
+
+	for _, bad := range [][]byte{
+		nil,
+		testCapsV2[:16],
+		testCapsV3[:20],
+		append([]byte{0x00, 0x00, 0x00, 0x04}, testCapsV2[4:]...),
+	} {
+		_, err := decodeCaps(bad)
+		assert.Error(t, err, "decodeCaps(%x)", bad)
+	}
+}
+
+func TestCapsText(t *testing.T) {
+	c := fileCaps{
+		permitted:   1<<12 | 1<<13 | 1<<21 | 1<<40 | 1<<50,
+		inheritable: 1 << 21,
+	}
+	text := formatCaps(c)
+	assert.Equal(t, "cap_net_admin,cap_net_raw,cap_checkpoint_restore,50+p;cap_sys_admin+ip", text)
+	parsed, err := parseCaps(text)
+	require.NoError(t, err)
+	assert.Equal(t, c, parsed)
+
+	parsed, err = parseCaps("CAP_SYS_ADMIN+pi;net_raw,12,checkpoint_restore,50+p")
+	require.NoError(t, err)
+	assert.Equal(t, c, parsed)
+
+	parsed, err = parseCaps("none")
+	require.NoError(t, err)
+	assert.Equal(t, fileCaps{}, parsed)
+	assert.Equal(t, "none", formatCaps(fileCaps{}))
+
+	for _, bad := range []string{
+		"",
+		"cap_net_raw",
+		"cap_bogus+ep",
+		"cap_net_raw+epx",
+		"cap_net_raw+ep;cap_chown+p",
+		"cap_net_raw+ep;rootid=-1",
+		"64+p",
+	} {
+		_, err := parseCaps(bad)
+		assert.Error(t, err, "parseCaps(%q)", bad)
+	}
+
+	assert.True(t, compareCaps("caps=cap_net_raw,cap_net_admin+ep", "caps=net_admin,net_raw+pe", &CompareOptions{}))
+	assert.False(t, compareCaps("caps=cap_net_raw+ep", "caps=cap_net_raw+p", &CompareOptions{}))
+	assert.False(t, compareCaps("caps=cap_net_raw+ep", "caps=cap_net_raw+ep;rootid=1000", &CompareOptions{}))
+}
+
+func TestCapsKeywordTar(t *testing.T) {
+	hdr := &tar.Header{
+		Name:     "ping",
+		Typeflag: tar.TypeReg,
+		Mode:     0o755,
+		PAXRecords: map[string]string{
+			"SCHILY.xattr." + capsXattr: string(testCapsV3),
+		},
+	}
+	kvs, err := capsKeywordFunc("ping", hdr.FileInfo(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, []KeyVal{"caps=cap_net_raw+ep"}, kvs)
+}
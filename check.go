@@ -1,5 +1,7 @@
 package mtree
 
+import "time"
+
 // Check a root directory path against the DirectoryHierarchy, regarding only
 // the available keywords from the list and each entry in the hierarchy.
 // If keywords is nil, the check all present in the DirectoryHierarchy
@@ -7,14 +9,79 @@ package mtree
 // This is equivalent to creating a new DirectoryHierarchy with Walk(root, nil,
 // keywords, fs) and then doing a Compare(dh, newDh, keywords).
 func Check(root string, dh *DirectoryHierarchy, keywords []Keyword, fs FsEval) ([]InodeDelta, error) {
+	return CheckWithOptions(root, dh, &CheckOptions{
+		Keywords: keywords,
+		FsEval:   fs,
+	})
+}
+
+// CheckOptions are the parameters for CheckWithOptions.
+type CheckOptions struct {
+	// Keywords to check. If nil, then all keywords present in the
+	// DirectoryHierarchy are checked.
+	Keywords []Keyword
+
+	// FsEval is the interface to use in evaluating files. If nil, then
+	// DefaultFsEval is used.
+	FsEval FsEval
+
+	// Rules adjust Keywords for the paths they match, both when walking root
+	// and when comparing it to the DirectoryHierarchy.
+	Rules KeywordRules
+
+	// Registry is used for both walking root and comparing it to the
+	// DirectoryHierarchy. If nil, then DefaultKeywordRegistry is used.
+	Registry *KeywordRegistry
+
+	// IDResolver is used to find the names for the "uname" and "gname"
+	// keywords when walking root. If nil, the host's names are used.
+	IDResolver IDResolver
+
+	// TimePrecision and TimeTolerance are as for CompareOptions.
+	TimePrecision time.Duration
+	TimeTolerance time.Duration
+
+	// UIDMap and GIDMap are as for CompareOptions, translating the "uid" and
+	// "gid" values of the DirectoryHierarchy to the IDs used on disk.
+	UIDMap IDMap
+	GIDMap IDMap
+}
+
+// CheckWithOptions is like Check, but takes its parameters from opts. A nil
+// opts is treated the same as a zero CheckOptions.
+func CheckWithOptions(root string, dh *DirectoryHierarchy, opts *CheckOptions) ([]InodeDelta, error) {
+	if opts == nil {
+		opts = &CheckOptions{}
+	}
+	keywords := opts.Keywords
 	if keywords == nil {
 		keywords = dh.UsedKeywords()
+		if opts.Rules != nil {
+			var err error
+			if keywords, err = opts.Rules.BaseKeywords(dh); err != nil {
+				return nil, err
+			}
+		}
 	}
 
-	newDh, err := Walk(root, nil, keywords, fs)
+	newDh, err := WalkWithOptions(root, &WalkOptions{
+		Keywords:   keywords,
+		FsEval:     opts.FsEval,
+		Rules:      opts.Rules,
+		Registry:   opts.Registry,
+		IDResolver: opts.IDResolver,
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	return Compare(dh, newDh, keywords)
+	return CompareWithOptions(dh, newDh, &CompareOptions{
+		Keywords:      keywords,
+		Rules:         opts.Rules,
+		Registry:      opts.Registry,
+		TimePrecision: opts.TimePrecision,
+		TimeTolerance: opts.TimeTolerance,
+		UIDMap:        opts.UIDMap,
+		GIDMap:        opts.GIDMap,
+	})
 }
@@ -1,16 +1,17 @@
 package cmd
 
 import (
-	"bufio"
 	"bytes"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"iter"
 	"os"
-	"path/filepath"
 	"slices"
 	"strings"
+	"time"
 
 	cli "github.com/urfave/cli/v2"
 	"github.com/vbatts/go-mtree"
@@ -50,12 +51,12 @@ func NewValidateCommand() *cli.Command {
 			&cli.StringFlag{
 				Name:    "use-keywords",
 				Aliases: []string{"k"},
-				Usage:   "Use only the specified (delimited by comma or space) keywords as the current set of keywords",
+				Usage:   "Use only the specified (delimited by comma or space) keywords as the current set of keywords. Keywords such as 'xattr.user.*' select a namespace of extended attributes.",
 			},
 			&cli.StringFlag{
 				Name:    "remove-keywords",
 				Aliases: []string{"R"},
-				Usage:   "Remove the specified (delimited by comma or space) keywords from the current set of keywords. If 'all' is specified, remove all keywords.",
+				Usage:   "Remove the specified (delimited by comma or space) keywords from the current set of keywords. If 'all' is specified, remove all keywords. Keywords such as 'xattr.security.*' remove a namespace of extended attributes.",
 			},
 			&cli.BoolFlag{
 				Name:    "directory-only",
@@ -75,7 +76,17 @@ func NewValidateCommand() *cli.Command {
 			&cli.StringFlag{
 				Name:    "exclude-file",
 				Aliases: []string{"X"},
-				Usage:   "File containing fnmatch(3) patterns of paths to exclude, one per line. Patterns with '/' are matched against the full relative path; others against the basename only. Lines starting with '#' are comments.",
+				Usage:   "File containing gitignore(5) patterns of paths to exclude, one per line. Patterns with a '/' are anchored to the root of the hierarchy; others match at any depth. Lines starting with '#' are comments.",
+			},
+			&cli.StringSliceFlag{
+				Name:  "exclude-per-directory",
+				Usage: "Read additional gitignore(5) patterns from files with this name (such as .mtreeignore) in each directory of the hierarchy",
+			},
+			&cli.StringFlag{
+				Name:      "dbdir",
+				Aliases:   []string{"N"},
+				TakesFile: true,
+				Usage:     "Use the passwd and group files in this directory (such as <root>/etc) instead of the host's to map user and group names, including for '-u'",
 			},
 			&cli.BoolFlag{
 				Name:    "update-attributes",
@@ -106,12 +117,85 @@ func NewValidateCommand() *cli.Command {
 			&cli.StringFlag{
 				Name:  "result-format",
 				Value: "bsd",
-				Usage: "output the validation results/errors using the given format (bsd, json, path)",
+				Usage: "output the validation results/errors using the given format (bsd, json, path, summary)",
+			},
+			&cli.IntFlag{
+				Name:  "summary-depth",
+				Value: defaultSummaryDepth,
+				Usage: "Number of leading path components of the directories which --result-format=summary groups results by (0 for the parent directory of each path)",
 			},
 			&cli.BoolFlag{
 				Name:  "strict",
 				Usage: "enable strict validation of manifests (any discrepancy will result in an error)",
 			},
+			&cli.StringFlag{
+				Name:      "rules",
+				TakesFile: true,
+				Usage:     "File of per-path keyword rules, one per line: a path glob followed by keywords to add ('+kw') or remove ('-kw')",
+			},
+			&cli.StringFlag{
+				Name:  "time-precision",
+				Usage: "Only compare times to this precision (ns, us, ms, s, or a duration such as 2s for FAT)",
+			},
+			&cli.BoolFlag{
+				Name:  "symbolic-modes",
+				Usage: "When creating a manifest, write mode values in chmod(1) symbolic form (u=rwx,go=rx) instead of octal",
+			},
+			&cli.DurationFlag{
+				Name:  "time-tolerance",
+				Usage: "Treat times which differ by at most this duration (such as 2s) as equal",
+			},
+			&cli.StringFlag{
+				Name:  "uidmap",
+				Usage: "Translate the uids of the spec with this user namespace mapping before comparing or updating: either a uid_map file (such as /proc/self/uid_map) or inline 'container:host:size' ranges separated by commas",
+			},
+			&cli.StringFlag{
+				Name:  "gidmap",
+				Usage: "As for --uidmap, but for gids",
+			},
+			&cli.StringFlag{
+				Name:      "selinux-contexts",
+				TakesFile: true,
+				Usage:     "Also check that the SELinux label of each path under '-p' matches this file_contexts(5) policy, treating '-p' as '/'. Without '-f', only the labels are checked, and no spec is read from stdin.",
+			},
+			&cli.StringFlag{
+				Name:  "dirhash-prefix",
+				Usage: "Prefix joined to the file names in 'dirhash' values, such as 'example.com/mod@v1.0.0' to match the module hash in go.sum",
+			},
+			&cli.IntFlag{
+				Name:  "fsverity-block-size",
+				Value: mtree.DefaultFsverityParams.BlockSize,
+				Usage: "Block size of the Merkle tree for the 'fsverity' keyword, as given to 'fsverity enable'",
+			},
+			&cli.StringFlag{
+				Name:  "fsverity-salt",
+				Usage: "Salt (in hex) of the Merkle tree for the 'fsverity' keyword, as given to 'fsverity enable'",
+			},
+			&cli.StringSliceFlag{
+				Name:  "exec",
+				Usage: "Define a keyword whose value is the output of an external command, as 'exec.<name>=/path/to/tool'. The command gets the contents of each file on stdin, and its path and attributes in MTREE_* environment variables. The keyword must also be selected, such as with '-K exec.<name>'.",
+			},
+			&cli.DurationFlag{
+				Name:  "exec-timeout",
+				Value: mtree.DefaultExecTimeout,
+				Usage: "How long the command of an 'exec.<name>' keyword may run for each file",
+			},
+			&cli.IntFlag{
+				Name:  "exec-jobs",
+				Usage: "The most commands of 'exec.<name>' keywords which may run at once (default: the number of CPUs)",
+			},
+			&cli.BoolFlag{
+				Name:  "trust-dirdigests",
+				Usage: "Skip the contents of directories whose 'dirdigest' is unchanged. Only names, types and contents are covered by the digest, so other changes beneath them are not reported.",
+			},
+			&cli.BoolFlag{
+				Name:  "detect-moves",
+				Usage: "Report missing and extra paths which are the same file (by digest, or by type, size and link) as moved",
+			},
+			&cli.BoolFlag{
+				Name:  "stream",
+				Usage: "Compare the two manifests given with -f by streaming them in path order, using bounded memory. All keywords in either manifest are compared, unless -k, -K or -R are given",
+			},
 		},
 	}
 }
@@ -122,13 +206,20 @@ func validateAction(c *cli.Context) error {
 	// -list-keywords
 	if c.Bool("list-keywords") {
 		fmt.Println("Available keywords:")
-		for k := range mtree.KeywordFuncs {
+		for _, k := range mtree.DefaultKeywordRegistry.Keywords() {
+			spec, _ := mtree.DefaultKeywordRegistry.Lookup(k)
+			if spec.Collect == nil {
+				continue
+			}
 			fmt.Print(" ")
 			fmt.Print(k)
-			if mtree.Keyword(k).Default() {
+			if len(spec.Synonyms) > 0 {
+				fmt.Printf(" (synonyms: %s)", strings.Join(mtree.FromKeywords(spec.Synonyms), ", "))
+			}
+			if spec.IsDefault {
 				fmt.Print(" (default)")
 			}
-			if !mtree.Keyword(k).Bsd() {
+			if !spec.IsBsd {
 				fmt.Print(" (not upstream)")
 			}
 			fmt.Print("\n")
@@ -136,8 +227,9 @@ func validateAction(c *cli.Context) error {
 		return nil
 	}
 
-	// --result-format
-	formatFunc, ok := formats[c.String("result-format")]
+	// --result-format, --summary-depth
+	summaryDepth := c.Int("summary-depth")
+	formatFunc, ok := resultFormat(c.String("result-format"), summaryDepth)
 	if !ok {
 		return fmt.Errorf("invalid output format: %s", c.String("result-format"))
 	}
@@ -180,6 +272,13 @@ func validateAction(c *cli.Context) error {
 			tmpKeywords = slices.DeleteFunc(tmpKeywords, func(kw mtree.Keyword) bool {
 				return mtree.InKeywordSlice(kw, removeKws)
 			})
+			// Keywords with a suffix, such as "xattr.security.*", remove
+			// only part of a keyword, so are negated selectors instead.
+			for _, kw := range removeKws {
+				if kw != kw.Prefix() {
+					tmpKeywords = append(tmpKeywords, "!"+kw)
+				}
+			}
 		}
 	}
 
@@ -202,6 +301,140 @@ func validateAction(c *cli.Context) error {
 		return fmt.Errorf("tar_time and time are mutually exclusive keywords")
 	}
 
+	// --rules <file>
+	var rules mtree.KeywordRules
+	if c.String("rules") != "" {
+		fh, err := os.Open(c.String("rules"))
+		if err != nil {
+			return err
+		}
+		rules, err = mtree.ParseKeywordRules(fh)
+		fh.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", c.String("rules"), err)
+		}
+	}
+
+	// --time-precision <precision>
+	var timePrecision time.Duration
+	if c.String("time-precision") != "" {
+		timePrecision, err = mtree.ParseTimePrecision(c.String("time-precision"))
+		if err != nil {
+			return err
+		}
+	}
+
+	// --time-tolerance <duration>
+	timeTolerance := c.Duration("time-tolerance")
+	if timeTolerance < 0 {
+		return fmt.Errorf("--time-tolerance must not be negative")
+	}
+
+	// --uidmap, --gidmap
+	uidMap, err := readIDMapArg(c.String("uidmap"))
+	if err != nil {
+		return fmt.Errorf("--uidmap: %w", err)
+	}
+	gidMap, err := readIDMapArg(c.String("gidmap"))
+	if err != nil {
+		return fmt.Errorf("--gidmap: %w", err)
+	}
+
+	// -N <dbdir>
+	var idResolver mtree.IDResolver
+	if c.String("dbdir") != "" {
+		idResolver = mtree.NewDBIDResolver(c.String("dbdir"))
+	}
+
+	// --fsverity-block-size, --fsverity-salt
+	var registry *mtree.KeywordRegistry
+	if c.IsSet("fsverity-block-size") || c.IsSet("fsverity-salt") {
+		salt, err := hex.DecodeString(c.String("fsverity-salt"))
+		if err != nil {
+			return fmt.Errorf("invalid --fsverity-salt: %w", err)
+		}
+		registry = mtree.DefaultKeywordRegistry.Clone()
+		spec, _ := registry.Lookup("fsverity")
+		spec.Collect = mtree.FsverityKeywordFunc(mtree.FsverityParams{
+			BlockSize: c.Int("fsverity-block-size"),
+			Salt:      salt,
+		})
+		if err := registry.Register(spec); err != nil {
+			return err
+		}
+	}
+
+	// --exec
+	if execDefs := c.StringSlice("exec"); len(execDefs) > 0 {
+		specs, err := mtree.ExecKeywordSpecs(execDefs, mtree.ExecOptions{
+			Timeout:       c.Duration("exec-timeout"),
+			MaxConcurrent: c.Int("exec-jobs"),
+		})
+		if err != nil {
+			return err
+		}
+		if registry == nil {
+			registry = mtree.DefaultKeywordRegistry.Clone()
+		}
+		for _, spec := range specs {
+			if err := registry.Register(spec); err != nil {
+				return err
+			}
+		}
+	}
+
+	// --stream
+	if c.Bool("stream") {
+		if len(c.StringSlice("file")) != 2 || c.Bool("create") {
+			return fmt.Errorf("--stream requires two manifests given with -f")
+		}
+		// Without reading the whole of the first manifest, we don't know
+		// which keywords it uses, so all of them are compared unless the user
+		// chose some.
+		var keywords []mtree.Keyword
+		if c.String("use-keywords") != "" || c.String("add-keywords") != "" || c.String("remove-keywords") != "" {
+			keywords = currentKeywords
+		}
+		oldFh, err := os.Open(c.StringSlice("file")[0])
+		if err != nil {
+			return err
+		}
+		defer oldFh.Close()
+		newFh, err := os.Open(c.StringSlice("file")[1])
+		if err != nil {
+			return err
+		}
+		defer newFh.Close()
+		res := mtree.CompareStreams(oldFh, newFh, &mtree.CompareOptions{
+			Keywords:        keywords,
+			Rules:           rules,
+			TimePrecision:   timePrecision,
+			TimeTolerance:   timeTolerance,
+			UIDMap:          uidMap,
+			GIDMap:          gidMap,
+			TrustDirDigests: c.Bool("trust-dirdigests"),
+			DetectMoves:     c.Bool("detect-moves"),
+			Registry:        registry,
+		})
+
+		var filters []deltaFilterFn
+		if !c.Bool("strict") {
+			filters = append(filters, freebsdCompatKeywordFilter)
+		}
+		// -e
+		if c.Bool("ignore-extra") {
+			filters = append(filters, func(d *mtree.InodeDelta) bool {
+				return d.Type() != mtree.Extra
+			})
+		}
+		emit, finish := streamFormat(os.Stdout, c.String("result-format"), c.Bool("strict"), summaryDepth)
+		err = streamResults(res, c.Bool("strict"), emit, filters...)
+		if finishErr := finish(); finishErr != nil {
+			return finishErr
+		}
+		return err
+	}
+
 	// If we're doing a comparison, we always are comparing between a spec and
 	// state DH. If specDh is nil, we are generating a new one.
 	var (
@@ -226,6 +459,12 @@ func validateAction(c *cli.Context) error {
 		// We can't check against more fields than in the specKeywords list, so
 		// currentKeywords can only have a subset of specKeywords.
 		specKeywords = specDh.UsedKeywords()
+		if rules != nil {
+			specKeywords, err = rules.BaseKeywords(specDh)
+			if err != nil {
+				return err
+			}
+		}
 	}
 
 	// -list-used
@@ -249,7 +488,7 @@ func validateAction(c *cli.Context) error {
 				fmt.Printf("Keywords used in [%s]:\n", file)
 				for _, kw := range specKeywords {
 					fmt.Printf(" %s", kw)
-					if _, ok := mtree.KeywordFuncs[kw]; !ok {
+					if spec, ok := mtree.DefaultKeywordRegistry.Lookup(kw); !ok || spec.Collect == nil {
 						fmt.Print(" (unsupported)")
 					}
 					fmt.Printf("\n")
@@ -293,12 +532,26 @@ func validateAction(c *cli.Context) error {
 	}
 
 	// -X <exclude-file>
-	if c.String("exclude-file") != "" {
-		patterns, err := readExcludePatterns(c.String("exclude-file"))
-		if err != nil {
-			return err
+	if c.String("exclude-file") != "" || len(c.StringSlice("exclude-per-directory")) > 0 {
+		var patterns []string
+		if c.String("exclude-file") != "" {
+			fh, err := os.Open(c.String("exclude-file"))
+			if err != nil {
+				return err
+			}
+			patterns, err = mtree.ReadExcludePatterns(fh)
+			fh.Close()
+			if err != nil {
+				return err
+			}
 		}
-		excludes = append(excludes, excludeByPatterns(rootPath, patterns))
+		// Per-directory ignore files are only read from the filesystem, so
+		// there's nothing to read them from when validating a tar archive.
+		excludeRoot := rootPath
+		if c.String("tar") != "" {
+			excludeRoot = ""
+		}
+		excludes = append(excludes, mtree.NewPatternExcluder(excludeRoot, patterns, c.StringSlice("exclude-per-directory")...))
 	}
 
 	// -C: dump spec with full paths
@@ -349,7 +602,11 @@ func validateAction(c *cli.Context) error {
 			defer fh.Close()
 			input = fh
 		}
-		ts := mtree.NewTarStreamer(input, excludes, currentKeywords)
+		ts := mtree.NewTarStreamerWithOptions(input, &mtree.TarStreamerOptions{
+			Excludes: excludes,
+			Keywords: currentKeywords,
+			Registry: registry,
+		})
 
 		if _, err := io.Copy(io.Discard, ts); err != nil && err != io.EOF {
 			return err
@@ -375,7 +632,14 @@ func validateAction(c *cli.Context) error {
 		}
 	} else {
 		// with a root directory
-		stateDh, err = mtree.Walk(rootPath, excludes, currentKeywords, nil)
+		stateDh, err = mtree.WalkWithOptions(rootPath, &mtree.WalkOptions{
+			Excludes:      excludes,
+			Keywords:      currentKeywords,
+			Rules:         rules,
+			IDResolver:    idResolver,
+			Registry:      registry,
+			DirHashPrefix: c.String("dirhash-prefix"),
+		})
 		if err != nil {
 			return err
 		}
@@ -385,7 +649,22 @@ func validateAction(c *cli.Context) error {
 	if c.Bool("update-attributes") && stateDh != nil {
 		// -u
 		// this comes before the next case, intentionally.
-		result, err := mtree.Update(rootPath, specDh, mtree.DefaultUpdateKeywords, nil)
+		updateKeywords := mtree.DefaultUpdateKeywords
+		if idResolver != nil {
+			// With -N, the owners can also be set from the names in the spec.
+			updateKeywords = slices.Clone(updateKeywords)
+			for _, kw := range []mtree.Keyword{"uname", "gname"} {
+				if mtree.InKeywordSlice(kw, specDh.UsedKeywords()) {
+					updateKeywords = append(updateKeywords, kw)
+				}
+			}
+		}
+		result, err := mtree.UpdateWithOptions(rootPath, specDh, &mtree.UpdateOptions{
+			Keywords:   updateKeywords,
+			IDResolver: idResolver,
+			UIDMap:     uidMap,
+			GIDMap:     gidMap,
+		})
 		if err != nil {
 			return err
 		}
@@ -395,7 +674,15 @@ func validateAction(c *cli.Context) error {
 
 		var res []mtree.InodeDelta
 		// only check the keywords that we just updated
-		res, err = mtree.Check(rootPath, specDh, mtree.DefaultUpdateKeywords, nil)
+		res, err = mtree.CheckWithOptions(rootPath, specDh, &mtree.CheckOptions{
+			Keywords:      updateKeywords,
+			Rules:         rules,
+			IDResolver:    idResolver,
+			TimePrecision: timePrecision,
+			TimeTolerance: timeTolerance,
+			UIDMap:        uidMap,
+			GIDMap:        gidMap,
+		})
 		if err != nil {
 			return err
 		}
@@ -425,10 +712,48 @@ func validateAction(c *cli.Context) error {
 		}
 
 		// output stateDh
-		_, err = stateDh.WriteTo(fh)
+		_, err = stateDh.WriteToWithOptions(fh, &mtree.WriteOptions{
+			SymbolicModes: c.Bool("symbolic-modes"),
+		})
 		return err
 	}
 
+	// --selinux-contexts <file_contexts>
+	var selinuxRes []mtree.InodeDelta
+	if c.String("selinux-contexts") != "" {
+		if c.String("tar") != "" {
+			return fmt.Errorf("--selinux-contexts is not compatible with '-T'")
+		}
+		fh, err := os.Open(c.String("selinux-contexts"))
+		if err != nil {
+			return err
+		}
+		contexts, err := mtree.ParseSELinuxContexts(fh)
+		fh.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", c.String("selinux-contexts"), err)
+		}
+		selinuxRes, err = mtree.CheckSELinuxContexts(rootPath, contexts, &mtree.WalkOptions{
+			Excludes: excludes,
+		})
+		if err != nil {
+			return err
+		}
+
+		// Without a spec, only the labels are being checked (rather than
+		// reading the spec from stdin).
+		if specDh == nil {
+			if len(selinuxRes) > 0 {
+				out := formatFunc(selinuxRes, c.Bool("strict"))
+				if _, err := os.Stdout.Write([]byte(out)); err != nil {
+					return err
+				}
+				return errValidate
+			}
+			return nil
+		}
+	}
+
 	// no spec manifest has been provided yet, so look for it on stdin
 	if specDh == nil {
 		// load the hierarchy
@@ -446,7 +771,17 @@ func validateAction(c *cli.Context) error {
 	// This is a validation.
 	if specDh != nil && stateDh != nil {
 		var res []mtree.InodeDelta
-		res, err = mtree.Compare(specDh, stateDh, currentKeywords)
+		res, err = mtree.CompareWithOptions(specDh, stateDh, &mtree.CompareOptions{
+			Keywords:        currentKeywords,
+			Rules:           rules,
+			TimePrecision:   timePrecision,
+			TimeTolerance:   timeTolerance,
+			UIDMap:          uidMap,
+			GIDMap:          gidMap,
+			TrustDirDigests: c.Bool("trust-dirdigests"),
+			DetectMoves:     c.Bool("detect-moves"),
+			Registry:        registry,
+		})
 		if err != nil {
 			return err
 		}
@@ -465,6 +800,11 @@ func validateAction(c *cli.Context) error {
 				return d.Type() != mtree.Extra
 			})
 		}
+		// The SELinux labels are filtered and ordered along with the spec.
+		if len(selinuxRes) > 0 {
+			res = append(res, selinuxRes...)
+			mtree.SortInodeDeltas(res)
+		}
 		res = filterDeltas(res, filters...)
 
 		if len(res) > 0 {
@@ -515,6 +855,33 @@ var formats = map[string]func([]mtree.InodeDelta, bool) string{
 	},
 }
 
+// resultFormat returns the function which formats results in the given
+// --result-format, with summaries grouped by the first summaryDepth components
+// of their paths.
+func resultFormat(format string, summaryDepth int) (func([]mtree.InodeDelta, bool) string, bool) {
+	if format == "summary" {
+		// Outputs the number of results of each type and keyword, and the
+		// size of the files affected, for each directory.
+		return func(d []mtree.InodeDelta, strict bool) string {
+			return formatSummaries(mtree.Summarize(d, summaryDepth))
+		}, true
+	}
+	formatFunc, ok := formats[format]
+	return formatFunc, ok
+}
+
+// defaultSummaryDepth is the default of --summary-depth.
+const defaultSummaryDepth = 2
+
+// formatSummaries returns the output of --result-format=summary.
+func formatSummaries(summaries []mtree.DeltaSummary) string {
+	var buffer bytes.Buffer
+	for _, summary := range summaries {
+		fmt.Fprintln(&buffer, summary)
+	}
+	return buffer.String()
+}
+
 // isDirEntry returns wheter an mtree.Entry describes a directory.
 func isDirEntry(e mtree.Entry) bool {
 	for _, kw := range e.Keywords {
@@ -576,23 +943,101 @@ type deltaFilterFn func(*mtree.InodeDelta) bool
 // given set of filters to it.
 func filterDeltas(deltas []mtree.InodeDelta, filters ...deltaFilterFn) []mtree.InodeDelta {
 	filtered := make([]mtree.InodeDelta, 0, len(deltas))
-next:
 	for _, delta := range deltas {
-		for _, filter := range filters {
-			if !filter(&delta) {
-				continue next
-			}
-		}
-		// Some filters might modify the entry to remove keyword deltas --
-		// if there are no deltas left then we should skip the entry entirely.
-		if delta.Type() == mtree.Modified && len(delta.Diff()) == 0 {
-			continue next
+		if keepDelta(&delta, filters...) {
+			filtered = append(filtered, delta)
 		}
-		filtered = append(filtered, delta)
 	}
 	return filtered
 }
 
+// keepDelta applies the given set of filters to delta, and returns whether it
+// should be kept.
+func keepDelta(delta *mtree.InodeDelta, filters ...deltaFilterFn) bool {
+	for _, filter := range filters {
+		if !filter(delta) {
+			return false
+		}
+	}
+	// Some filters might modify the entry to remove keyword deltas -- if
+	// there are no deltas left then we should skip the entry entirely.
+	return delta.Type() != mtree.Modified || len(delta.Diff()) > 0
+}
+
+// streamResults calls emit with each of the deltas of res which are kept by
+// filters, as they are found, and returns errValidate if any of them is a
+// failure.
+func streamResults(res iter.Seq2[mtree.InodeDelta, error], strict bool, emit func(mtree.InodeDelta) error, filters ...deltaFilterFn) error {
+	var failed bool
+	for delta, err := range res {
+		if err != nil {
+			return err
+		}
+		if !keepDelta(&delta, filters...) {
+			continue
+		}
+		if strict || delta.Type() == mtree.Modified {
+			failed = true
+		}
+		if err := emit(delta); err != nil {
+			return err
+		}
+	}
+	if failed {
+		return errValidate
+	}
+	return nil
+}
+
+// streamFormat returns functions which write each delta to w in the given
+// result format, and then finish the output, which is the same as that of
+// resultFormat.
+func streamFormat(w io.Writer, format string, strict bool, summaryDepth int) (emit func(mtree.InodeDelta) error, finish func() error) {
+	if format == "summary" {
+		// Only the summaries are held, rather than all of the results.
+		summarizer := mtree.NewSummarizer(summaryDepth)
+		emit = func(delta mtree.InodeDelta) error {
+			summarizer.Add(delta)
+			return nil
+		}
+		finish = func() error {
+			_, err := io.WriteString(w, formatSummaries(summarizer.Summaries()))
+			return err
+		}
+		return emit, finish
+	}
+	if format != "json" {
+		emit = func(delta mtree.InodeDelta) error {
+			_, err := io.WriteString(w, formats[format]([]mtree.InodeDelta{delta}, strict))
+			return err
+		}
+		return emit, func() error { return nil }
+	}
+
+	var count int
+	emit = func(delta mtree.InodeDelta) error {
+		buf, err := json.Marshal(delta)
+		if err != nil {
+			return err
+		}
+		sep := ","
+		if count == 0 {
+			sep = "["
+		}
+		count++
+		_, err = io.WriteString(w, sep+string(buf))
+		return err
+	}
+	finish = func() error {
+		if count == 0 {
+			return nil
+		}
+		_, err := io.WriteString(w, "]\n")
+		return err
+	}
+	return emit, finish
+}
+
 // isTarSpec returns whether the spec provided came from the tar generator.
 // This takes advantage of an unsolveable problem in tar generation.
 func isTarSpec(spec *mtree.DirectoryHierarchy) bool {
@@ -616,51 +1061,6 @@ func isTarSpec(spec *mtree.DirectoryHierarchy) bool {
 	return false
 }
 
-// readExcludePatterns reads fnmatch patterns from a file, one per line.
-// Blank lines and lines beginning with '#' are ignored.
-func readExcludePatterns(filename string) ([]string, error) {
-	fh, err := os.Open(filename)
-	if err != nil {
-		return nil, err
-	}
-	defer fh.Close()
-
-	var patterns []string
-	scanner := bufio.NewScanner(fh)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-		patterns = append(patterns, line)
-	}
-	return patterns, scanner.Err()
-}
-
-// excludeByPatterns returns an ExcludeFunc that excludes paths matching any of
-// the given fnmatch patterns. Patterns containing '/' are matched against the
-// path relative to rootPath; others are matched against the basename only.
-func excludeByPatterns(rootPath string, patterns []string) mtree.ExcludeFunc {
-	return func(path string, info os.FileInfo) bool {
-		rel, err := filepath.Rel(rootPath, path)
-		if err != nil {
-			rel = path
-		}
-		for _, pattern := range patterns {
-			var matched bool
-			if strings.Contains(pattern, "/") {
-				matched, _ = filepath.Match(pattern, rel)
-			} else {
-				matched, _ = filepath.Match(pattern, filepath.Base(rel))
-			}
-			if matched {
-				return true
-			}
-		}
-		return false
-	}
-}
-
 func splitKeywordsArg(str string) []mtree.Keyword {
 	keywords := []mtree.Keyword{}
 	for _, kw := range strings.Fields(strings.Replace(str, ",", " ", -1)) {
@@ -668,3 +1068,20 @@ func splitKeywordsArg(str string) []mtree.Keyword {
 	}
 	return keywords
 }
+
+// readIDMapArg parses the argument of --uidmap or --gidmap, which is either an
+// inline mapping such as "0:100000:65536" or the path of a uid_map file.
+func readIDMapArg(arg string) (mtree.IDMap, error) {
+	if arg == "" {
+		return nil, nil
+	}
+	if strings.Contains(arg, ":") {
+		return mtree.ParseIDMap(arg)
+	}
+	fh, err := os.Open(arg)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	return mtree.ReadIDMap(fh)
+}
@@ -5,8 +5,8 @@ import (
 	"fmt"
 	"iter"
 	"slices"
-
-	"github.com/sirupsen/logrus"
+	"strings"
+	"time"
 )
 
 // XXX: Do we need a Difference interface to make it so people can do var x
@@ -37,6 +37,12 @@ const (
 	// only generated from CompareSame().
 	Same DifferenceType = "same"
 
+	// Moved represents the case where an object present in the @old
+	// manifest is present at another path in the @new manifest. These are
+	// only generated when CompareOptions.DetectMoves is set, in place of a
+	// Missing and an Extra discrepancy.
+	Moved DifferenceType = "moved"
+
 	// ErrorDifference represents an attempted update to the values of
 	// a keyword that failed
 	ErrorDifference DifferenceType = "errored"
@@ -52,13 +58,15 @@ func sPtr(s string) *string { return &s }
 // DirectoryHierarchy manifests. Discrepancies are caused by entries only
 // present in one manifest [Missing, Extra], keys only present in one of the
 // manifests [Modified] or a difference between the keys of the same object in
-// both manifests [Modified].
+// both manifests [Modified]. Entries which were moved to another path are
+// [Moved], if detected.
 type InodeDelta struct {
-	diff DifferenceType
-	path string
-	new  Entry
-	old  Entry
-	keys []KeyDelta
+	diff    DifferenceType
+	path    string
+	oldPath string
+	new     Entry
+	old     Entry
+	keys    []KeyDelta
 }
 
 // Type returns the type of discrepancy encountered when comparing this inode
@@ -68,14 +76,24 @@ func (i InodeDelta) Type() DifferenceType {
 }
 
 // Path returns the path to the inode (relative to the root of the
-// DirectoryHierarchy manifests).
+// DirectoryHierarchy manifests). For Moved inodes, this is the path in the
+// "new" DirectoryHierarchy.
 func (i InodeDelta) Path() string {
 	return i.path
 }
 
+// OldPath returns the path of a Moved inode in the "old" DirectoryHierarchy.
+// For other types of discrepancy, it is the same as Path.
+func (i InodeDelta) OldPath() string {
+	if i.diff == Moved {
+		return i.oldPath
+	}
+	return i.path
+}
+
 // Diff returns the set of key discrepancies between the two manifests for the
-// specific inode. If the DifferenceType of the inode is not Modified, then
-// Diff returns nil.
+// specific inode, sorted by keyword. If the DifferenceType of the inode is not
+// Modified or Moved, then Diff returns nil.
 func (i InodeDelta) Diff() []KeyDelta {
 	return i.keys
 }
@@ -83,9 +101,9 @@ func (i InodeDelta) Diff() []KeyDelta {
 // DiffPtr returns a pointer to the internal slice that would be returned by
 // [InodeDelta.Diff]. This is intended to be used by tools which need to filter
 // aspects of [InodeDelta] entries. If the [DifferenceType] of the inode is not
-// [Modified], then DiffPtr returns nil.
+// [Modified] or [Moved], then DiffPtr returns nil.
 func (i *InodeDelta) DiffPtr() *[]KeyDelta {
-	if i.diff == Modified {
+	if i.diff == Modified || i.diff == Moved {
 		return &i.keys
 	}
 	return nil
@@ -94,7 +112,7 @@ func (i *InodeDelta) DiffPtr() *[]KeyDelta {
 // Old returns the value of the inode Entry in the "old" DirectoryHierarchy (as
 // determined by the ordering of parameters to Compare).
 func (i InodeDelta) Old() *Entry {
-	if i.diff == Modified || i.diff == Missing {
+	if i.diff == Modified || i.diff == Missing || i.diff == Moved {
 		return ePtr(i.old)
 	}
 	return nil
@@ -103,7 +121,7 @@ func (i InodeDelta) Old() *Entry {
 // New returns the value of the inode Entry in the "new" DirectoryHierarchy (as
 // determined by the ordering of parameters to Compare).
 func (i InodeDelta) New() *Entry {
-	if i.diff == Modified || i.diff == Extra {
+	if i.diff == Modified || i.diff == Extra || i.diff == Moved {
 		return ePtr(i.new)
 	}
 	return nil
@@ -112,13 +130,15 @@ func (i InodeDelta) New() *Entry {
 // MarshalJSON creates a JSON-encoded version of InodeDelta.
 func (i InodeDelta) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
-		Type DifferenceType `json:"type"`
-		Path string         `json:"path"`
-		Keys []KeyDelta     `json:"keys"`
+		Type    DifferenceType `json:"type"`
+		Path    string         `json:"path"`
+		OldPath string         `json:"old_path,omitempty"`
+		Keys    []KeyDelta     `json:"keys"`
 	}{
-		Type: i.diff,
-		Path: i.path,
-		Keys: i.keys,
+		Type:    i.diff,
+		Path:    i.path,
+		OldPath: i.oldPath,
+		Keys:    i.keys,
 	})
 }
 
@@ -133,6 +153,13 @@ func (i InodeDelta) String() string {
 		return fmt.Sprintf("%q: unexpected path", i.path)
 	case Missing:
 		return fmt.Sprintf("%q: missing path", i.path)
+	case Moved:
+		if len(i.keys) > 0 {
+			// Output the first failure, as for Modified.
+			f := i.keys[0]
+			return fmt.Sprintf("%q: moved from %q: keyword %q: expected %s; got %s", i.path, i.oldPath, f.name, f.old, f.new)
+		}
+		return fmt.Sprintf("%q: moved from %q", i.path, i.oldPath)
 	default:
 		panic("programming error")
 	}
@@ -144,11 +171,12 @@ func (i InodeDelta) String() string {
 // the keys of the same object in both manifests [Modified]. A set of these is
 // returned with InodeDelta.Diff().
 type KeyDelta struct {
-	diff DifferenceType
-	name Keyword
-	old  string
-	new  string
-	err  error // used for update delta results
+	diff     DifferenceType
+	name     Keyword
+	old      string
+	new      string
+	volatile bool
+	err      error // used for update delta results
 }
 
 // Type returns the type of discrepancy encountered when comparing this key
@@ -183,18 +211,26 @@ func (k KeyDelta) New() *string {
 	return nil
 }
 
+// Volatile returns whether the keyword can never be restored by Update (such
+// as "ctime"), so that the discrepancy cannot be fixed by updating the files.
+func (k KeyDelta) Volatile() bool {
+	return k.volatile
+}
+
 // MarshalJSON creates a JSON-encoded version of KeyDelta.
 func (k KeyDelta) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
-		Type DifferenceType `json:"type"`
-		Name Keyword        `json:"name"`
-		Old  string         `json:"old"`
-		New  string         `json:"new"`
+		Type     DifferenceType `json:"type"`
+		Name     Keyword        `json:"name"`
+		Old      string         `json:"old"`
+		New      string         `json:"new"`
+		Volatile bool           `json:"volatile,omitempty"`
 	}{
-		Type: k.diff,
-		Name: k.name,
-		Old:  k.old,
-		New:  k.new,
+		Type:     k.diff,
+		Name:     k.name,
+		Old:      k.old,
+		New:      k.new,
+		Volatile: k.volatile,
 	})
 }
 
@@ -225,99 +261,173 @@ func iterMapsKeys[M ~map[K]V, K comparable, V any](maps ...M) iter.Seq[K] {
 	}
 }
 
-func convertToTarTime(timeVal string) (KeyVal, error) {
-	var (
-		timeSec, timeNsec int64
-		// used to check for trailing characters
-		trailing rune
-	)
-	n, _ := fmt.Sscanf(timeVal, "%d.%d%c", &timeSec, &timeNsec, &trailing)
-	if n != 2 {
-		return "", fmt.Errorf(`failed to parse "time" key: invalid format %q`, timeVal)
+// entryKey is the path of an entry, and whether it is a directory, which
+// together give its place in hierarchy order.
+type entryKey struct {
+	path string
+	dir  bool
+}
+
+// compareEntryKeys orders entries in hierarchy order, which is the order in
+// which Walk writes them, for sorting: the root comes first, and the
+// contents of each directory are sorted by name with the non-directories
+// before the directories, each of which is followed by its own contents.
+func compareEntryKeys(a, b entryKey) int {
+	switch {
+	case a == b:
+		return 0
+	case a.path == ".":
+		return -1
+	case b.path == ".":
+		return 1
+	}
+	aPath, bPath := a.path, b.path
+	for {
+		aName, aRest, aHasRest := strings.Cut(aPath, "/")
+		bName, bRest, bHasRest := strings.Cut(bPath, "/")
+		// Everything but the last component of a path is a directory.
+		aDir, bDir := aHasRest || a.dir, bHasRest || b.dir
+		if aDir != bDir {
+			if bDir {
+				return -1
+			}
+			return 1
+		}
+		if c := strings.Compare(aName, bName); c != 0 {
+			return c
+		}
+		switch {
+		case !aHasRest && !bHasRest:
+			return 0
+		case !aHasRest:
+			return -1
+		case !bHasRest:
+			return 1
+		}
+		aPath, bPath = aRest, bRest
+	}
+}
+
+// key returns the entryKey of the inode, which is a directory if its entry
+// in the "old" DirectoryHierarchy is (or for Extra and Moved inodes, its
+// entry in the "new" DirectoryHierarchy).
+func (i InodeDelta) key() entryKey {
+	e := i.old
+	if i.diff == Extra || i.diff == Moved {
+		e = i.new
+	}
+	return entryKey{path: i.path, dir: e.IsDir()}
+}
+
+// SortInodeDeltas sorts deltas in hierarchy order (the order in which Compare
+// returns them), such as to combine the results of several comparisons.
+// Deltas of the same path keep their order.
+func SortInodeDeltas(deltas []InodeDelta) {
+	type keyedDelta struct {
+		key   entryKey
+		delta InodeDelta
+	}
+	keyed := make([]keyedDelta, len(deltas))
+	for i, delta := range deltas {
+		keyed[i] = keyedDelta{key: delta.key(), delta: delta}
+	}
+	slices.SortStableFunc(keyed, func(a, b keyedDelta) int {
+		return compareEntryKeys(a.key, b.key)
+	})
+	for i := range keyed {
+		deltas[i] = keyed[i].delta
 	}
-	return KeyVal(fmt.Sprintf("tar_time=%d.%9.9d", timeSec, 0)), nil
 }
 
 // Like Compare, but for single inode entries only. Used to compute the
 // cached version of inode.keys.
-func compareEntry(oldEntry, newEntry Entry) ([]KeyDelta, error) {
+func compareEntry(oldEntry, newEntry Entry, opts *CompareOptions) ([]KeyDelta, error) {
+	registry := opts.Registry
+	if registry == nil {
+		registry = DefaultKeywordRegistry
+	}
+
 	var (
 		oldKeys = oldEntry.allKeysMap()
 		newKeys = newEntry.allKeysMap()
 	)
 
-	// If both tar_time and time were specified in the set of keys, we have to
-	// convert the "time" entries to "tar_time" to allow for tar archive
-	// manifests to be compared with proper filesystem manifests.
-	// TODO(cyphar): This really should be abstracted inside keywords.go
-	if (mapContains(oldKeys, "tar_time") || mapContains(newKeys, "tar_time")) &&
-		(mapContains(oldKeys, "time") || mapContains(newKeys, "time")) {
-
-		path, _ := oldEntry.Path()
-		logrus.WithFields(logrus.Fields{
-			"old:tar_time": oldKeys["tar_time"],
-			"new:tar_time": newKeys["tar_time"],
-			"old:time":     oldKeys["time"],
-			"new:time":     newKeys["time"],
-		}).Debugf(`%q: "tar_time" and "time" both present`, path)
-
-		// Clear the "time" keys.
-		oldTime, oldHadTime := oldKeys["time"]
-		delete(oldKeys, "time")
-		newTime, newHadTime := newKeys["time"]
-		delete(newKeys, "time")
-
-		// NOTE: It is possible (though inadvisable) for a manifest to have
-		// both "tar_time" and "time" set. In those cases, we favour the
-		// existing "tar_time" and just ignore the "time" value.
-
-		switch {
-		case oldHadTime && !mapContains(oldKeys, "tar_time"):
-			tarTime, err := convertToTarTime(oldTime.Value())
-			if err != nil {
-				return nil, fmt.Errorf("old entry: %w", err)
+	// If one entry has a keyword (such as "tar_time") and the other only has
+	// one of its equivalents (such as "time"), then the equivalent is compared
+	// in its place. This allows tar archive manifests to be compared with
+	// proper filesystem manifests.
+	for _, k := range slices.Sorted(iterMapsKeys(oldKeys, newKeys)) {
+		spec, ok := registry.Lookup(k)
+		if !ok || spec.Name != k {
+			continue
+		}
+		for _, eq := range spec.Equivalents {
+			for _, keys := range []map[Keyword]KeyVal{oldKeys, newKeys} {
+				// NOTE: It is possible (though inadvisable) for a manifest to
+				// have both "tar_time" and "time" set. In those cases, we
+				// favour the existing "tar_time" and just ignore the "time"
+				// value.
+				if kv, ok := keys[eq]; ok {
+					if !mapContains(keys, k) {
+						keys[k] = kv
+					}
+					delete(keys, eq)
+				}
 			}
-			oldKeys["tar_time"] = tarTime
-		case newHadTime && !mapContains(newKeys, "tar_time"):
-			tarTime, err := convertToTarTime(newTime.Value())
-			if err != nil {
-				return nil, fmt.Errorf("new entry: %w", err)
+		}
+	}
+
+	// Translate the IDs of the old entry to the IDs of the new one. IDs which
+	// are not mapped are left alone, and so will be reported as modified.
+	if len(opts.UIDMap) > 0 || len(opts.GIDMap) > 0 {
+		for _, k := range []Keyword{"uid", "gid"} {
+			if kv, ok := oldKeys[k]; ok {
+				if mapped, err := mapIDKeyVal(kv, opts.UIDMap, opts.GIDMap); err == nil {
+					oldKeys[k] = mapped
+				}
 			}
-			newKeys["tar_time"] = tarTime
 		}
 	}
 
 	// Are there any differences?
 	var results []KeyDelta
-	for k := range iterMapsKeys(newKeys, oldKeys) {
+	for _, k := range slices.Sorted(iterMapsKeys(newKeys, oldKeys)) {
 		old, oldHas := oldKeys[k]
 		gnu, gnuHas := newKeys[k] // avoid shadowing "new" builtin
+		spec, hasSpec := registry.Lookup(k)
 
 		switch {
 		// Missing
 		case !gnuHas:
 			results = append(results, KeyDelta{
-				diff: Missing,
-				name: k,
-				old:  old.Value(),
+				diff:     Missing,
+				name:     k,
+				old:      old.Value(),
+				volatile: spec.Volatile,
 			})
 
 		// Extra
 		case !oldHas:
 			results = append(results, KeyDelta{
-				diff: Extra,
-				name: k,
-				new:  gnu.Value(),
+				diff:     Extra,
+				name:     k,
+				new:      gnu.Value(),
+				volatile: spec.Volatile,
 			})
 
 		// Modified
 		default:
-			if !old.Equal(gnu) {
+			equal := old.Equal
+			if hasSpec && spec.Compare != nil {
+				equal = func(gnu KeyVal) bool { return spec.Compare(old, gnu, opts) }
+			}
+			if !equal(gnu) {
 				results = append(results, KeyDelta{
-					diff: Modified,
-					name: k,
-					old:  old.Value(),
-					new:  gnu.Value(),
+					diff:     Modified,
+					name:     k,
+					old:      old.Value(),
+					new:      gnu.Value(),
+					volatile: spec.Volatile,
 				})
 			}
 		}
@@ -326,8 +436,87 @@ func compareEntry(oldEntry, newEntry Entry) ([]KeyDelta, error) {
 	return results, nil
 }
 
+// CompareOptions are the parameters for CompareWithOptions.
+type CompareOptions struct {
+	// Keywords controls which keys will be compared. If nil, then all
+	// possible keys will be compared between the two manifests.
+	Keywords []Keyword
+
+	// Rules adjust Keywords for the paths they match. If Keywords is nil,
+	// only the keywords removed by Rules have any effect.
+	Rules KeywordRules
+
+	// IncludeSame includes the entries that are the same with a Same
+	// DifferenceType, as with CompareSame.
+	IncludeSame bool
+
+	// Registry is used to find how to compare each keyword. If nil, then
+	// DefaultKeywordRegistry is used.
+	Registry *KeywordRegistry
+
+	// TimePrecision is the granularity of the "time" and "tar_time" values
+	// (and other timestamps, such as "ctime") which are compared, such as
+	// time.Second for filesystems which don't store sub-second times, or
+	// 2*time.Second for FAT. Times are truncated to a multiple of it before
+	// comparison. Zero means nanoseconds.
+	TimePrecision time.Duration
+
+	// TimeTolerance is the largest difference between timestamp values
+	// (after applying TimePrecision) which is still treated as equal.
+	TimeTolerance time.Duration
+
+	// UIDMap and GIDMap translate the "uid" and "gid" values of the old
+	// hierarchy (such as a manifest created inside a user namespace) to the
+	// IDs of the new one, before they are compared. Modified deltas report
+	// the translated old value.
+	UIDMap IDMap
+	GIDMap IDMap
+
+	// TrustDirDigests skips the paths beneath directories which have the same
+	// "dirdigest" value in both hierarchies, which is much quicker for large
+	// trees. The digest only covers the names, types and contents of the
+	// paths, so changes to other keywords (such as "mode" or "time") beneath
+	// those directories are not reported.
+	TrustDirDigests bool
+
+	// DetectMoves pairs up the Missing and Extra entries which are the same
+	// file at another path, and reports them as Moved instead. Files are the
+	// same if they have the same digest (such as "sha256digest"), or without
+	// a digest the same "type", "size" and "link". Directories are only
+	// paired if everything beneath them is the same, and are then reported as
+	// a single Moved entry. Entries are only paired if there is exactly one
+	// candidate. Any other differences between the keys of the paired
+	// entries are given by the Diff of the Moved entry.
+	DetectMoves bool
+
+	// TempDir is the directory in which CompareStreams writes temporary
+	// files. If empty, then os.TempDir is used.
+	TempDir string
+}
+
 // compare is the actual workhorse for Compare() and CompareSame()
-func compare(oldDh, newDh *DirectoryHierarchy, keys []Keyword, same bool) ([]InodeDelta, error) {
+func compare(oldDh, newDh *DirectoryHierarchy, opts CompareOptions) ([]InodeDelta, error) {
+	var results []InodeDelta
+	err := compareFunc(oldDh, newDh, opts, func(delta InodeDelta) bool {
+		results = append(results, delta)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// compareFunc calls fn with each of the discrepancies between oldDh and newDh
+// in hierarchy order, as they are found, until fn returns false.
+func compareFunc(oldDh, newDh *DirectoryHierarchy, opts CompareOptions, fn func(InodeDelta) bool) error {
+	if opts.DetectMoves {
+		opts.DetectMoves = false
+		return detectMovesFunc(&opts, func(fn func(InodeDelta) bool) error {
+			return compareFunc(oldDh, newDh, opts, fn)
+		}, fn)
+	}
+
 	toEntryMap := func(dh *DirectoryHierarchy) (map[string]Entry, error) {
 		if dh == nil {
 			// treat nil DirectoryHierarchy as empty
@@ -350,79 +539,136 @@ func compare(oldDh, newDh *DirectoryHierarchy, keys []Keyword, same bool) ([]Ino
 
 	oldEntries, err := toEntryMap(oldDh)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	newEntries, err := toEntryMap(newDh)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	// Now we compute the diff.
-	var results []InodeDelta
+	var trustedDirs map[string]bool
+	if opts.TrustDirDigests {
+		trustedDirs = matchingDirDigests(oldEntries, newEntries)
+	}
+
+	keys := make([]entryKey, 0, len(oldEntries))
 	for path := range iterMapsKeys(oldEntries, newEntries) {
-		old, oldHas := oldEntries[path]
-		gnu, gnuHas := newEntries[path] // avoid shadowing "new" builtin
+		e, ok := oldEntries[path]
+		if !ok {
+			e = newEntries[path]
+		}
+		keys = append(keys, entryKey{path: path, dir: e.IsDir()})
+	}
+	slices.SortFunc(keys, compareEntryKeys)
 
-		switch {
-		// Missing
-		case !gnuHas:
-			results = append(results, InodeDelta{
-				diff: Missing,
-				path: path,
-				old:  old,
-			})
+	// Now we compute the diff.
+	for _, key := range keys {
+		path := key.path
+		if inDirs(path, trustedDirs) {
+			continue
+		}
+		var old, gnu *Entry // avoid shadowing "new" builtin
+		if e, ok := oldEntries[path]; ok {
+			old = &e
+		}
+		if e, ok := newEntries[path]; ok {
+			gnu = &e
+		}
+		delta, ok, err := diffEntries(path, old, gnu, &opts)
+		if err != nil {
+			return err
+		}
+		if ok && !fn(delta) {
+			return nil
+		}
+	}
 
-		// Extra
-		case !oldHas:
-			results = append(results, InodeDelta{
-				diff: Extra,
-				path: path,
-				new:  gnu,
-			})
+	return nil
+}
 
-		// Modified
-		default:
-			changed, err := compareEntry(old, gnu)
-			if err != nil {
-				return nil, fmt.Errorf("comparison failed %s: %s", path, err)
-			}
+// diffEntries returns the discrepancy between the entries of path in the old
+// and new hierarchies (either of which may be nil), and whether there is one
+// to report.
+func diffEntries(path string, old, gnu *Entry, opts *CompareOptions) (InodeDelta, bool, error) {
+	switch {
+	// Missing
+	case gnu == nil:
+		return InodeDelta{
+			diff: Missing,
+			path: path,
+			old:  *old,
+		}, true, nil
+
+	// Extra
+	case old == nil:
+		return InodeDelta{
+			diff: Extra,
+			path: path,
+			new:  *gnu,
+		}, true, nil
+	}
 
-			// Ignore changes to keys not in the requested set.
-			if keys != nil {
-				changed = slices.DeleteFunc(changed, func(delta KeyDelta) bool {
-					name := delta.name.Prefix()
-					return !InKeywordSlice(name, keys) &&
-						// We remap time to tar_time in compareEntry, so we
-						// need to treat them equivalently here.
-						!(name == "time" && InKeywordSlice("tar_time", keys)) &&
-						!(name == "tar_time" && InKeywordSlice("time", keys))
-				})
-			}
+	// Modified
+	changed, err := compareEntry(*old, *gnu, opts)
+	if err != nil {
+		return InodeDelta{}, false, fmt.Errorf("comparison failed %s: %s", path, err)
+	}
+
+	registry := opts.Registry
+	if registry == nil {
+		registry = DefaultKeywordRegistry
+	}
 
-			// Check if there were any actual changes.
-			if len(changed) > 0 {
-				results = append(results, InodeDelta{
-					diff: Modified,
-					path: path,
-					old:  old,
-					new:  gnu,
-					keys: changed,
+	// Ignore changes to keys not in the requested set.
+	if keys := opts.Keywords; keys != nil {
+		pathKeys := keys
+		if opts.Rules != nil {
+			pathKeys = opts.Rules.Apply(path, keys)
+		}
+		changed = slices.DeleteFunc(changed, func(delta KeyDelta) bool {
+			name := delta.name.Prefix()
+			if !suffixSelected(delta.name, pathKeys) {
+				return true
+			}
+			// Equivalent keywords are compared in place of each other in
+			// compareEntry, so we need to treat them equivalently here.
+			return !InKeywordSlice(name, keywordPrefixes(pathKeys)) &&
+				!slices.ContainsFunc(pathKeys, func(kw Keyword) bool {
+					return registry.equivalent(name, kw)
 				})
-			} else if same {
-				// this means that nothing changed, i.e. that
-				// the files are the same.
-				results = append(results, InodeDelta{
-					diff: Same,
-					path: path,
-					old:  old,
-					new:  gnu,
-					keys: changed,
+		})
+	} else if opts.Rules != nil {
+		changed = slices.DeleteFunc(changed, func(delta KeyDelta) bool {
+			name := delta.name.Prefix()
+			spec, _ := registry.Lookup(name)
+			return opts.Rules.Removed(path, name) ||
+				slices.ContainsFunc(spec.Equivalents, func(eq Keyword) bool {
+					return opts.Rules.Removed(path, eq)
 				})
-			}
-		}
+		})
 	}
 
-	return results, nil
+	// Check if there were any actual changes.
+	if len(changed) > 0 {
+		return InodeDelta{
+			diff: Modified,
+			path: path,
+			old:  *old,
+			new:  *gnu,
+			keys: changed,
+		}, true, nil
+	} else if opts.IncludeSame {
+		// this means that nothing changed, i.e. that
+		// the files are the same.
+		return InodeDelta{
+			diff: Same,
+			path: path,
+			old:  *old,
+			new:  *gnu,
+			keys: changed,
+		}, true, nil
+	}
+	return InodeDelta{}, false, nil
 }
 
 // Compare compares two directory hierarchy manifests, and returns the
@@ -430,7 +676,8 @@ func compare(oldDh, newDh *DirectoryHierarchy, keys []Keyword, same bool) ([]Ino
 // manifest are considered, with differences being generated for
 // RelativeType and FullType entries. Differences in structure (such as
 // the way /set and /unset are written) are not considered to be
-// discrepancies. The list of differences are all filesystem objects.
+// discrepancies. The list of differences are all filesystem objects, sorted
+// in hierarchy order (the order in which Walk writes them).
 //
 // keys controls which keys will be compared, but if keys is nil then all
 // possible keys will be compared between the two manifests (allowing for
@@ -445,11 +692,39 @@ func compare(oldDh, newDh *DirectoryHierarchy, keys []Keyword, same bool) ([]Ino
 //
 //	Missing are considered as different discrepancy types.
 func Compare(oldDh, newDh *DirectoryHierarchy, keys []Keyword) ([]InodeDelta, error) {
-	return compare(oldDh, newDh, keys, false)
+	return compare(oldDh, newDh, CompareOptions{Keywords: keys})
 }
 
 // CompareSame is the same as Compare, except it also includes the entries
 // that are the same with a Same DifferenceType.
 func CompareSame(oldDh, newDh *DirectoryHierarchy, keys []Keyword) ([]InodeDelta, error) {
-	return compare(oldDh, newDh, keys, true)
+	return compare(oldDh, newDh, CompareOptions{Keywords: keys, IncludeSame: true})
+}
+
+// CompareWithOptions is like Compare, but takes its parameters from opts. A nil
+// opts is treated the same as a zero CompareOptions.
+func CompareWithOptions(oldDh, newDh *DirectoryHierarchy, opts *CompareOptions) ([]InodeDelta, error) {
+	if opts == nil {
+		opts = &CompareOptions{}
+	}
+	return compare(oldDh, newDh, *opts)
+}
+
+// CompareSeq is like CompareWithOptions, but returns an iterator over the
+// discrepancies, which yields each of them as soon as it is found rather than
+// once the whole of the manifests have been compared. If the comparison
+// fails, the error is yielded last. With DetectMoves, nothing can be yielded
+// until all of the entries have been compared.
+func CompareSeq(oldDh, newDh *DirectoryHierarchy, opts *CompareOptions) iter.Seq2[InodeDelta, error] {
+	if opts == nil {
+		opts = &CompareOptions{}
+	}
+	return func(yield func(InodeDelta, error) bool) {
+		err := compareFunc(oldDh, newDh, *opts, func(delta InodeDelta) bool {
+			return yield(delta, nil)
+		})
+		if err != nil {
+			yield(InodeDelta{}, err)
+		}
+	}
 }
@@ -400,3 +400,107 @@ func TestTarCompare(t *testing.T) {
 		}
 	}
 }
+
+func TestCompareEntryKeys(t *testing.T) {
+	keys := []entryKey{
+		{"b", false}, {"a-c", true}, {"a/b/c", false}, {".", true}, {".hidden", false},
+		{"a", true}, {"a/b", true}, {"a.d", false}, {"a/a", false}, {"a/b-c", false},
+	}
+	slices.SortFunc(keys, compareEntryKeys)
+	assert.Equal(t, []entryKey{
+		{".", true}, {".hidden", false}, {"a.d", false}, {"b", false},
+		{"a", true}, {"a/a", false}, {"a/b-c", false}, {"a/b", true}, {"a/b/c", false},
+		{"a-c", true},
+	}, keys)
+}
+
+func TestCompareOrder(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a", "a/b", "a-c", "z", "z/y"} {
+		require.NoError(t, os.Mkdir(filepath.Join(dir, name), 0755))
+	}
+	for _, name := range []string{"a/b/f", "a/file", "a-c/f", "m", "z/y/f"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("data\n"), 0644))
+	}
+	keywords := []Keyword{"type", "size", "mode", "uid", "gid", "sha256digest"}
+	dh, err := Walk(dir, nil, keywords, nil)
+	require.NoError(t, err)
+
+	// Compare against an empty hierarchy, with different keys.
+	res, err := Compare(nil, dh, nil)
+	require.NoError(t, err)
+	var paths []string
+	for _, delta := range res {
+		assert.Equal(t, Extra, delta.Type())
+		paths = append(paths, delta.Path())
+	}
+	assert.Equal(t, []string{".", "m", "a", "a/file", "a/b", "a/b/f", "a-c", "a-c/f", "z", "z/y", "z/y/f"}, paths)
+
+	// This is the order in which Walk writes the entries.
+	var walked []string
+	for _, e := range dh.Entries {
+		if e.Type == RelativeType || e.Type == FullType {
+			path, err := e.Path()
+			require.NoError(t, err)
+			walked = append(walked, path)
+		}
+	}
+	assert.Equal(t, walked, paths)
+
+	var modified DirectoryHierarchy
+	for _, e := range dh.Entries {
+		if e.Name == "m" {
+			e.Keywords = []KeyVal{"type=file", "size=1", "mode=0600", "uid=1", "gid=1", "sha256digest=00"}
+		}
+		modified.Entries = append(modified.Entries, e)
+	}
+	for range 5 {
+		res, err = Compare(dh, &modified, nil)
+		require.NoError(t, err)
+		require.Len(t, res, 1)
+		var keys []Keyword
+		for _, kd := range res[0].Diff() {
+			keys = append(keys, kd.Name())
+		}
+		assert.Equal(t, []Keyword{"gid", "mode", "sha256digest", "size", "uid"}, keys)
+	}
+}
+
+func TestCompareSeq(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a", "b", "c"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(name), 0644))
+	}
+	dh, err := Walk(dir, nil, []Keyword{"type", "size"}, nil)
+	require.NoError(t, err)
+
+	expected, err := Compare(nil, dh, nil)
+	require.NoError(t, err)
+	var got []InodeDelta
+	for delta, err := range CompareSeq(nil, dh, nil) {
+		require.NoError(t, err)
+		got = append(got, delta)
+	}
+	assert.Equal(t, expected, got)
+
+	// Stopping early stops the comparison.
+	got = nil
+	for delta, err := range CompareSeq(nil, dh, &CompareOptions{DetectMoves: true}) {
+		require.NoError(t, err)
+		got = append(got, delta)
+		if len(got) == 2 {
+			break
+		}
+	}
+	assert.Equal(t, expected[:2], got)
+
+	// Errors are yielded.
+	bad := &DirectoryHierarchy{Entries: []Entry{{Type: RelativeType, Name: `\z`}}}
+	var errs []error
+	for _, err := range CompareSeq(bad, dh, nil) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	assert.Len(t, errs, 1)
+}
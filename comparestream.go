@@ -0,0 +1,574 @@
+package mtree
+
+import (
+	"bufio"
+	"container/heap"
+	"fmt"
+	"io"
+	"iter"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/vbatts/go-mtree/pkg/govis"
+)
+
+var (
+	// sortChunkEntries is how many entries are sorted in memory at once,
+	// when sorting a specification which is not in hierarchy order.
+	sortChunkEntries = 1 << 16
+
+	// sortMergeWidth is the most sorted runs which are merged at once.
+	sortMergeWidth = 64
+)
+
+// streamEntry is an entry of a specification, with its entryKey.
+type streamEntry struct {
+	key   entryKey
+	entry Entry
+}
+
+// entryStream yields the entries of a specification one at a time.
+type entryStream interface {
+	// next returns the next entry, or false at the end of the stream.
+	next() (streamEntry, bool, error)
+	close() error
+}
+
+// specEntries is an entryStream of the RelativeType and FullType entries read
+// by a SpecReader, in the order in which they are read.
+type specEntries struct {
+	sr *SpecReader
+}
+
+func (s *specEntries) next() (streamEntry, bool, error) {
+	for {
+		e, err := s.sr.Next()
+		if err == io.EOF {
+			return streamEntry{}, false, nil
+		}
+		if err != nil {
+			return streamEntry{}, false, err
+		}
+		if e.Type != RelativeType && e.Type != FullType {
+			continue
+		}
+		path, err := e.Path()
+		if err != nil {
+			return streamEntry{}, false, err
+		}
+		return streamEntry{key: entryKey{path: path, dir: e.IsDir()}, entry: e}, true, nil
+	}
+}
+
+func (s *specEntries) close() error { return nil }
+
+// sliceEntries is an entryStream of entries held in memory.
+type sliceEntries []streamEntry
+
+func (s *sliceEntries) next() (streamEntry, bool, error) {
+	if len(*s) == 0 {
+		return streamEntry{}, false, nil
+	}
+	e := (*s)[0]
+	*s = (*s)[1:]
+	return e, true, nil
+}
+
+func (s *sliceEntries) close() error { return nil }
+
+// runEntries is an entryStream of the entries of a sorted run written by
+// writeRun. The entries are FullType entries holding all of their keywords.
+type runEntries struct {
+	fh *os.File
+	r  *bufio.Reader
+}
+
+func openRun(name string) (*runEntries, error) {
+	fh, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	// The lines hold the keywords of any /set as well as the entry's own, and
+	// so may be of any length.
+	return &runEntries{fh: fh, r: bufio.NewReader(fh)}, nil
+}
+
+func (r *runEntries) next() (streamEntry, bool, error) {
+	line, err := r.r.ReadString('\n')
+	if err == io.EOF && line == "" {
+		return streamEntry{}, false, nil
+	} else if err != nil && err != io.EOF {
+		return streamEntry{}, false, err
+	}
+	f := strings.Fields(line)
+	e := Entry{
+		Name:     f[0],
+		Type:     FullType,
+		Keywords: StringToKeyVals(f[1:]),
+	}
+	path, err := e.Path()
+	if err != nil {
+		return streamEntry{}, false, err
+	}
+	return streamEntry{key: entryKey{path: path, dir: e.IsDir()}, entry: e}, true, nil
+}
+
+func (r *runEntries) close() error { return r.fh.Close() }
+
+// writeRun writes all of the entries of src to the file name, one per line,
+// for reading with openRun.
+func writeRun(name string, src entryStream) error {
+	fh, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	w := bufio.NewWriter(fh)
+	for {
+		e, ok, err := src.next()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		encoded, err := govis.Vis(e.key.path, DefaultVisFlags)
+		if err != nil {
+			return err
+		}
+		w.WriteString(encoded)
+		for _, kv := range e.entry.AllKeys() {
+			w.WriteString(" " + string(kv))
+		}
+		w.WriteString("\n")
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return fh.Close()
+}
+
+// mergedEntries is an entryStream merging entryStreams which are each in
+// hierarchy order. Entries with the same key are yielded in the order of the
+// streams they are from.
+type mergedEntries struct {
+	streams []entryStream
+	heads   []streamEntry
+	order   []int // the indexes of the streams with a head, as a heap
+}
+
+func mergeEntries(streams []entryStream) (*mergedEntries, error) {
+	m := &mergedEntries{streams: streams, heads: make([]streamEntry, len(streams))}
+	for i, s := range streams {
+		e, ok, err := s.next()
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			m.heads[i] = e
+			m.order = append(m.order, i)
+		}
+	}
+	heap.Init(m)
+	return m, nil
+}
+
+func (m *mergedEntries) Len() int { return len(m.order) }
+func (m *mergedEntries) Less(i, j int) bool {
+	a, b := m.order[i], m.order[j]
+	if c := compareEntryKeys(m.heads[a].key, m.heads[b].key); c != 0 {
+		return c < 0
+	}
+	return a < b
+}
+func (m *mergedEntries) Swap(i, j int) { m.order[i], m.order[j] = m.order[j], m.order[i] }
+func (m *mergedEntries) Push(x any)    { m.order = append(m.order, x.(int)) }
+func (m *mergedEntries) Pop() any {
+	i := m.order[len(m.order)-1]
+	m.order = m.order[:len(m.order)-1]
+	return i
+}
+
+func (m *mergedEntries) next() (streamEntry, bool, error) {
+	if len(m.order) == 0 {
+		return streamEntry{}, false, nil
+	}
+	i := m.order[0]
+	e := m.heads[i]
+	head, ok, err := m.streams[i].next()
+	if err != nil {
+		return streamEntry{}, false, err
+	}
+	if ok {
+		m.heads[i] = head
+		heap.Fix(m, 0)
+	} else {
+		heap.Pop(m)
+	}
+	return e, true, nil
+}
+
+func (m *mergedEntries) close() error {
+	var err error
+	for _, s := range m.streams {
+		if closeErr := s.close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// sortedEntries is an entryStream of the entries of a specification in
+// hierarchy order, which closes the copy of the specification and removes the
+// temporary files used to sort it (if any) when closed.
+type sortedEntries struct {
+	entryStream
+	spool  *os.File
+	tmpDir string
+}
+
+func (s *sortedEntries) close() error {
+	var err error
+	if s.entryStream != nil {
+		err = s.entryStream.close()
+	}
+	if s.spool != nil {
+		if closeErr := s.spool.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if s.tmpDir != "" {
+		if rmErr := os.RemoveAll(s.tmpDir); err == nil {
+			err = rmErr
+		}
+	}
+	return err
+}
+
+// inHierarchyOrder returns whether the entries of src are in hierarchy order,
+// stopping at the first which is not.
+func inHierarchyOrder(src entryStream) (bool, error) {
+	var prev *entryKey
+	for {
+		e, ok, err := src.next()
+		if err != nil || !ok {
+			return true, err
+		}
+		if prev != nil && compareEntryKeys(*prev, e.key) > 0 {
+			return false, nil
+		}
+		prev = &e.key
+	}
+}
+
+// openSortedEntries returns the entries of the specification read from r in
+// hierarchy order. The specification is read once to check whether it is
+// already in order, and if not it is sorted in runs of sortChunkEntries in
+// temporary files in tmpDir which are then merged. If r is not an
+// io.ReadSeeker, then it is also copied to a temporary file to be read again.
+func openSortedEntries(r io.Reader, tmpDir string) (_ entryStream, retErr error) {
+	sorted := &sortedEntries{}
+	defer func() {
+		if retErr != nil {
+			sorted.close()
+		}
+	}()
+	// The temporary directory is only created if it is needed.
+	mkdir := func() error {
+		if sorted.tmpDir != "" {
+			return nil
+		}
+		dir, err := os.MkdirTemp(tmpDir, "mtree-compare-")
+		sorted.tmpDir = dir
+		return err
+	}
+
+	rs, seekable := r.(io.ReadSeeker)
+	var start int64
+	if seekable {
+		var err error
+		if start, err = rs.Seek(0, io.SeekCurrent); err != nil {
+			// Such as a pipe.
+			seekable = false
+		}
+	}
+	if !seekable {
+		if err := mkdir(); err != nil {
+			return nil, err
+		}
+		spool, err := os.Create(filepath.Join(sorted.tmpDir, "spec"))
+		if err != nil {
+			return nil, err
+		}
+		sorted.spool = spool
+		r, rs = io.TeeReader(r, spool), spool
+	}
+	inOrder, err := inHierarchyOrder(&specEntries{sr: NewSpecReader(r)})
+	if err != nil {
+		return nil, err
+	}
+	if !seekable {
+		// The whole of the specification is needed in the copy.
+		if _, err := io.Copy(io.Discard, r); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := rs.Seek(start, io.SeekStart); err != nil {
+		return nil, err
+	}
+	src := &specEntries{sr: NewSpecReader(rs)}
+	if inOrder {
+		sorted.entryStream = src
+		return sorted, nil
+	}
+
+	// Sort the entries in runs of sortChunkEntries.
+	if err := mkdir(); err != nil {
+		return nil, err
+	}
+	dir := sorted.tmpDir
+	var (
+		runs  []string
+		chunk []streamEntry
+	)
+	writeChunk := func() error {
+		slices.SortStableFunc(chunk, func(a, b streamEntry) int {
+			return compareEntryKeys(a.key, b.key)
+		})
+		name := filepath.Join(dir, fmt.Sprintf("run%d", len(runs)))
+		s := sliceEntries(chunk)
+		if err := writeRun(name, &s); err != nil {
+			return err
+		}
+		runs = append(runs, name)
+		chunk = chunk[:0]
+		return nil
+	}
+	for {
+		e, ok, err := src.next()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		chunk = append(chunk, e)
+		if len(chunk) == sortChunkEntries {
+			if err := writeChunk(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(chunk) > 0 || len(runs) == 0 {
+		if err := writeChunk(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Merge the runs, sortMergeWidth at a time, until they can all be merged
+	// at once.
+	for len(runs) > sortMergeWidth {
+		var merged []string
+		for len(runs) > 0 {
+			n := min(sortMergeWidth, len(runs))
+			m, err := openRuns(runs[:n])
+			if err != nil {
+				return nil, err
+			}
+			name := runs[0] + "m"
+			err = writeRun(name, m)
+			if closeErr := m.close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return nil, err
+			}
+			for _, run := range runs[:n] {
+				os.Remove(run)
+			}
+			merged = append(merged, name)
+			runs = runs[n:]
+		}
+		runs = merged
+	}
+	m, err := openRuns(runs)
+	if err != nil {
+		return nil, err
+	}
+	sorted.entryStream = m
+	return sorted, nil
+}
+
+// openRuns returns the entries of the sorted runs written to the files names,
+// merged in hierarchy order.
+func openRuns(names []string) (*mergedEntries, error) {
+	var streams []entryStream
+	for _, name := range names {
+		run, err := openRun(name)
+		if err != nil {
+			for _, s := range streams {
+				s.close()
+			}
+			return nil, err
+		}
+		streams = append(streams, run)
+	}
+	m, err := mergeEntries(streams)
+	if err != nil {
+		for _, s := range streams {
+			s.close()
+		}
+		return nil, err
+	}
+	return m, nil
+}
+
+// dedupedEntries is an entryStream which yields only the last of consecutive
+// entries with the same key, as with a path given more than once in a
+// specification.
+type dedupedEntries struct {
+	entryStream
+	head    streamEntry
+	hasHead bool
+	started bool
+}
+
+func (d *dedupedEntries) next() (streamEntry, bool, error) {
+	if !d.started {
+		var err error
+		d.head, d.hasHead, err = d.entryStream.next()
+		if err != nil {
+			return streamEntry{}, false, err
+		}
+		d.started = true
+	}
+	if !d.hasHead {
+		return streamEntry{}, false, nil
+	}
+	for {
+		e := d.head
+		var err error
+		d.head, d.hasHead, err = d.entryStream.next()
+		if err != nil {
+			return streamEntry{}, false, err
+		}
+		if !d.hasHead || d.head.key != e.key {
+			return e, true, nil
+		}
+	}
+}
+
+// mergeJoin calls fn with each of the discrepancies between the entries of
+// old and gnu, which are both in hierarchy order, as they are found, until fn
+// returns false.
+func mergeJoin(old, gnu entryStream, opts CompareOptions, fn func(InodeDelta) bool) error {
+	old, gnu = &dedupedEntries{entryStream: old}, &dedupedEntries{entryStream: gnu}
+	o, oldHas, err := old.next()
+	if err != nil {
+		return err
+	}
+	n, gnuHas, err := gnu.next()
+	if err != nil {
+		return err
+	}
+
+	// trustedDir holds the directory (if any) with the same "dirdigest" in both,
+	// whose contents are skipped. They follow it in hierarchy order.
+	var trustedDir map[string]bool
+	for oldHas || gnuHas {
+		var c int
+		switch {
+		case !gnuHas:
+			c = -1
+		case !oldHas:
+			c = 1
+		default:
+			c = compareEntryKeys(o.key, n.key)
+		}
+
+		var (
+			path               string
+			oldEntry, gnuEntry *Entry
+		)
+		if c <= 0 {
+			path, oldEntry = o.key.path, &o.entry
+		}
+		if c >= 0 {
+			path, gnuEntry = n.key.path, &n.entry
+		}
+		if !inDirs(path, trustedDir) {
+			trustedDir = nil
+			if opts.TrustDirDigests && oldEntry != nil && gnuEntry != nil && sameDirDigest(*oldEntry, *gnuEntry) {
+				trustedDir = map[string]bool{path: true}
+			}
+			delta, ok, err := diffEntries(path, oldEntry, gnuEntry, &opts)
+			if err != nil {
+				return err
+			}
+			if ok && !fn(delta) {
+				return nil
+			}
+		}
+
+		if c <= 0 {
+			if o, oldHas, err = old.next(); err != nil {
+				return err
+			}
+		}
+		if c >= 0 {
+			if n, gnuHas, err = gnu.next(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// CompareStreams is like CompareSeq, but compares the specifications read
+// from oldR and newR with a merge join of their entries in hierarchy order,
+// so that only a few entries of each are held in memory at once (rather than
+// all of them, twice over). This is the order in which Walk writes them, but
+// a specification which isn't in order (such as one created from a tar
+// archive) is first sorted in temporary files in opts.TempDir. Inputs which
+// aren't an io.ReadSeeker are copied to a temporary file too, as they are
+// read twice.
+//
+// A path which is a directory in one specification but not the other is at
+// different places in hierarchy order, so it is reported as Missing and
+// Extra rather than Modified.
+func CompareStreams(oldR, newR io.Reader, opts *CompareOptions) iter.Seq2[InodeDelta, error] {
+	if opts == nil {
+		opts = &CompareOptions{}
+	}
+	return func(yield func(InodeDelta, error) bool) {
+		err := compareStreams(oldR, newR, *opts, func(delta InodeDelta) bool {
+			return yield(delta, nil)
+		})
+		if err != nil {
+			yield(InodeDelta{}, err)
+		}
+	}
+}
+
+func compareStreams(oldR, newR io.Reader, opts CompareOptions, fn func(InodeDelta) bool) error {
+	if opts.DetectMoves {
+		opts.DetectMoves = false
+		return detectMovesFunc(&opts, func(fn func(InodeDelta) bool) error {
+			return compareStreams(oldR, newR, opts, fn)
+		}, fn)
+	}
+
+	old, err := openSortedEntries(oldR, opts.TempDir)
+	if err != nil {
+		return fmt.Errorf("old specification: %w", err)
+	}
+	defer old.close()
+	gnu, err := openSortedEntries(newR, opts.TempDir)
+	if err != nil {
+		return fmt.Errorf("new specification: %w", err)
+	}
+	defer gnu.close()
+	return mergeJoin(old, gnu, opts, fn)
+}
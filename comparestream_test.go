@@ -0,0 +1,223 @@
+package mtree
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vbatts/go-mtree/pkg/govis"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// streamDeltaSummary returns the type, path and keyword deltas of each of
+// deltas, which don't depend on how the entries were read.
+func streamDeltaSummary(t *testing.T, deltas []InodeDelta) []string {
+	t.Helper()
+	var summary []string
+	for _, d := range deltas {
+		s := string(d.Type()) + " " + d.Path()
+		if d.Type() == Moved {
+			s += " <- " + d.OldPath()
+		}
+		for _, kd := range d.Diff() {
+			s += " " + string(kd.Type()) + ":" + string(kd.Name()) + "=" + kd.old + "/" + kd.new
+		}
+		summary = append(summary, s)
+	}
+	return summary
+}
+
+// collectStreams returns the results of CompareStreams.
+func collectStreams(t *testing.T, oldR, newR io.Reader, opts *CompareOptions) []InodeDelta {
+	t.Helper()
+	var res []InodeDelta
+	for delta, err := range CompareStreams(oldR, newR, opts) {
+		require.NoError(t, err)
+		res = append(res, delta)
+	}
+	return res
+}
+
+// fullPathSpec returns the entries of dh as a specification of FullType
+// entries (with all of their keywords), in a random order.
+func fullPathSpec(t *testing.T, dh *DirectoryHierarchy) []byte {
+	t.Helper()
+	var lines []string
+	for _, e := range dh.Entries {
+		if e.Type != RelativeType && e.Type != FullType {
+			continue
+		}
+		path, err := e.Path()
+		require.NoError(t, err)
+		encoded, err := govis.Vis(path, DefaultVisFlags)
+		require.NoError(t, err)
+		line := "./" + encoded
+		for _, kv := range e.AllKeys() {
+			line += " " + string(kv)
+		}
+		lines = append(lines, line)
+	}
+	rand.Shuffle(len(lines), func(i, j int) { lines[i], lines[j] = lines[j], lines[i] })
+	return []byte("#mtree\n" + strings.Join(lines, "\n") + "\n")
+}
+
+func writeSpec(t *testing.T, dh *DirectoryHierarchy) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	_, err := dh.WriteTo(&buf)
+	require.NoError(t, err)
+	return buf.Bytes()
+}
+
+func TestCompareStreams(t *testing.T) {
+	keywords := []Keyword{"type", "size", "mode", "link", "sha256digest", "dirdigest"}
+
+	dir := t.TempDir()
+	moveTree(t, dir, map[string][]byte{
+		"a":            []byte("a\n"),
+		"b b":          []byte("b\n"),
+		"sub/c":        []byte("c\n"),
+		"sub/d":        []byte("d\n"),
+		"sub/deep/e":   []byte("e\n"),
+		"sub-2/f":      []byte("f\n"),
+		"same/g":       []byte("g\n"),
+		"gone/h":       []byte("h\n"),
+		"z/moved/i":    []byte("moved\n"),
+		"z/moved/j/k":  []byte("moved too\n"),
+		"z/empty-dir/": nil,
+	})
+	require.NoError(t, os.Symlink("a", filepath.Join(dir, "link")))
+	oldDh, err := Walk(dir, nil, keywords, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sub/c"), []byte("changed\n"), 0644))
+	require.NoError(t, os.Chmod(filepath.Join(dir, "sub/deep/e"), 0600))
+	require.NoError(t, os.RemoveAll(filepath.Join(dir, "gone")))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sub-2/new"), []byte("new\n"), 0644))
+	require.NoError(t, os.Rename(filepath.Join(dir, "z/moved"), filepath.Join(dir, "z/moved-to")))
+	require.NoError(t, os.Remove(filepath.Join(dir, "link")))
+	require.NoError(t, os.Symlink("b b", filepath.Join(dir, "link")))
+	newDh, err := Walk(dir, nil, keywords, nil)
+	require.NoError(t, err)
+
+	oldSpec, newSpec := writeSpec(t, oldDh), writeSpec(t, newDh)
+	for _, opts := range []*CompareOptions{
+		nil,
+		{Keywords: []Keyword{"type", "size", "sha256digest"}},
+		{IncludeSame: true},
+		{TrustDirDigests: true},
+		{DetectMoves: true},
+	} {
+		expected, err := CompareWithOptions(oldDh, newDh, opts)
+		require.NoError(t, err)
+		expectedSummary := streamDeltaSummary(t, expected)
+
+		// In hierarchy order, as written by Walk.
+		res := collectStreams(t, bytes.NewReader(oldSpec), bytes.NewReader(newSpec), opts)
+		assert.Equal(t, expectedSummary, streamDeltaSummary(t, res), "in order (%+v)", opts)
+
+		// Not in order, and not seekable.
+		res = collectStreams(t, io.MultiReader(bytes.NewReader(fullPathSpec(t, oldDh))), bytes.NewReader(fullPathSpec(t, newDh)), opts)
+		assert.ElementsMatch(t, expectedSummary, streamDeltaSummary(t, res), "out of order (%+v)", opts)
+	}
+}
+
+func TestCompareStreamsOrder(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string][]byte{}
+	for i := range 200 {
+		files[filepath.Join(string(rune('a'+i%7)), string(rune('a'+i%5)), strings.Repeat("x", i%13)+string(rune('a'+i%11)))] = []byte{byte(i)}
+	}
+	moveTree(t, dir, files)
+	dh, err := Walk(dir, nil, []Keyword{"type", "size"}, nil)
+	require.NoError(t, err)
+	expected, err := Compare(nil, dh, nil)
+	require.NoError(t, err)
+
+	// Sort in many small runs, merged more than once.
+	oldChunk, oldWidth := sortChunkEntries, sortMergeWidth
+	sortChunkEntries, sortMergeWidth = 3, 4
+	defer func() { sortChunkEntries, sortMergeWidth = oldChunk, oldWidth }()
+
+	tmpDir := t.TempDir()
+	res := collectStreams(t, strings.NewReader(""), bytes.NewReader(fullPathSpec(t, dh)), &CompareOptions{TempDir: tmpDir})
+	var expectedPaths, paths []string
+	for _, d := range expected {
+		expectedPaths = append(expectedPaths, d.Path())
+	}
+	for _, d := range res {
+		assert.Equal(t, Extra, d.Type())
+		paths = append(paths, d.Path())
+	}
+	assert.Equal(t, expectedPaths, paths, "results should be in hierarchy order")
+
+	// The temporary files are removed.
+	left, err := os.ReadDir(tmpDir)
+	require.NoError(t, err)
+	assert.Empty(t, left)
+}
+
+func TestCompareStreamsLongLines(t *testing.T) {
+	// An entry continued over many lines is written to the sorted runs as a
+	// single line, longer than a bufio.Scanner reads.
+	var xattrs []string
+	for i := range 64 {
+		xattrs = append(xattrs, fmt.Sprintf("xattr.user.a%d=%s", i, strings.Repeat("x", 32*1024)))
+	}
+	long := strings.Join(xattrs, " \\\n")
+	oldSpec := "#mtree\n./b type=file " + long + "\n./a type=file\n"
+	newSpec := "#mtree\n./b type=file " + long + "y\n./a type=file\n"
+
+	oldChunk := sortChunkEntries
+	sortChunkEntries = 1
+	defer func() { sortChunkEntries = oldChunk }()
+
+	res := collectStreams(t, strings.NewReader(oldSpec), strings.NewReader(newSpec), &CompareOptions{TempDir: t.TempDir()})
+	require.Len(t, res, 1)
+	assert.Equal(t, Modified, res[0].Type())
+	assert.Equal(t, "b", res[0].Path())
+}
+
+func TestCompareStreamsDuplicates(t *testing.T) {
+	oldSpec := "#mtree\n./a type=file size=1\n./b type=file size=1\n./a type=file size=2\n"
+	newSpec := "#mtree\n. type=dir\na type=file size=2\nb type=file size=3\n"
+	res := collectStreams(t, strings.NewReader(oldSpec), strings.NewReader(newSpec), nil)
+	assert.Equal(t, []string{"extra .", "modified b modified:size=1/3"}, streamDeltaSummary(t, res))
+}
+
+func TestCompareStreamsTypeChange(t *testing.T) {
+	// A file which became a directory is in a different place in hierarchy
+	// order.
+	oldSpec := "#mtree\n. type=dir\nx type=file\n"
+	newSpec := "#mtree\n. type=dir\nx type=dir\n..\n"
+	res := collectStreams(t, strings.NewReader(oldSpec), strings.NewReader(newSpec), nil)
+	assert.Equal(t, []string{"missing x", "extra x"}, streamDeltaSummary(t, res))
+}
+
+func TestCompareStreamsStop(t *testing.T) {
+	spec := "#mtree\n. type=dir\na type=file\nb type=file\nc type=file\n"
+	var paths []string
+	for delta, err := range CompareStreams(strings.NewReader(""), strings.NewReader(spec), nil) {
+		require.NoError(t, err)
+		paths = append(paths, delta.Path())
+		if len(paths) == 2 {
+			break
+		}
+	}
+	assert.Equal(t, []string{".", "a"}, paths)
+
+	var errs []error
+	for _, err := range CompareStreams(strings.NewReader(`\z type=file`), strings.NewReader(spec), nil) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	assert.Len(t, errs, 1)
+}
@@ -0,0 +1,300 @@
+package mtree
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/vbatts/go-mtree/pkg/govis"
+)
+
+// dirChild is a path in a directory, as recorded for the "dirdigest" and
+// "dirhash" keywords.
+type dirChild struct {
+	// typ is the value of the "type" keyword.
+	typ string
+	// sum is the SHA-256 digest of the contents of a regular file, or of the
+	// "link" value of a symlink. It is nil for other types.
+	sum []byte
+	// hardlink is the path of the file which a hardlink in a tar archive
+	// refers to, whose contents it has.
+	hardlink string
+}
+
+// dirDigester collects the children of each directory while walking a tree
+// or streaming a tar archive, so that the "dirdigest" and "dirhash" values of
+// the directories can be computed bottom-up once all of them are known.
+type dirDigester struct {
+	// children are the children of each directory, by name.
+	children map[string]map[string]dirChild
+	// digestDirs and hashDirs are the directories which "dirdigest" and
+	// "dirhash" were selected for.
+	digestDirs map[string]bool
+	hashDirs   map[string]bool
+	// prefix is joined to the names of files in "dirhash" values.
+	prefix string
+
+	digests map[string][]byte
+}
+
+func newDirDigester(prefix string) *dirDigester {
+	return &dirDigester{
+		children:   map[string]map[string]dirChild{".": {}},
+		digestDirs: map[string]bool{},
+		hashDirs:   map[string]bool{},
+		prefix:     prefix,
+		digests:    map[string][]byte{},
+	}
+}
+
+// selectDirDigests returns whether the "dirdigest" and "dirhash" keywords are
+// selected by keywords.
+func selectDirDigests(keywords []Keyword) (digest, hash bool) {
+	return MatchKeywords(keywords, "dirdigest"), MatchKeywords(keywords, "dirhash")
+}
+
+// ensureDir records the directory p (and its parents), if they haven't been
+// already.
+func (d *dirDigester) ensureDir(p string) {
+	if _, ok := d.children[p]; ok || p == "." {
+		return
+	}
+	d.children[p] = map[string]dirChild{}
+	parent, name := path.Dir(p), path.Base(p)
+	d.ensureDir(parent)
+	if _, ok := d.children[parent][name]; !ok {
+		d.children[parent][name] = dirChild{typ: "dir"}
+	}
+}
+
+// add records the path p, relative to the root of the tree.
+func (d *dirDigester) add(p string, child dirChild) {
+	p = path.Clean(strings.TrimPrefix(CleanPath(p), "/"))
+	if p == "." || p == "" {
+		return
+	}
+	if child.typ == "dir" {
+		d.ensureDir(p)
+		return
+	}
+	parent := path.Dir(p)
+	d.ensureDir(parent)
+	d.children[parent][path.Base(p)] = child
+}
+
+// fileSum returns the sum of a regular file, following tar hardlinks.
+func (d *dirDigester) fileSum(child dirChild) []byte {
+	for i := 0; child.hardlink != "" && i < 255; i++ {
+		target := path.Clean(strings.TrimPrefix(CleanPath(child.hardlink), "/"))
+		child = d.children[path.Dir(target)][path.Base(target)]
+	}
+	return child.sum
+}
+
+// dirDigest returns the "dirdigest" of dir: the SHA-256 digest of a line for
+// each of its children, sorted by name, giving the child's type, the digest of
+// its contents (or its own "dirdigest" if it is a directory) and its encoded
+// name.
+func (d *dirDigester) dirDigest(dir string) ([]byte, error) {
+	if sum, ok := d.digests[dir]; ok {
+		return sum, nil
+	}
+	children := d.children[dir]
+	names := make([]string, 0, len(children))
+	for name := range children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	for _, name := range names {
+		child := children[name]
+		sum := d.fileSum(child)
+		if child.typ == "dir" {
+			var err error
+			if sum, err = d.dirDigest(path.Join(dir, name)); err != nil {
+				return nil, err
+			}
+		}
+		encoded, err := govis.Vis(name, DefaultVisFlags)
+		if err != nil {
+			return nil, err
+		}
+		digest := "-"
+		if sum != nil {
+			digest = fmt.Sprintf("%x", sum)
+		}
+		fmt.Fprintf(h, "%s %s %s\n", child.typ, digest, encoded)
+	}
+	sum := h.Sum(nil)
+	d.digests[dir] = sum
+	return sum, nil
+}
+
+// dirFiles adds the sums of the regular files beneath dir to files, by their
+// paths relative to dir (with rel as a prefix).
+func (d *dirDigester) dirFiles(dir, rel string, files map[string][]byte) {
+	for name, child := range d.children[dir] {
+		switch child.typ {
+		case "dir":
+			d.dirFiles(path.Join(dir, name), path.Join(rel, name), files)
+		case "file":
+			files[path.Join(rel, name)] = d.fileSum(child)
+		}
+	}
+}
+
+// dirHash returns the "dirhash" of dir, which is the same as Go's module
+// dirhash.HashDir(dir, prefix, dirhash.Hash1): the SHA-256 digest of a line
+// for each regular file beneath dir, sorted by name, giving the SHA-256 digest
+// of the file and its path (joined to the prefix), in the "h1:" form used by
+// go.sum.
+func (d *dirDigester) dirHash(dir string) (string, error) {
+	sums := map[string][]byte{}
+	d.dirFiles(dir, d.prefix, sums)
+	files := make([]string, 0, len(sums))
+	for file := range sums {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	h := sha256.New()
+	for _, file := range files {
+		if strings.Contains(file, "\n") {
+			return "", errors.New("dirhash: filenames with newlines are not supported")
+		}
+		fmt.Fprintf(h, "%x  %s\n", sums[file], file)
+	}
+	return "h1:" + base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// apply adds the "dirdigest" and "dirhash" values to the selected directories
+// of dh.
+func (d *dirDigester) apply(dh *DirectoryHierarchy) error {
+	for i, e := range dh.Entries {
+		if e.Type != RelativeType && e.Type != FullType {
+			continue
+		}
+		p, err := e.Path()
+		if err != nil {
+			return err
+		}
+		p = path.Clean(strings.TrimPrefix(p, "/"))
+		if d.digestDirs[p] {
+			sum, err := d.dirDigest(p)
+			if err != nil {
+				return fmt.Errorf("dirdigest for %q: %w", p, err)
+			}
+			dh.Entries[i].Keywords = append(dh.Entries[i].Keywords, KeyVal(fmt.Sprintf("dirdigest=%x", sum)))
+		}
+		if d.hashDirs[p] {
+			hash, err := d.dirHash(p)
+			if err != nil {
+				return fmt.Errorf("dirhash for %q: %w", p, err)
+			}
+			dh.Entries[i].Keywords = append(dh.Entries[i].Keywords, KeyVal("dirhash="+hash))
+		}
+	}
+	return nil
+}
+
+// matchingDirDigests returns the set of paths of the directories which have
+// the same "dirdigest" value in both oldEntries and newEntries.
+func matchingDirDigests(oldEntries, newEntries map[string]Entry) map[string]bool {
+	dirs := map[string]bool{}
+	for path, old := range oldEntries {
+		if gnu, ok := newEntries[path]; ok && sameDirDigest(old, gnu) {
+			dirs[path] = true
+		}
+	}
+	return dirs
+}
+
+// sameDirDigest returns whether old and gnu are directories with the same
+// "dirdigest" value.
+func sameDirDigest(old, gnu Entry) bool {
+	if !old.IsDir() || !gnu.IsDir() {
+		return false
+	}
+	oldKV, oldHas := old.allKeysMap()["dirdigest"]
+	gnuKV, gnuHas := gnu.allKeysMap()["dirdigest"]
+	return oldHas && gnuHas && oldKV.Value() == gnuKV.Value()
+}
+
+// inDirs returns whether path is beneath one of the set of dirs (but is not
+// one of them). Only the parents of path are looked up, so this doesn't depend
+// on the number of dirs.
+func inDirs(p string, dirs map[string]bool) bool {
+	if len(dirs) == 0 {
+		return false
+	}
+	for {
+		parent := path.Dir(p)
+		if parent == p {
+			return false
+		}
+		if dirs[parent] {
+			return true
+		}
+		p = parent
+	}
+}
+
+// dirChildOf returns the dirChild for a path being walked or streamed. Its
+// type and link target are found by calling collect with the KeywordFunc of
+// those keywords. The digest of a regular file is taken from its "sha256digest"
+// in kvs, the values already collected for it, or else is computed from the
+// contents given by open. The keywords don't need to be in any registry.
+func dirChildOf(kvs []KeyVal, collect func(KeywordFunc) ([]KeyVal, error), open func() (io.ReadCloser, error)) (dirChild, error) {
+	var child dirChild
+	typ, err := collect(typeKeywordFunc)
+	if err != nil {
+		return child, err
+	}
+	if len(typ) > 0 {
+		child.typ = typ[0].Value()
+	}
+	switch child.typ {
+	case "file":
+		if sums := HasKeyword(kvs, "sha256digest"); len(sums) > 0 {
+			if sum, err := hex.DecodeString(sums[0].Value()); err == nil {
+				child.sum = sum
+				break
+			}
+		}
+		r, err := open()
+		if err != nil {
+			return child, err
+		}
+		defer r.Close()
+		h := sha256.New()
+		if _, err := io.Copy(h, r); err != nil {
+			return child, err
+		}
+		child.sum = h.Sum(nil)
+	case "link":
+		link, err := collect(linkKeywordFunc)
+		if err != nil {
+			return child, err
+		}
+		if len(link) > 0 {
+			s := sha256.Sum256([]byte(link[0].Value()))
+			child.sum = s[:]
+		}
+	}
+	return child, nil
+}
+
+// dirKeywordFunc is the KeywordFunc of the "dirdigest" and "dirhash" keywords,
+// which produces nothing, as their values are only known once the whole tree
+// has been walked.
+func dirKeywordFunc(path string, info os.FileInfo, r io.Reader) ([]KeyVal, error) {
+	return nil, nil
+}
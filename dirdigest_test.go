@@ -0,0 +1,170 @@
+package mtree
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// dirKeyword returns the value of keyword for the entry at path in dh.
+func dirKeyword(t *testing.T, dh *DirectoryHierarchy, path string, keyword Keyword) string {
+	t.Helper()
+	for _, e := range dh.Entries {
+		if e.Type != RelativeType && e.Type != FullType {
+			continue
+		}
+		p, err := e.Path()
+		require.NoError(t, err)
+		if p == path {
+			kvs := HasKeyword(e.Keywords, keyword)
+			require.Len(t, kvs, 1, "%s of %s", keyword, path)
+			return kvs[0].Value()
+		}
+	}
+	t.Fatalf("no entry for %s", path)
+	return ""
+}
+
+func TestDirDigestWalkTar(t *testing.T) {
+	keywords := []Keyword{"type", "dirdigest", "dirhash"}
+
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "sub"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a"), []byte("hello\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sub", "b"), []byte("x\n"), 0644))
+	require.NoError(t, os.Symlink("../a", filepath.Join(dir, "sub", "l")))
+	require.NoError(t, os.Link(filepath.Join(dir, "a"), filepath.Join(dir, "sub", "h")))
+	dh, err := Walk(dir, nil, keywords, nil)
+	require.NoError(t, err)
+
+	tdh := tarHierarchy(t, &TarStreamerOptions{Keywords: keywords},
+		tarFile{Header: tar.Header{Name: "./", Mode: 0755, Typeflag: tar.TypeDir}},
+		tarFile{Header: tar.Header{Name: "./a", Mode: 0644, Typeflag: tar.TypeReg}, Data: []byte("hello\n")},
+		tarFile{Header: tar.Header{Name: "./sub/", Mode: 0755, Typeflag: tar.TypeDir}},
+		tarFile{Header: tar.Header{Name: "./sub/b", Mode: 0644, Typeflag: tar.TypeReg}, Data: []byte("x\n")},
+		tarFile{Header: tar.Header{Name: "./sub/h", Mode: 0644, Linkname: "./a", Typeflag: tar.TypeLink}},
+		tarFile{Header: tar.Header{Name: "./sub/l", Mode: 0777, Linkname: "../a", Typeflag: tar.TypeSymlink}},
+	)
+
+	for _, path := range []string{".", "sub"} {
+		for _, kw := range []Keyword{"dirdigest", "dirhash"} {
+			assert.Equal(t, dirKeyword(t, dh, path, kw), dirKeyword(t, tdh, path, kw), "%s of %s", kw, path)
+		}
+	}
+	assert.NotEqual(t, dirKeyword(t, dh, ".", "dirdigest"), dirKeyword(t, dh, "sub", "dirdigest"))
+
+	// The digests of a directory and its parents change with its contents,
+	// but not those of its siblings.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sub", "b"), []byte("y\n"), 0644))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "other"), 0755))
+	dh2, err := Walk(dir, nil, keywords, nil)
+	require.NoError(t, err)
+	for _, path := range []string{".", "sub"} {
+		assert.NotEqual(t, dirKeyword(t, dh, path, "dirdigest"), dirKeyword(t, dh2, path, "dirdigest"), "dirdigest of %s", path)
+	}
+}
+
+func TestDirDigestRegistry(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a"), []byte("hello\n"), 0644))
+	require.NoError(t, os.Symlink("a", filepath.Join(dir, "l")))
+	keywords := []Keyword{"type", "dirdigest"}
+	dh, err := Walk(dir, nil, keywords, nil)
+	require.NoError(t, err)
+
+	// The digests of files don't depend on "sha256digest" being registered.
+	registry := NewKeywordRegistry()
+	for _, kw := range append(keywords, SetKeywords...) {
+		spec, ok := DefaultKeywordRegistry.Lookup(kw)
+		require.True(t, ok)
+		require.NoError(t, registry.Register(spec))
+	}
+	rdh, err := WalkWithOptions(dir, &WalkOptions{Keywords: keywords, Registry: registry})
+	require.NoError(t, err)
+	assert.Equal(t, dirKeyword(t, dh, ".", "dirdigest"), dirKeyword(t, rdh, ".", "dirdigest"))
+
+	tdh := tarHierarchy(t, &TarStreamerOptions{Keywords: keywords, Registry: registry},
+		tarFile{Header: tar.Header{Name: "./", Mode: 0755, Typeflag: tar.TypeDir}},
+		tarFile{Header: tar.Header{Name: "./a", Mode: 0644, Typeflag: tar.TypeReg}, Data: []byte("hello\n")},
+		tarFile{Header: tar.Header{Name: "./l", Mode: 0777, Linkname: "a", Typeflag: tar.TypeSymlink}},
+	)
+	assert.Equal(t, dirKeyword(t, dh, ".", "dirdigest"), dirKeyword(t, tdh, ".", "dirdigest"))
+
+	// Nor do they change when "sha256digest" is also collected.
+	sdh, err := Walk(dir, nil, append(keywords, "sha256digest"), nil)
+	require.NoError(t, err)
+	assert.Equal(t, dirKeyword(t, dh, ".", "dirdigest"), dirKeyword(t, sdh, ".", "dirdigest"))
+}
+
+func TestDirHash(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "sub"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a"), []byte("hello\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sub", "b"), []byte("x\n"), 0644))
+	// Only regular files are hashed, as with Go's dirhash.
+	require.NoError(t, os.Symlink("a", filepath.Join(dir, "l")))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "empty"), 0755))
+
+	dh, err := WalkWithOptions(dir, &WalkOptions{
+		Keywords:      []Keyword{"type", "dirhash"},
+		DirHashPrefix: "example.com/mod@v1.0.0",
+	})
+	require.NoError(t, err)
+	// The same as dirhash.HashDir(dir, "example.com/mod@v1.0.0", dirhash.Hash1).
+	assert.Equal(t, "h1:Q4EZEAQLQKYnaXt+eZGCxVwXjQIuA8+U04PRCUw0cCE=", dirKeyword(t, dh, ".", "dirhash"))
+}
+
+func TestTrustDirDigests(t *testing.T) {
+	keywords := []Keyword{"type", "mode", "sha256digest", "dirdigest"}
+
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "sub"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sub", "b"), []byte("x\n"), 0644))
+	dh, err := Walk(dir, nil, keywords, nil)
+	require.NoError(t, err)
+
+	// A change to the mode isn't covered by the digest, so is skipped.
+	require.NoError(t, os.Chmod(filepath.Join(dir, "sub", "b"), 0600))
+	newDh, err := Walk(dir, nil, keywords, nil)
+	require.NoError(t, err)
+	res, err := CompareWithOptions(dh, newDh, &CompareOptions{Keywords: keywords})
+	require.NoError(t, err)
+	assert.Len(t, res, 1)
+	res, err = CompareWithOptions(dh, newDh, &CompareOptions{Keywords: keywords, TrustDirDigests: true})
+	require.NoError(t, err)
+	assert.Empty(t, res)
+
+	// Changes to the contents are still found.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sub", "b"), []byte("y\n"), 0600))
+	newDh, err = Walk(dir, nil, keywords, nil)
+	require.NoError(t, err)
+	res, err = CompareWithOptions(dh, newDh, &CompareOptions{Keywords: keywords, TrustDirDigests: true})
+	require.NoError(t, err)
+	paths := []string{}
+	for _, d := range res {
+		paths = append(paths, d.Path())
+	}
+	assert.ElementsMatch(t, []string{".", "sub", "sub/b"}, paths)
+}
+
+func TestInDirs(t *testing.T) {
+	dirs := map[string]bool{"usr/lib": true, "etc": true}
+	for path, expect := range map[string]bool{
+		"usr/lib/a":     true,
+		"usr/lib/sub/b": true,
+		"etc/passwd":    true,
+		"usr/lib":       false,
+		"usr/libexec/c": false,
+		"usr":           false,
+		".":             false,
+	} {
+		assert.Equal(t, expect, inDirs(path, dirs), path)
+	}
+	assert.True(t, inDirs("usr", map[string]bool{".": true}))
+	assert.False(t, inDirs(".", map[string]bool{".": true}))
+	assert.False(t, inDirs("usr/lib/a", nil))
+}
@@ -0,0 +1,96 @@
+package mtree
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"os"
+)
+
+// ntGNUBuildID is the type of the GNU build ID note (NT_GNU_BUILD_ID).
+const ntGNUBuildID = 3
+
+// elfBuildID returns the GNU build ID of the ELF file f, from its
+// SHT_NOTE sections or, if it has no section headers (as with sstrip), its
+// PT_NOTE segments. It returns nil if there is no build ID.
+func elfBuildID(f *elf.File) ([]byte, error) {
+	var notes []io.ReadSeeker
+	for _, s := range f.Sections {
+		if s.Type == elf.SHT_NOTE {
+			notes = append(notes, s.Open())
+		}
+	}
+	if len(notes) == 0 {
+		for _, p := range f.Progs {
+			if p.Type == elf.PT_NOTE {
+				notes = append(notes, p.Open())
+			}
+		}
+	}
+	for _, r := range notes {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		if id := findELFNote(data, f.ByteOrder, "GNU", ntGNUBuildID); id != nil {
+			return id, nil
+		}
+	}
+	return nil, nil
+}
+
+// findELFNote returns the descriptor of the note with the given name and type
+// in data, which is a series of Elf_Nhdr headers each followed by the name and
+// descriptor, padded to 4 bytes.
+func findELFNote(data []byte, order binary.ByteOrder, name string, typ uint32) []byte {
+	// The sizes are padded as uint64, so that they can't overflow.
+	align := func(n uint32) uint64 { return (uint64(n) + 3) &^ 3 }
+	for len(data) >= 12 {
+		namesz, descsz, ntype := order.Uint32(data[0:]), order.Uint32(data[4:]), order.Uint32(data[8:])
+		data = data[12:]
+		if align(namesz)+align(descsz) > uint64(len(data)) {
+			return nil
+		}
+		noteName := bytes.TrimRight(data[:namesz], "\x00")
+		desc := data[align(namesz) : align(namesz)+uint64(descsz)]
+		if ntype == typ && string(noteName) == name {
+			return desc
+		}
+		data = data[align(namesz)+align(descsz):]
+	}
+	return nil
+}
+
+// elfKeywordFunc returns the GNU build ID of ELF files, such as executables
+// and shared libraries, as "elf.buildid". Other files, and ELF files which
+// can't be parsed, have no value.
+func elfKeywordFunc(path string, info os.FileInfo, r io.Reader) ([]KeyVal, error) {
+	if r == nil || !info.Mode().IsRegular() {
+		return nil, nil
+	}
+	// debug/elf needs an io.ReaderAt, which files (including the temporary
+	// files of the tar streamer) are.
+	ra, ok := r.(io.ReaderAt)
+	if !ok {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		ra = bytes.NewReader(data)
+	}
+	magic := make([]byte, len(elf.ELFMAG))
+	if _, err := ra.ReadAt(magic, 0); err != nil || string(magic) != elf.ELFMAG {
+		return nil, nil
+	}
+	f, err := elf.NewFile(ra)
+	if err != nil {
+		return nil, nil
+	}
+	id, err := elfBuildID(f)
+	if err != nil || id == nil {
+		return nil, nil
+	}
+	return []KeyVal{KeyVal("elf.buildid=" + hex.EncodeToString(id))}, nil
+}
@@ -0,0 +1,98 @@
+package mtree
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testELF returns a minimal little-endian ELF64 executable, with the GNU build
+// ID id in a PT_NOTE segment and no section headers (as after sstrip).
+func testELF(t *testing.T, id []byte) []byte {
+	var note bytes.Buffer
+	for _, v := range []uint32{4, uint32(len(id)), ntGNUBuildID} {
+		require.NoError(t, binary.Write(&note, binary.LittleEndian, v))
+	}
+	note.WriteString("GNU\x00")
+	note.Write(id)
+
+	hdr := elf.Header64{
+		Type:      uint16(elf.ET_EXEC),
+		Machine:   uint16(elf.EM_X86_64),
+		Version:   uint32(elf.EV_CURRENT),
+		Phoff:     64,
+		Ehsize:    64,
+		Phentsize: 56,
+		Phnum:     1,
+	}
+	copy(hdr.Ident[:], elf.ELFMAG)
+	hdr.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	hdr.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	hdr.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	prog := elf.Prog64{
+		Type:   uint32(elf.PT_NOTE),
+		Flags:  uint32(elf.PF_R),
+		Off:    64 + 56,
+		Filesz: uint64(note.Len()),
+		Memsz:  uint64(note.Len()),
+		Align:  4,
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, binary.Write(&buf, binary.LittleEndian, hdr))
+	require.NoError(t, binary.Write(&buf, binary.LittleEndian, prog))
+	buf.Write(note.Bytes())
+	return buf.Bytes()
+}
+
+func TestELFBuildID(t *testing.T) {
+	const want = "elf.buildid=0123456789abcdef0123456789abcdef01234567"
+	files := map[string][]byte{
+		"prog":  testELF(t, []byte{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef, 0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef, 0x01, 0x23, 0x45, 0x67}),
+		"text":  []byte("not an ELF file\n"),
+		"short": []byte(elf.ELFMAG),
+	}
+
+	assert.Contains(t, DefaultKeywordRegistry.Keywords(), Keyword("elf.buildid"))
+	assert.NotContains(t, DefaultKeywordRegistry.Keywords(), Keyword("elf"))
+
+	keywords := []Keyword{"type", "elf.buildid"}
+	for name, dh := range walkAndTarHierarchies(t, nil, keywords, files) {
+		t.Run(name, func(t *testing.T) {
+			ids := map[string][]KeyVal{}
+			for _, e := range dh.Entries {
+				ids[e.Name] = HasKeyword(e.Keywords, "elf.buildid")
+			}
+			assert.Equal(t, []KeyVal{want}, ids["prog"])
+			assert.Empty(t, ids["text"])
+			assert.Empty(t, ids["short"])
+		})
+	}
+}
+
+func TestFindELFNoteMalformed(t *testing.T) {
+	note := func(namesz, descsz, typ uint32, rest ...byte) []byte {
+		buf := binary.LittleEndian.AppendUint32(nil, namesz)
+		buf = binary.LittleEndian.AppendUint32(buf, descsz)
+		buf = binary.LittleEndian.AppendUint32(buf, typ)
+		return append(buf, rest...)
+	}
+	for name, data := range map[string][]byte{
+		"truncated header": note(4, 4, ntGNUBuildID)[:10],
+		"truncated note":   note(4, 20, ntGNUBuildID, 'G', 'N', 'U', 0, 1, 2),
+		"oversized name":   note(0xfffffffe, 0, ntGNUBuildID, 'G', 'N', 'U', 0),
+		"oversized desc":   note(4, 0xfffffffe, ntGNUBuildID, 'G', 'N', 'U', 0),
+		"oversized both":   note(0xffffffff, 0xffffffff, ntGNUBuildID),
+	} {
+		assert.NotPanics(t, func() {
+			assert.Nil(t, findELFNote(data, binary.LittleEndian, "GNU", ntGNUBuildID), name)
+		}, name)
+	}
+
+	id := []byte{1, 2, 3, 4}
+	assert.Equal(t, id, findELFNote(note(4, 4, ntGNUBuildID, append([]byte("GNU\x00"), id...)...), binary.LittleEndian, "GNU", ntGNUBuildID))
+}
@@ -0,0 +1,273 @@
+package mtree
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// DefaultIgnoreFile is the conventional name of a per-directory ignore file,
+// for use with NewPatternExcluder.
+const DefaultIgnoreFile = ".mtreeignore"
+
+// NewPatternExcluder returns an ExcludeFunc which excludes the paths beneath
+// root that match patterns, using the same syntax and semantics as
+// gitignore(5):
+//
+//   - a pattern without a '/' (other than a trailing one) matches a name at
+//     any depth, otherwise it is anchored to root;
+//   - a trailing '/' only matches directories;
+//   - "**/", "/**" and "/**/" match any number of directories;
+//   - a leading '!' re-includes a path excluded by an earlier pattern, and
+//     the last matching pattern wins;
+//   - nothing beneath an excluded directory can be re-included.
+//
+// ignoreFiles are the names of per-directory ignore files (such as
+// DefaultIgnoreFile). When one is present in a directory beneath root, its
+// patterns apply to the contents of that directory, relative to it, and take
+// precedence over those of parent directories and over patterns. Ignore files
+// are only read from the filesystem, so they have no effect if root is empty.
+//
+// The paths passed to the ExcludeFunc are expected to be beneath root, as
+// passed by Walk. Paths which are not (such as the header names passed by
+// NewTarStreamer) are treated as relative to root.
+func NewPatternExcluder(root string, patterns []string, ignoreFiles ...string) ExcludeFunc {
+	pe := &patternExcluder{
+		root:        root,
+		patterns:    compileExcludePatterns(".", patterns),
+		ignoreFiles: ignoreFiles,
+		dirPatterns: map[string][]excludePattern{},
+		excludedDir: map[string]bool{},
+	}
+	return pe.exclude
+}
+
+// ReadExcludePatterns reads gitignore(5) style patterns from r, one per line.
+// Blank lines and lines beginning with '#' are ignored, as are trailing spaces
+// unless they are escaped with a backslash.
+func ReadExcludePatterns(r io.Reader) ([]string, error) {
+	var patterns []string
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := trimTrailingSpace(strings.TrimSuffix(s.Text(), "\r"))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns, s.Err()
+}
+
+func trimTrailingSpace(line string) string {
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+	return line
+}
+
+type patternExcluder struct {
+	root        string
+	patterns    []excludePattern
+	ignoreFiles []string
+
+	mu          sync.Mutex
+	dirPatterns map[string][]excludePattern
+	excludedDir map[string]bool
+}
+
+func (pe *patternExcluder) exclude(p string, info os.FileInfo) bool {
+	rel := pe.relPath(p)
+	if rel == "." {
+		return false
+	}
+
+	pe.mu.Lock()
+	defer pe.mu.Unlock()
+
+	// A path beneath an excluded directory is always excluded.
+	parts := strings.Split(rel, "/")
+	for i := 1; i < len(parts); i++ {
+		dir := strings.Join(parts[:i], "/")
+		excluded, ok := pe.excludedDir[dir]
+		if !ok {
+			excluded = pe.match(dir, true)
+			pe.excludedDir[dir] = excluded
+		}
+		if excluded {
+			return true
+		}
+	}
+	return pe.match(rel, info != nil && info.IsDir())
+}
+
+// relPath returns p relative to the root, in slash-separated form.
+func (pe *patternExcluder) relPath(p string) string {
+	if pe.root != "" {
+		if rel, err := filepath.Rel(pe.root, p); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return filepath.ToSlash(rel)
+		}
+	}
+	return filepath.ToSlash(CleanPath(p))
+}
+
+// match returns whether the relative path rel is excluded by the patterns,
+// ignoring whether any of its parent directories are. pe.mu must be held.
+func (pe *patternExcluder) match(rel string, isDir bool) bool {
+	excluded := false
+	check := func(patterns []excludePattern) {
+		for _, pat := range patterns {
+			if pat.match(rel, isDir) {
+				excluded = !pat.negate
+			}
+		}
+	}
+	check(pe.patterns)
+	if len(pe.ignoreFiles) > 0 {
+		check(pe.loadDir("."))
+		for i := strings.Index(rel, "/"); i >= 0; i = nextSlash(rel, i) {
+			check(pe.loadDir(rel[:i]))
+		}
+	}
+	return excluded
+}
+
+func nextSlash(s string, i int) int {
+	j := strings.Index(s[i+1:], "/")
+	if j < 0 {
+		return -1
+	}
+	return i + 1 + j
+}
+
+// loadDir returns the patterns from the ignore files in the relative
+// directory dir. pe.mu must be held.
+func (pe *patternExcluder) loadDir(dir string) []excludePattern {
+	if patterns, ok := pe.dirPatterns[dir]; ok {
+		return patterns
+	}
+	var patterns []excludePattern
+	if pe.root != "" {
+		for _, name := range pe.ignoreFiles {
+			fh, err := os.Open(filepath.Join(pe.root, filepath.FromSlash(dir), name))
+			if err != nil {
+				continue
+			}
+			lines, err := ReadExcludePatterns(fh)
+			fh.Close()
+			if err != nil {
+				continue
+			}
+			patterns = append(patterns, compileExcludePatterns(dir, lines)...)
+		}
+	}
+	pe.dirPatterns[dir] = patterns
+	return patterns
+}
+
+// excludePattern is a single compiled gitignore(5) pattern.
+type excludePattern struct {
+	// base is the directory the pattern is relative to.
+	base string
+	// segments are the path.Match patterns for each path component, where
+	// "**" matches any number of components.
+	segments []string
+	negate   bool
+	dirOnly  bool
+}
+
+func compileExcludePatterns(base string, lines []string) []excludePattern {
+	var patterns []excludePattern
+	for _, line := range lines {
+		if pat, ok := compileExcludePattern(base, line); ok {
+			patterns = append(patterns, pat)
+		}
+	}
+	return patterns
+}
+
+func compileExcludePattern(base, line string) (excludePattern, bool) {
+	pat := excludePattern{base: base}
+	line = trimTrailingSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return pat, false
+	}
+	if strings.HasPrefix(line, "!") {
+		pat.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		pat.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return pat, false
+	}
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	if !anchored {
+		pat.segments = append(pat.segments, "**")
+	}
+	for _, seg := range strings.Split(line, "/") {
+		if seg == "" {
+			continue
+		}
+		if seg != "**" {
+			seg = strings.ReplaceAll(seg, "**", "*")
+			seg = strings.ReplaceAll(seg, "[!", "[^")
+			// Patterns which path.Match cannot handle never match, the
+			// same as git treats them.
+			if _, err := path.Match(seg, ""); err != nil {
+				return pat, false
+			}
+		}
+		pat.segments = append(pat.segments, seg)
+	}
+	return pat, true
+}
+
+// match returns whether the pattern matches the relative path rel.
+func (pat excludePattern) match(rel string, isDir bool) bool {
+	if pat.dirOnly && !isDir {
+		return false
+	}
+	if pat.base != "." {
+		if !strings.HasPrefix(rel, pat.base+"/") {
+			return false
+		}
+		rel = rel[len(pat.base)+1:]
+	}
+	return matchSegments(pat.segments, strings.Split(rel, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			rest := pattern[1:]
+			if len(rest) == 0 {
+				// A trailing "/**" matches everything inside, but not the
+				// directory itself.
+				return len(name) > 0
+			}
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(rest, name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
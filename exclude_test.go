@@ -0,0 +1,159 @@
+package mtree
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type excludeFileInfo struct {
+	os.FileInfo
+	dir bool
+}
+
+func (fi excludeFileInfo) IsDir() bool { return fi.dir }
+
+func TestReadExcludePatterns(t *testing.T) {
+	patterns, err := ReadExcludePatterns(strings.NewReader("# comment\n\n*.o\ntrailing  \nescaped\\ \n\\#hash\n!keep.o\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"*.o", "trailing", "escaped\\ ", "\\#hash", "!keep.o"}, patterns)
+}
+
+func TestPatternExcluder(t *testing.T) {
+	ex := NewPatternExcluder("/root", []string{
+		"*.o",
+		"!keep.o",
+		"/build",
+		"cache/",
+		"doc/**/*.html",
+		"logs/**",
+		"**/tmp/*.swp",
+		"a/b",
+		"[!x]y",
+		"\\!bang",
+	})
+
+	for _, test := range []struct {
+		path     string
+		dir      bool
+		excluded bool
+	}{
+		{"/root", true, false},
+		{"/root/main.o", false, true},
+		{"/root/src/deep/main.o", false, true},
+		{"/root/keep.o", false, false},
+		{"/root/src/keep.o", false, false},
+		{"/root/build", true, true},
+		{"/root/build/output", false, true},
+		{"/root/src/build", true, false},
+		{"/root/cache", true, true},
+		{"/root/cache", false, false},
+		{"/root/src/cache", true, true},
+		{"/root/src/cache/file", false, true},
+		{"/root/doc/index.html", false, true},
+		{"/root/doc/a/b/index.html", false, true},
+		{"/root/src/doc/index.html", false, false},
+		{"/root/logs", true, false},
+		{"/root/logs/today", false, true},
+		{"/root/tmp/x.swp", false, true},
+		{"/root/a/tmp/x.swp", false, true},
+		{"/root/a/b", false, true},
+		{"/root/c/a/b", false, false},
+		{"/root/ay", false, true},
+		{"/root/xy", false, false},
+		{"/root/!bang", false, true},
+		{"/root/bang", false, false},
+		// paths from a tar archive are relative to root
+		{"build/output", false, true},
+		{"./src/main.o", false, true},
+		{"src/main.c", false, false},
+	} {
+		t.Run(test.path, func(t *testing.T) {
+			assert.Equal(t, test.excluded, ex(test.path, excludeFileInfo{dir: test.dir}))
+		})
+	}
+}
+
+func TestPatternExcluderNoReinclude(t *testing.T) {
+	// A file beneath an excluded directory cannot be re-included.
+	ex := NewPatternExcluder("/root", []string{"dir/", "!dir/file"})
+	assert.True(t, ex("/root/dir", excludeFileInfo{dir: true}))
+	assert.True(t, ex("/root/dir/file", excludeFileInfo{}))
+
+	// But the contents of a directory can be.
+	ex = NewPatternExcluder("/root", []string{"dir/*", "!dir/file"})
+	assert.False(t, ex("/root/dir", excludeFileInfo{dir: true}))
+	assert.True(t, ex("/root/dir/other", excludeFileInfo{}))
+	assert.False(t, ex("/root/dir/file", excludeFileInfo{}))
+}
+
+func TestPatternExcluderIgnoreFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"a.log", "b.txt", "keep.log",
+		"sub/a.log", "sub/b.txt", "sub/c.txt",
+		"sub/deep/b.txt", "other/b.txt",
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(name), 0644))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(dir, DefaultIgnoreFile), []byte("*.log\n!keep.log\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sub", DefaultIgnoreFile), []byte("# sub\n*.txt\n!/c.txt\n"), 0644))
+
+	ex := NewPatternExcluder(dir, []string{"deep/"}, DefaultIgnoreFile)
+	dh, err := Walk(dir, []ExcludeFunc{ex}, []Keyword{"type"}, nil)
+	require.NoError(t, err)
+
+	var paths []string
+	for _, e := range dh.Entries {
+		if e.Type == RelativeType {
+			p, err := e.Path()
+			require.NoError(t, err)
+			paths = append(paths, p)
+		}
+	}
+	assert.ElementsMatch(t, []string{
+		".", DefaultIgnoreFile, "b.txt", "keep.log",
+		"other", "other/b.txt",
+		"sub", "sub/" + DefaultIgnoreFile, "sub/c.txt",
+	}, paths)
+}
+
+func TestPatternExcluderTar(t *testing.T) {
+	// The same patterns should give the same results for Walk and for the
+	// TarStreamer, including for entries beneath an excluded directory.
+	buf, err := makeTarStream([]fakeFile{
+		{Name: "./", Mode: 0755, Type: '5'},
+		{Name: "./build/", Mode: 0755, Type: '5'},
+		{Name: "./build/out.o", Body: "out", Mode: 0644, Type: '0'},
+		{Name: "./src/", Mode: 0755, Type: '5'},
+		{Name: "./src/main.c", Body: "main", Mode: 0644, Type: '0'},
+		{Name: "./src/main.o", Body: "main", Mode: 0644, Type: '0'},
+		{Name: "./logs/a/b", Body: "b", Mode: 0644, Type: '0'},
+	})
+	require.NoError(t, err)
+
+	ex := NewPatternExcluder("", []string{"build/", "*.o", "logs/"})
+	str := NewTarStreamer(bytes.NewReader(buf), []ExcludeFunc{ex}, []Keyword{"type"})
+	_, err = io.Copy(io.Discard, str)
+	require.NoError(t, err, "read full tar stream")
+	require.NoError(t, str.Close(), "close tar stream")
+	tdh, err := str.Hierarchy()
+	require.NoError(t, err)
+
+	var paths []string
+	for _, e := range tdh.Entries {
+		if e.Type == RelativeType {
+			p, err := e.Path()
+			require.NoError(t, err)
+			paths = append(paths, p)
+		}
+	}
+	assert.ElementsMatch(t, []string{".", "src", "src/main.c"}, paths)
+}
@@ -0,0 +1,139 @@
+package mtree
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/vbatts/go-mtree/pkg/govis"
+)
+
+// DefaultExecTimeout is how long the command of an "exec.<name>" keyword may
+// run for each file, if ExecOptions.Timeout is zero.
+const DefaultExecTimeout = 30 * time.Second
+
+// ExecOptions control how the commands of "exec.<name>" keywords are run.
+type ExecOptions struct {
+	// Timeout is how long the command may run for each file, after which it
+	// is killed. If zero, then DefaultExecTimeout is used.
+	Timeout time.Duration
+
+	// MaxConcurrent is the most commands which may be running at once,
+	// across all of the keywords created together. If zero, then the number
+	// of CPUs is used.
+	MaxConcurrent int
+}
+
+// execEnvKeywords are the keywords whose values are given to the commands of
+// "exec.<name>" keywords, as environment variables such as MTREE_SIZE.
+var execEnvKeywords = []struct {
+	name    string
+	collect KeywordFunc
+}{
+	{"type", typeKeywordFunc},
+	{"size", sizeKeywordFunc},
+	{"mode", modeKeywordFunc},
+	{"uid", uidKeywordFunc},
+	{"gid", gidKeywordFunc},
+	{"time", timeKeywordFunc},
+}
+
+// ExecKeywordSpecs returns the KeywordSpecs of keywords whose values are
+// produced by external commands, for registering in a KeywordRegistry. Each
+// of defs is of the form "exec.<name>=/path/to/tool".
+//
+// The command is run for each file, with the contents of regular files on its
+// standard input (other files have an empty input), and the path of the file
+// in the MTREE_PATH environment variable. The values of the "type", "size",
+// "mode", "uid", "gid" and "time" keywords are given in MTREE_TYPE,
+// MTREE_SIZE, and so on. Its standard output, with surrounding whitespace
+// trimmed and encoded with Vis, is the value of the keyword; files for which
+// it writes nothing have no value. It is an error for the command to fail or
+// to time out. The values are compared as opaque strings.
+func ExecKeywordSpecs(defs []string, opts ExecOptions) ([]KeywordSpec, error) {
+	if opts.Timeout == 0 {
+		opts.Timeout = DefaultExecTimeout
+	}
+	if opts.MaxConcurrent <= 0 {
+		opts.MaxConcurrent = runtime.NumCPU()
+	}
+	sem := make(chan struct{}, opts.MaxConcurrent)
+
+	var specs []KeywordSpec
+	for _, def := range defs {
+		kv := KeyVal(def)
+		name, command := kv.Keyword(), kv.Value()
+		if name.Prefix() != "exec" || name.Suffix() == "" || name == "exec" || !strings.Contains(def, "=") {
+			return nil, fmt.Errorf("invalid exec keyword %q: must be exec.<name>=<command>", def)
+		}
+		if command == "" {
+			return nil, fmt.Errorf("invalid exec keyword %q: missing command", def)
+		}
+		specs = append(specs, KeywordSpec{
+			Name:            name,
+			Collect:         execKeywordFunc(name, command, opts.Timeout, sem),
+			ConsumesContent: true,
+		})
+	}
+	return specs, nil
+}
+
+// execKeywordFunc returns the KeywordFunc of the keyword name, which runs
+// command for each file, with at most cap(sem) running at once.
+func execKeywordFunc(name Keyword, command string, timeout time.Duration, sem chan struct{}) KeywordFunc {
+	return func(path string, info os.FileInfo, r io.Reader) ([]KeyVal, error) {
+		env := append(os.Environ(), "MTREE_PATH="+path)
+		for _, kw := range execEnvKeywords {
+			kvs, err := kw.collect(path, info, nil)
+			if err != nil {
+				return nil, err
+			}
+			if len(kvs) > 0 {
+				env = append(env, "MTREE_"+strings.ToUpper(kw.name)+"="+kvs[0].Value())
+			}
+		}
+
+		sem <- struct{}{}
+		defer func() { <-sem }()
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, command)
+		cmd.Env = env
+		if info.Mode().IsRegular() {
+			cmd.Stdin = r
+		}
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		// Don't wait for the input to be copied or the output to be closed
+		// by any children of a command which has been killed.
+		cmd.WaitDelay = time.Second
+		if err := cmd.Run(); err != nil {
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return nil, fmt.Errorf("%s for %q: %s timed out after %s", name, path, command, timeout)
+			}
+			if msg := strings.TrimSpace(stderr.String()); msg != "" {
+				return nil, fmt.Errorf("%s for %q: %s: %w: %s", name, path, command, err, msg)
+			}
+			return nil, fmt.Errorf("%s for %q: %s: %w", name, path, command, err)
+		}
+
+		value := strings.TrimSpace(stdout.String())
+		if value == "" {
+			return nil, nil
+		}
+		encoded, err := govis.Vis(value, DefaultVisFlags)
+		if err != nil {
+			return nil, err
+		}
+		return []KeyVal{KeyVal(string(name) + "=" + encoded)}, nil
+	}
+}
@@ -0,0 +1,143 @@
+package mtree
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTool writes a shell script to dir, returning its path.
+func writeTool(t *testing.T, dir, name, script string) string {
+	t.Helper()
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("skipping: no sh")
+	}
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0755))
+	return path
+}
+
+func TestExecKeywords(t *testing.T) {
+	tools := t.TempDir()
+	license := writeTool(t, tools, "license", `head -n 1`)
+	attrs := writeTool(t, tools, "attrs", `if [ "$MTREE_TYPE" = file ]; then echo "$(basename "$MTREE_PATH") $MTREE_SIZE $MTREE_MODE"; fi`)
+	specs, err := ExecKeywordSpecs([]string{"exec.license=" + license, "exec.attrs=" + attrs}, ExecOptions{})
+	require.NoError(t, err)
+	registry := DefaultKeywordRegistry.Clone()
+	for _, spec := range specs {
+		require.NoError(t, registry.Register(spec))
+	}
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "mit"), []byte("MIT License\n\nCopyright\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "empty"), nil, 0600))
+
+	for _, keywords := range [][]Keyword{
+		{"type", "exec.license", "exec.attrs"},
+		{"type", "exec"},
+		{"type", "exec.*"},
+	} {
+		dh, err := WalkWithOptions(dir, &WalkOptions{Keywords: keywords, Registry: registry})
+		require.NoError(t, err, "%v", keywords)
+		values := map[string][]KeyVal{}
+		for _, e := range dh.Entries {
+			if e.Type == RelativeType {
+				values[e.Name] = e.Keywords
+			}
+		}
+		assert.ElementsMatch(t, []KeyVal{"exec.license=MIT\\040License", "exec.attrs=mit\\04023\\0400644"}, HasKeyword(values["mit"], "exec"), "%v", keywords)
+		// Files for which there is no output have no value.
+		assert.Equal(t, []KeyVal{"exec.attrs=empty\\0400\\0400600"}, HasKeyword(values["empty"], "exec"), "%v", keywords)
+		assert.Empty(t, HasKeyword(values["."], "exec"), "%v", keywords)
+
+	}
+
+	// Only the selected keywords are run.
+	dh, err := WalkWithOptions(dir, &WalkOptions{Keywords: []Keyword{"type", "exec.attrs"}, Registry: registry})
+	require.NoError(t, err)
+	for _, e := range dh.Entries {
+		for _, kv := range e.Keywords {
+			assert.NotEqual(t, Keyword("exec.license"), kv.Keyword())
+		}
+	}
+
+	// The values are compared as opaque strings.
+	dh, err = WalkWithOptions(dir, &WalkOptions{Keywords: []Keyword{"type", "exec.license"}, Registry: registry})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "mit"), []byte("Apache License\n"), 0644))
+	res, err := CheckWithOptions(dir, dh, &CheckOptions{Registry: registry})
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	assert.Equal(t, "mit", res[0].Path())
+	assert.Equal(t, Keyword("exec.license"), res[0].Diff()[0].Name())
+}
+
+func TestExecKeywordErrors(t *testing.T) {
+	tools := t.TempDir()
+	fail := writeTool(t, tools, "fail", `echo "bad signature" >&2; exit 1`)
+	slow := writeTool(t, tools, "slow", `sleep 5`)
+
+	for _, defs := range [][]string{
+		{"exec=" + fail},
+		{"exec.=" + fail},
+		{"sha1.foo=" + fail},
+		{"exec.foo"},
+		{"exec.foo="},
+	} {
+		_, err := ExecKeywordSpecs(defs, ExecOptions{})
+		assert.Error(t, err, "%v", defs)
+	}
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "file"), []byte("data"), 0644))
+	specs, err := ExecKeywordSpecs([]string{"exec.fail=" + fail, "exec.slow=" + slow}, ExecOptions{Timeout: 100 * time.Millisecond})
+	require.NoError(t, err)
+	registry := DefaultKeywordRegistry.Clone()
+	for _, spec := range specs {
+		require.NoError(t, registry.Register(spec))
+	}
+
+	_, err = WalkWithOptions(dir, &WalkOptions{Keywords: []Keyword{"exec.fail"}, Registry: registry})
+	assert.ErrorContains(t, err, "bad signature")
+	start := time.Now()
+	_, err = WalkWithOptions(dir, &WalkOptions{Keywords: []Keyword{"exec.slow"}, Registry: registry})
+	assert.ErrorContains(t, err, "timed out")
+	assert.Less(t, time.Since(start), 4*time.Second)
+}
+
+func TestExecKeywordConcurrency(t *testing.T) {
+	tools := t.TempDir()
+	lock := filepath.Join(t.TempDir(), "lock")
+	// The tool fails if another is running at the same time.
+	tool := writeTool(t, tools, "exclusive", `mkdir "`+lock+`" || exit 1; sleep 0.05; rmdir "`+lock+`"; echo ok`)
+	specs, err := ExecKeywordSpecs([]string{"exec.a=" + tool, "exec.b=" + tool}, ExecOptions{MaxConcurrent: 1})
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 4; i++ {
+		for _, spec := range specs {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				info, err := os.Stat(dir)
+				if err == nil {
+					_, err = spec.Collect(dir, info, nil)
+				}
+				errs <- err
+			}()
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+}
@@ -0,0 +1,43 @@
+package mtree
+
+import (
+	"sort"
+	"strings"
+)
+
+// parseFlags splits a "flags" value into its canonical flag names, in sorted
+// order and without duplicates. "none" (or an empty value) is no flags.
+func parseFlags(v string) []string {
+	seen := map[string]bool{}
+	var names []string
+	for _, name := range strings.Split(v, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || name == "none" {
+			continue
+		}
+		name = canonicalFlag(name)
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// compareFlags compares "flags" values as sets of flags, so that the order of
+// the flags and the use of synonyms (such as "immutable" for "uchg") doesn't
+// matter. Flags which are the same on this platform (such as "schg" and "uchg"
+// on Linux, which has no system immutable flag) are treated as equal.
+func compareFlags(old, new KeyVal, opts *CompareOptions) bool {
+	a, b := parseFlags(old.Value()), parseFlags(new.Value())
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
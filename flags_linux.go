@@ -0,0 +1,214 @@
+//go:build linux
+// +build linux
+
+package mtree
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// linuxFileFlags are the inode flags (see ioctl_iflags(2)) which are written
+// in the "flags" keyword, with the BSD name for each where there is one. The
+// first name is the one written in manifests. Flags which are not in this list
+// (such as FS_EXTENT_FL) are managed by the filesystem, and are neither
+// recorded nor changed.
+var linuxFileFlags = []struct {
+	names []string
+	bit   uint32
+}{
+	{[]string{"secrm"}, 0x00000001},                                           // FS_SECRM_FL
+	{[]string{"undel"}, 0x00000002},                                           // FS_UNRM_FL
+	{[]string{"compress"}, 0x00000004},                                        // FS_COMPR_FL
+	{[]string{"sync"}, 0x00000008},                                            // FS_SYNC_FL
+	{[]string{"uchg", "schg", "immutable", "uimmutable", "simmutable"}, 0x10}, // FS_IMMUTABLE_FL
+	{[]string{"uappnd", "sappnd", "append", "uappend", "sappend"}, 0x20},      // FS_APPEND_FL
+	{[]string{"nodump"}, 0x00000040},                                          // FS_NODUMP_FL
+	{[]string{"noatime"}, 0x00000080},                                         // FS_NOATIME_FL
+	{[]string{"journal-data"}, 0x00004000},                                    // FS_JOURNAL_DATA_FL
+	{[]string{"notail"}, 0x00008000},                                          // FS_NOTAIL_FL
+	{[]string{"dirsync"}, 0x00010000},                                         // FS_DIRSYNC_FL
+	{[]string{"topdir"}, 0x00020000},                                          // FS_TOPDIR_FL
+	{[]string{"nocow"}, 0x00800000},                                           // FS_NOCOW_FL
+	{[]string{"projinherit"}, 0x20000000},                                     // FS_PROJINHERIT_FL
+}
+
+// linuxFileFlagsMask is all of the bits in linuxFileFlags.
+var linuxFileFlagsMask = func() uint32 {
+	var mask uint32
+	for _, f := range linuxFileFlags {
+		mask |= f.bit
+	}
+	return mask
+}()
+
+// canonicalFlag returns the name written in manifests for the flag name,
+// which is the same for all of the BSD flags that map to one inode flag.
+func canonicalFlag(name string) string {
+	for _, f := range linuxFileFlags {
+		for _, n := range f.names {
+			if n == name {
+				return f.names[0]
+			}
+		}
+	}
+	return name
+}
+
+// formatLinuxFlags returns the "flags" value for the inode flags bits, or
+// "none" if none of linuxFileFlags are set.
+func formatLinuxFlags(bits uint32) string {
+	var names []string
+	for _, f := range linuxFileFlags {
+		if bits&f.bit != 0 {
+			names = append(names, f.names[0])
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ",")
+}
+
+// parseLinuxFlags returns the inode flags for a "flags" value.
+func parseLinuxFlags(v string) (uint32, error) {
+	var bits uint32
+names:
+	for _, name := range strings.Split(v, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || name == "none" {
+			continue
+		}
+		for _, f := range linuxFileFlags {
+			for _, n := range f.names {
+				if n == name {
+					bits |= f.bit
+					continue names
+				}
+			}
+		}
+		return 0, fmt.Errorf("unknown file flag %q", name)
+	}
+	return bits, nil
+}
+
+// isFlagsUnsupported returns whether err means that the filesystem doesn't
+// support inode flags.
+func isFlagsUnsupported(err error) bool {
+	return errors.Is(err, unix.ENOTTY) || errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.EINVAL)
+}
+
+// openForFlags opens path for FS_IOC_GETFLAGS and FS_IOC_SETFLAGS. Only
+// regular files and directories can have their flags read or set, as opening
+// anything else may have side effects.
+func openForFlags(path string, info os.FileInfo) (*os.File, error) {
+	if !info.Mode().IsRegular() && !info.IsDir() {
+		return nil, nil
+	}
+	return os.OpenFile(path, os.O_RDONLY|unix.O_NONBLOCK|unix.O_NOFOLLOW|unix.O_NOCTTY, 0)
+}
+
+func getLinuxFlags(fh *os.File) (uint32, error) {
+	bits, err := unix.IoctlGetUint32(int(fh.Fd()), unix.FS_IOC_GETFLAGS)
+	if err != nil {
+		return 0, &os.PathError{Op: "ioctl FS_IOC_GETFLAGS", Path: fh.Name(), Err: err}
+	}
+	return bits, nil
+}
+
+func flagsKeywordFunc(path string, info os.FileInfo, r io.Reader) ([]KeyVal, error) {
+	if _, ok := info.Sys().(*tar.Header); ok {
+		return nil, nil
+	}
+	// Files which can't be opened (such as those only readable by root) are
+	// treated like those whose filesystem doesn't support flags.
+	fh, err := openForFlags(path, info)
+	if err != nil || fh == nil {
+		if errors.Is(err, unix.EACCES) || errors.Is(err, unix.EPERM) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer fh.Close()
+	bits, err := getLinuxFlags(fh)
+	if err != nil {
+		if isFlagsUnsupported(err) || errors.Is(err, unix.EACCES) || errors.Is(err, unix.EPERM) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return []KeyVal{KeyVal("flags=" + formatLinuxFlags(bits))}, nil
+}
+
+// clearLockingFlags clears the immutable and append-only flags of path, which
+// would stop its other attributes from being updated. They are restored by
+// updating the "flags" keyword afterwards.
+func clearLockingFlags(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	fh, err := openForFlags(path, info)
+	if err != nil || fh == nil {
+		return err
+	}
+	defer fh.Close()
+
+	const locking = 0x10 | 0x20 // FS_IMMUTABLE_FL, FS_APPEND_FL
+	cur, err := getLinuxFlags(fh)
+	if err != nil {
+		if isFlagsUnsupported(err) {
+			return nil
+		}
+		return err
+	}
+	if cur&locking == 0 {
+		return nil
+	}
+	if err := unix.IoctlSetPointerInt(int(fh.Fd()), unix.FS_IOC_SETFLAGS, int(cur&^locking)); err != nil {
+		return &os.PathError{Op: "ioctl FS_IOC_SETFLAGS", Path: path, Err: err}
+	}
+	return nil
+}
+
+func flagsUpdateKeywordFunc(path string, kv KeyVal) (os.FileInfo, error) {
+	want, err := parseLinuxFlags(kv.Value())
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil, err
+	}
+	fh, err := openForFlags(path, info)
+	if err != nil {
+		return nil, err
+	}
+	if fh == nil {
+		return info, nil
+	}
+	defer fh.Close()
+
+	cur, err := getLinuxFlags(fh)
+	if err != nil {
+		if want == 0 && isFlagsUnsupported(err) {
+			return info, nil
+		}
+		return nil, err
+	}
+	// Keep the flags which are managed by the filesystem.
+	bits := cur&^linuxFileFlagsMask | want
+	if bits == cur {
+		return info, nil
+	}
+	if err := unix.IoctlSetPointerInt(int(fh.Fd()), unix.FS_IOC_SETFLAGS, int(bits)); err != nil {
+		return nil, &os.PathError{Op: "ioctl FS_IOC_SETFLAGS", Path: path, Err: err}
+	}
+	return os.Lstat(path)
+}
@@ -0,0 +1,145 @@
+package mtree
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLinuxFlags(t *testing.T) {
+	bits, err := parseLinuxFlags("immutable,nodump,sappnd")
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0x70), bits)
+	assert.Equal(t, "uchg,uappnd,nodump", formatLinuxFlags(bits))
+	assert.Equal(t, "none", formatLinuxFlags(0))
+	// Filesystem-managed flags (FS_EXTENT_FL) are not written.
+	assert.Equal(t, "nodump", formatLinuxFlags(0x80040))
+
+	_, err = parseLinuxFlags("nodump,bogus")
+	assert.Error(t, err)
+
+	// The system and user flags are the same inode flag on Linux.
+	for _, test := range []struct {
+		a, b  string
+		equal bool
+	}{
+		{"schg", "uchg", true},
+		{"simmutable,sappnd", "uappnd,uchg", true},
+		{"schg", "uappnd", false},
+	} {
+		assert.Equal(t, test.equal, compareFlags(KeyVal("flags="+test.a), KeyVal("flags="+test.b), &CompareOptions{}), "%q vs %q", test.a, test.b)
+	}
+}
+
+func TestLinuxFlagsUpdate(t *testing.T) {
+	// /tmp is often tmpfs, which doesn't support most flags.
+	dir, err := os.MkdirTemp(".", "test.flags.")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "file")
+	require.NoError(t, os.WriteFile(file, []byte("data"), 0644))
+	info, err := os.Lstat(file)
+	require.NoError(t, err)
+
+	kvs, err := flagsKeywordFunc(file, info, nil)
+	require.NoError(t, err)
+	if len(kvs) == 0 {
+		t.Skipf("skipping: %q does not support inode flags", dir)
+	}
+	assert.Equal(t, []KeyVal{"flags=none"}, kvs)
+
+	if _, err := flagsUpdateKeywordFunc(file, "flags=nodump"); err != nil {
+		t.Skipf("skipping: cannot set nodump on %q: %v", dir, err)
+	}
+	kvs, err = flagsKeywordFunc(file, info, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []KeyVal{"flags=nodump"}, kvs)
+
+	dh, err := Walk(dir, nil, []Keyword{"type", "flags"}, nil)
+	require.NoError(t, err)
+	res, err := Check(dir, dh, nil, nil)
+	require.NoError(t, err)
+	assert.Empty(t, res)
+
+	// Clearing the flag is a difference, which Update restores.
+	_, err = flagsUpdateKeywordFunc(file, "flags=none")
+	require.NoError(t, err)
+	res, err = Check(dir, dh, nil, nil)
+	require.NoError(t, err)
+	if assert.Len(t, res, 1) {
+		assert.Equal(t, "file", res[0].Path())
+	}
+	res, err = Update(dir, dh, []Keyword{"flags"}, nil)
+	require.NoError(t, err)
+	assert.Empty(t, res)
+	res, err = Check(dir, dh, nil, nil)
+	require.NoError(t, err)
+	assert.Empty(t, res)
+}
+
+func TestLinuxFlagsUpdateImmutable(t *testing.T) {
+	dir, err := os.MkdirTemp(".", "test.flags.")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "file")
+	require.NoError(t, os.WriteFile(file, []byte("data"), 0644))
+	if _, err := flagsUpdateKeywordFunc(file, "flags=uchg"); err != nil {
+		t.Skipf("skipping: cannot set immutable flag on %q: %v", dir, err)
+	}
+	defer flagsUpdateKeywordFunc(file, "flags=none") //nolint:errcheck
+
+	// The mode is updated before the file is made immutable.
+	_, err = flagsUpdateKeywordFunc(file, "flags=none")
+	require.NoError(t, err)
+	dh, err := ParseSpec(strings.NewReader("/set type=file\n. type=dir\n    file mode=0600 flags=uchg\n..\n"))
+	require.NoError(t, err)
+	res, err := Update(dir, dh, []Keyword{"flags", "mode"}, nil)
+	require.NoError(t, err)
+	assert.Empty(t, res)
+
+	info, err := os.Lstat(file)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	kvs, err := flagsKeywordFunc(file, info, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []KeyVal{"flags=uchg"}, kvs)
+	assert.Error(t, os.WriteFile(file, []byte("changed"), 0644), "immutable file should not be writable")
+
+	// An immutable file is unlocked to update its mode, and a BSD "schg" is
+	// applied (and then checks) as "uchg".
+	dh, err = ParseSpec(strings.NewReader("/set type=file\n. type=dir flags=none\n    file mode=0644 flags=schg\n..\n"))
+	require.NoError(t, err)
+	res, err = Update(dir, dh, []Keyword{"flags", "mode"}, nil)
+	require.NoError(t, err)
+	assert.Empty(t, res)
+	info, err = os.Lstat(file)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0644), info.Mode().Perm())
+	res, err = Check(dir, dh, []Keyword{"flags"}, nil)
+	require.NoError(t, err)
+	assert.Empty(t, res)
+}
+
+func TestLinuxFlagsUnreadable(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can read any file")
+	}
+	dir := t.TempDir()
+	fn := filepath.Join(dir, "secret")
+	require.NoError(t, os.WriteFile(fn, []byte("data"), 0000))
+
+	// A file which can't be opened has no flags, rather than stopping the walk.
+	dh, err := Walk(dir, nil, []Keyword{"type", "flags"}, nil)
+	require.NoError(t, err)
+	for _, e := range dh.Entries {
+		if e.Name == "secret" {
+			assert.Empty(t, HasKeyword(e.Keywords, "flags"))
+		}
+	}
+}
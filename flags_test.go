@@ -0,0 +1,24 @@
+package mtree
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompareFlags(t *testing.T) {
+	for _, test := range []struct {
+		a, b  string
+		equal bool
+	}{
+		{"none", "none", true},
+		{"none", "", true},
+		{"uchg,nodump", "nodump,uchg", true},
+		{"immutable", "uchg", true},
+		{"uappnd,append", "uappnd", true},
+		{"uchg", "none", false},
+		{"uchg,nodump", "uchg", false},
+	} {
+		assert.Equal(t, test.equal, compareFlags(KeyVal("flags="+test.a), KeyVal("flags="+test.b), &CompareOptions{}), "%q vs %q", test.a, test.b)
+	}
+}
@@ -0,0 +1,28 @@
+//go:build !linux
+// +build !linux
+
+package mtree
+
+// flagSynonyms maps the alternative names of file flags, as accepted by
+// chflags(1), to the name written in manifests.
+var flagSynonyms = map[string]string{
+	"uimmutable": "uchg",
+	"immutable":  "uchg",
+	"uappend":    "uappnd",
+	"append":     "uappnd",
+	"simmutable": "schg",
+	"sappend":    "sappnd",
+}
+
+// canonicalFlag returns the name written in manifests for the flag name.
+func canonicalFlag(name string) string {
+	if canon, ok := flagSynonyms[name]; ok {
+		return canon
+	}
+	return name
+}
+
+// clearLockingFlags is a no-op, as flags are only updated on Linux.
+func clearLockingFlags(path string) error {
+	return nil
+}
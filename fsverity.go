@@ -0,0 +1,214 @@
+package mtree
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"math/bits"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// FsverityParams are the parameters of the Merkle tree from which the
+// "fsverity" digest of a file is computed. They must be the same as those
+// given when fs-verity was enabled on the file (such as with "fsverity enable
+// --block-size=4096 --salt=...") for the digests to match.
+type FsverityParams struct {
+	// BlockSize is the size of the data and Merkle tree blocks, which must be
+	// a power of two from 1024 to 65536.
+	BlockSize int
+
+	// Salt is prepended to each block before it is hashed, and may be up to
+	// 32 bytes long.
+	Salt []byte
+}
+
+// DefaultFsverityParams are the default parameters of fsverity-utils: 4096
+// byte blocks and no salt.
+var DefaultFsverityParams = FsverityParams{BlockSize: 4096}
+
+// fsverityMismatch is appended to the "fsverity" value of a file whose digest
+// differs from the one which the kernel enforces for it.
+const fsverityMismatch = ";mismatch"
+
+const (
+	// fsverityHashAlgSHA256 is FS_VERITY_HASH_ALG_SHA256.
+	fsverityHashAlgSHA256 = 1
+	// fsverityMaxSalt is the size of the salt field of the descriptor.
+	fsverityMaxSalt = 32
+)
+
+func (p FsverityParams) validate() error {
+	if p.BlockSize < 1024 || p.BlockSize > 65536 || p.BlockSize&(p.BlockSize-1) != 0 {
+		return fmt.Errorf("fsverity: invalid block size %d", p.BlockSize)
+	}
+	if len(p.Salt) > fsverityMaxSalt {
+		return fmt.Errorf("fsverity: salt is longer than %d bytes", fsverityMaxSalt)
+	}
+	return nil
+}
+
+// merkleTree computes the root hash of the fs-verity Merkle tree of some data,
+// one level at a time as the blocks of the level below are completed, so that
+// only one block of each level is held in memory.
+type merkleTree struct {
+	params FsverityParams
+	h      hash.Hash
+	salt   []byte
+	// levels are the incomplete blocks of hashes of each level of the tree,
+	// and the number of blocks completed on each.
+	levels []merkleLevel
+}
+
+type merkleLevel struct {
+	buf    []byte
+	blocks int64
+}
+
+func newMerkleTree(params FsverityParams) *merkleTree {
+	h := sha256.New()
+	var salt []byte
+	if len(params.Salt) > 0 {
+		// The salt is zero-padded to a multiple of the hash's block size.
+		salt = make([]byte, (len(params.Salt)+h.BlockSize()-1)/h.BlockSize()*h.BlockSize())
+		copy(salt, params.Salt)
+	}
+	return &merkleTree{params: params, h: h, salt: salt}
+}
+
+// hashBlock returns the salted hash of block, which is zero-padded to the
+// block size.
+func (t *merkleTree) hashBlock(block []byte) []byte {
+	t.h.Reset()
+	t.h.Write(t.salt)
+	t.h.Write(block)
+	if pad := t.params.BlockSize - len(block); pad > 0 {
+		t.h.Write(make([]byte, pad))
+	}
+	return t.h.Sum(nil)
+}
+
+// add adds sum, the hash of a completed block of the level below, to level.
+// Level 0 holds the hashes of the data blocks.
+func (t *merkleTree) add(level int, sum []byte) {
+	if level == len(t.levels) {
+		t.levels = append(t.levels, merkleLevel{buf: make([]byte, 0, t.params.BlockSize)})
+	}
+	l := &t.levels[level]
+	l.buf = append(l.buf, sum...)
+	if len(l.buf)+len(sum) > t.params.BlockSize {
+		// l is done with before adding to the next level, which may move it.
+		l.blocks++
+		sum := t.hashBlock(l.buf)
+		l.buf = l.buf[:0]
+		t.add(level+1, sum)
+	}
+}
+
+// root returns the root hash of the tree, which is the only hash of the top
+// level. For data of one block, that is the hash of the data block itself,
+// and the root hash of empty data is all zeros.
+func (t *merkleTree) root() []byte {
+	for level := 0; level < len(t.levels); level++ {
+		l := &t.levels[level]
+		if l.blocks == 0 && len(l.buf) == t.h.Size() {
+			return l.buf
+		}
+		if len(l.buf) > 0 {
+			l.blocks++
+			sum := t.hashBlock(l.buf)
+			l.buf = l.buf[:0]
+			t.add(level+1, sum)
+		}
+	}
+	return make([]byte, t.h.Size())
+}
+
+// fsverityDigest returns the fs-verity file digest of the data read from r:
+// the SHA-256 digest of the fs_verity_descriptor holding the root hash of its
+// Merkle tree (see the kernel's Documentation/filesystems/fsverity.rst).
+func fsverityDigest(r io.Reader, params FsverityParams) ([]byte, error) {
+	if err := params.validate(); err != nil {
+		return nil, err
+	}
+	tree := newMerkleTree(params)
+	block := make([]byte, params.BlockSize)
+	var size uint64
+	for {
+		n, err := io.ReadFull(r, block)
+		if n > 0 {
+			size += uint64(n)
+			tree.add(0, tree.hashBlock(block[:n]))
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// struct fsverity_descriptor
+	desc := make([]byte, 256)
+	desc[0] = 1 // version
+	desc[1] = fsverityHashAlgSHA256
+	desc[2] = byte(bits.TrailingZeros(uint(params.BlockSize)))
+	desc[3] = byte(len(params.Salt))
+	binary.LittleEndian.PutUint64(desc[8:], size)
+	copy(desc[16:80], tree.root())
+	copy(desc[80:112], params.Salt)
+	sum := sha256.Sum256(desc)
+	return sum[:], nil
+}
+
+// FsverityKeywordFunc returns the KeywordFunc of the "fsverity" keyword with
+// the given parameters. It can be used to register the keyword with other
+// parameters in a KeywordRegistry:
+//
+//	registry := mtree.DefaultKeywordRegistry.Clone()
+//	spec, _ := registry.Lookup("fsverity")
+//	spec.Collect = mtree.FsverityKeywordFunc(params)
+//	registry.Register(spec)
+func FsverityKeywordFunc(params FsverityParams) KeywordFunc {
+	return func(path string, info os.FileInfo, r io.Reader) ([]KeyVal, error) {
+		if r == nil || !info.Mode().IsRegular() {
+			return nil, nil
+		}
+		sum, err := fsverityDigest(r, params)
+		if err != nil {
+			return nil, fmt.Errorf("fsverity for %q: %w", path, err)
+		}
+		digest := "sha256:" + hex.EncodeToString(sum)
+		if _, isTar := info.Sys().(*tar.Header); !isTar {
+			// If fs-verity is enabled on the file, then the kernel enforces
+			// its own digest, which should be the one in the manifest. Only
+			// SHA-256 digests can be compared with the computed one.
+			enforced, ok, err := measureFsverity(path)
+			if err != nil {
+				return nil, fmt.Errorf("fsverity for %q: %w", path, err)
+			}
+			if ok && strings.HasPrefix(enforced, "sha256:") && enforced != digest {
+				logrus.Debugf("fsverity for %q: enforced digest %s does not match computed digest %s", path, enforced, digest)
+				digest += fsverityMismatch
+			}
+		}
+		return []KeyVal{KeyVal("fsverity=" + digest)}, nil
+	}
+}
+
+// compareFsverity flags files whose digest doesn't match the one enforced by
+// the kernel, as well as changes to the digest.
+func compareFsverity(old, new KeyVal, opts *CompareOptions) bool {
+	if strings.HasSuffix(new.Value(), fsverityMismatch) {
+		return false
+	}
+	return compareDigest(old, new, opts)
+}
+
+var fsverityKeywordFunc = FsverityKeywordFunc(DefaultFsverityParams)
@@ -0,0 +1,51 @@
+//go:build linux
+// +build linux
+
+package mtree
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// measureFsverity returns the fs-verity digest which the kernel enforces for
+// the file at path, as found with FS_IOC_MEASURE_VERITY. It returns false if
+// fs-verity isn't enabled on the file, or isn't supported by its filesystem.
+func measureFsverity(path string) (string, bool, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return "", false, err
+	}
+	defer fh.Close()
+
+	// struct fsverity_digest, with room for the largest (SHA-512) digest.
+	buf := make([]byte, 4+64)
+	binary.NativeEndian.PutUint16(buf[2:], 64)
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, fh.Fd(), unix.FS_IOC_MEASURE_VERITY, uintptr(unsafe.Pointer(&buf[0])))
+	if errno != 0 {
+		switch {
+		case errors.Is(errno, unix.ENODATA), errors.Is(errno, unix.ENOTTY), errors.Is(errno, unix.EOPNOTSUPP):
+			return "", false, nil
+		}
+		return "", false, &os.PathError{Op: "ioctl FS_IOC_MEASURE_VERITY", Path: path, Err: errno}
+	}
+	alg := binary.NativeEndian.Uint16(buf[0:])
+	size := binary.NativeEndian.Uint16(buf[2:])
+	if int(size) > len(buf)-4 {
+		return "", false, fmt.Errorf("ioctl FS_IOC_MEASURE_VERITY: invalid digest size %d", size)
+	}
+	name := fmt.Sprintf("alg%d", alg)
+	switch alg {
+	case unix.FS_VERITY_HASH_ALG_SHA256:
+		name = "sha256"
+	case unix.FS_VERITY_HASH_ALG_SHA512:
+		name = "sha512"
+	}
+	return name + ":" + hex.EncodeToString(buf[4:4+size]), true, nil
+}
@@ -0,0 +1,80 @@
+package mtree
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+// enableFsverity enables fs-verity on the file at path with the default
+// parameters, skipping the test if the filesystem doesn't support it.
+func enableFsverity(t *testing.T, path string) {
+	t.Helper()
+	fh, err := os.Open(path)
+	require.NoError(t, err)
+	defer fh.Close()
+
+	arg := unix.FsverityEnableArg{
+		Version:        1,
+		Hash_algorithm: unix.FS_VERITY_HASH_ALG_SHA256,
+		Block_size:     uint32(DefaultFsverityParams.BlockSize),
+	}
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, fh.Fd(), unix.FS_IOC_ENABLE_VERITY, uintptr(unsafe.Pointer(&arg)))
+	switch {
+	case errno == 0:
+	case errors.Is(errno, unix.ENOTTY), errors.Is(errno, unix.EOPNOTSUPP), errors.Is(errno, unix.EINVAL), errors.Is(errno, unix.EPERM):
+		t.Skipf("fs-verity is not supported here: %v", errno)
+	default:
+		require.NoError(t, errno, "enable fs-verity on %s", path)
+	}
+}
+
+func TestFsverityEnforced(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"small":      "hello",
+		"one block":  strings.Repeat("a", 4096),
+		"two blocks": strings.Repeat("a", 4097),
+	} {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(data), 0644))
+		enableFsverity(t, path)
+	}
+
+	// The computed digests must agree with those which the kernel enforces.
+	keywords := []Keyword{"type", "fsverity"}
+	dh, err := Walk(dir, nil, keywords, nil)
+	require.NoError(t, err)
+	for _, e := range dh.Entries {
+		if e.Type == RelativeType && !e.IsDir() {
+			kvs := HasKeyword(e.Keywords, "fsverity")
+			if assert.Len(t, kvs, 1, e.Name) {
+				assert.NotContains(t, kvs[0].Value(), fsverityMismatch, e.Name)
+			}
+		}
+	}
+
+	// With other parameters, the digests are flagged rather than stopping
+	// the walk.
+	registry := DefaultKeywordRegistry.Clone()
+	spec, _ := registry.Lookup("fsverity")
+	spec.Collect = FsverityKeywordFunc(FsverityParams{BlockSize: 1024})
+	require.NoError(t, registry.Register(spec))
+	dh, err = WalkWithOptions(dir, &WalkOptions{Keywords: keywords, Registry: registry})
+	require.NoError(t, err)
+	for _, e := range dh.Entries {
+		if e.Type == RelativeType && !e.IsDir() {
+			kvs := HasKeyword(e.Keywords, "fsverity")
+			if assert.Len(t, kvs, 1, e.Name) {
+				assert.True(t, strings.HasSuffix(kvs[0].Value(), fsverityMismatch), e.Name)
+			}
+		}
+	}
+}
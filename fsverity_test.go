@@ -0,0 +1,82 @@
+package mtree
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFsverityDigest(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		data   string
+		params FsverityParams
+		digest string
+	}{
+		// As given by "fsverity digest" from fsverity-utils.
+		{"empty", "", DefaultFsverityParams, "3d248ca542a24fc62d1c43b916eae5016878e2533c88238480b26128a1f1af95"},
+		{"small", "hello", DefaultFsverityParams, "555b589c26ee43b7a2510e6c67ced9fb3190b6da6e9e683984551f5d77a763de"},
+		{"one block", strings.Repeat("a", 4096), DefaultFsverityParams, "a2a808ddaced77f0b6b3068f47b14b5a1fb3fc43674993ab11b8e7e6f2d089e2"},
+		{"two blocks", strings.Repeat("a", 4097), DefaultFsverityParams, "18b155c0b6e054f3f7d22488ed15340e74dc161ce2d123e13eb685c3ce565f70"},
+		{"two levels", strings.Repeat("a", 4096*129), DefaultFsverityParams, "86ca2993bfc7a6fdb0dbea51f8a3c9217566a136b9aa7356d0313a438a6cf31a"},
+		{"full level", strings.Repeat("a", 4096*128*128+5), DefaultFsverityParams, "6f42f661e3102c264726830cd8f8d4e5a0f61cb3cc84e6ccd6e388d3351d9dc8"},
+		{"salted", "hello", FsverityParams{BlockSize: 1024, Salt: []byte{1, 2}}, "3444446fe6b68607465123c9e75fd321b83e3ed7dcfb30e89821dd93d631d45d"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			sum, err := fsverityDigest(strings.NewReader(test.data), test.params)
+			require.NoError(t, err)
+			assert.Equal(t, test.digest, hex.EncodeToString(sum))
+		})
+	}
+
+	for _, params := range []FsverityParams{
+		{BlockSize: 512},
+		{BlockSize: 5000},
+		{BlockSize: 4096, Salt: make([]byte, 33)},
+	} {
+		_, err := fsverityDigest(strings.NewReader("hello"), params)
+		assert.Error(t, err, "params %+v", params)
+	}
+}
+
+func TestFsverityKeyword(t *testing.T) {
+	const want = "fsverity=sha256:555b589c26ee43b7a2510e6c67ced9fb3190b6da6e9e683984551f5d77a763de"
+	keywords := []Keyword{"type", "fsverity"}
+
+	files := map[string][]byte{"file": []byte("hello")}
+	for name, dh := range walkAndTarHierarchies(t, nil, keywords, files) {
+		t.Run(name, func(t *testing.T) {
+			for _, e := range dh.Entries {
+				if e.Name == "file" {
+					assert.Equal(t, []KeyVal{want}, HasKeyword(e.Keywords, "fsverity"))
+					return
+				}
+			}
+			t.Error("file entry should be present")
+		})
+	}
+
+	// Other parameters can be registered.
+	registry := DefaultKeywordRegistry.Clone()
+	spec, _ := registry.Lookup("fsverity")
+	spec.Collect = FsverityKeywordFunc(FsverityParams{BlockSize: 1024, Salt: []byte{1, 2}})
+	require.NoError(t, registry.Register(spec))
+	for _, dh := range walkAndTarHierarchies(t, registry, keywords, files) {
+		for _, e := range dh.Entries {
+			if e.Name == "file" {
+				assert.Equal(t, []KeyVal{"fsverity=sha256:3444446fe6b68607465123c9e75fd321b83e3ed7dcfb30e89821dd93d631d45d"}, HasKeyword(e.Keywords, "fsverity"))
+			}
+		}
+	}
+}
+
+func TestCompareFsverity(t *testing.T) {
+	assert.True(t, compareFsverity("fsverity=sha256:aabb", "fsverity=sha256:aabb", nil))
+	assert.False(t, compareFsverity("fsverity=sha256:aabb", "fsverity=sha256:aacc", nil))
+	// Mismatches with the enforced digest are always flagged.
+	assert.False(t, compareFsverity("fsverity=sha256:aabb", "fsverity=sha256:aabb;mismatch", nil))
+	assert.False(t, compareFsverity("fsverity=sha256:aabb;mismatch", "fsverity=sha256:aabb;mismatch", nil))
+}
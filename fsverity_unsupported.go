@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package mtree
+
+// measureFsverity is only supported on Linux.
+func measureFsverity(path string) (string, bool, error) {
+	return "", false, nil
+}
@@ -13,9 +13,28 @@ type DirectoryHierarchy struct {
 // WriteTo simplifies the output of the resulting hierarchy spec.
 // Satisfies the `io.WriterTo` interface.
 func (dh DirectoryHierarchy) WriteTo(w io.Writer) (n int64, err error) {
+	return dh.WriteToWithOptions(w, nil)
+}
+
+// WriteOptions are the parameters for WriteToWithOptions.
+type WriteOptions struct {
+	// SymbolicModes writes "mode" values in the chmod(1) symbolic form (such
+	// as "u=rwx,g=rx,o=rx") instead of in octal.
+	SymbolicModes bool
+}
+
+// WriteToWithOptions is like WriteTo, but takes its parameters from opts. A nil
+// opts is treated the same as a zero WriteOptions.
+func (dh DirectoryHierarchy) WriteToWithOptions(w io.Writer, opts *WriteOptions) (n int64, err error) {
+	if opts == nil {
+		opts = &WriteOptions{}
+	}
 	sort.Sort(byPos(dh.Entries))
 	var sum int64
 	for _, e := range dh.Entries {
+		if opts.SymbolicModes {
+			e.Keywords = symbolicModeKeyVals(e.Keywords)
+		}
 		str := e.String()
 		i, err := io.WriteString(w, str+"\n")
 		if err != nil {
@@ -27,7 +46,9 @@ func (dh DirectoryHierarchy) WriteTo(w io.Writer) (n int64, err error) {
 }
 
 // UsedKeywords collects and returns all the keywords used in a
-// a DirectoryHierarchy
+// a DirectoryHierarchy. Keywords with a suffix (such as "xattr.user.foo") are
+// returned as their prefix, unless only the whole keyword is registered (as
+// with "exec.<name>").
 func (dh DirectoryHierarchy) UsedKeywords() []Keyword {
 	usedkeywords := []Keyword{}
 	for _, e := range dh.Entries {
@@ -36,7 +57,10 @@ func (dh DirectoryHierarchy) UsedKeywords() []Keyword {
 			if e.Type != SpecialType || e.Name == "/set" {
 				kvs := e.Keywords
 				for _, kv := range kvs {
-					kw := KeyVal(kv).Keyword().Prefix()
+					kw := KeyVal(kv).Keyword()
+					if _, ok := DefaultKeywordRegistry.Lookup(kw.Prefix()); ok {
+						kw = kw.Prefix()
+					}
 					if !InKeywordSlice(kw, usedkeywords) {
 						usedkeywords = append(usedkeywords, KeywordSynonym(string(kw)))
 					}
@@ -46,3 +70,18 @@ func (dh DirectoryHierarchy) UsedKeywords() []Keyword {
 	}
 	return usedkeywords
 }
+
+// symbolicModeKeyVals returns a copy of keyvals with any octal "mode" value
+// converted to the symbolic form.
+func symbolicModeKeyVals(keyvals []KeyVal) []KeyVal {
+	ret := keyValCopy(keyvals)
+	for i, kv := range ret {
+		if kv.Keyword() != "mode" {
+			continue
+		}
+		if mode, err := ParseMode(kv.Value()); err == nil {
+			ret[i] = kv.NewValue(formatSymbolicMode(mode))
+		}
+	}
+	return ret
+}
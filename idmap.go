@@ -0,0 +1,130 @@
+package mtree
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// IDMapping is a contiguous range of IDs mapped from a user namespace to its
+// parent, as in one line of /proc/<pid>/uid_map.
+type IDMapping struct {
+	// ContainerID is the first ID of the range inside the namespace.
+	ContainerID int64
+	// HostID is the first ID of the range outside the namespace.
+	HostID int64
+	// Size is the number of IDs in the range.
+	Size int64
+}
+
+// IDMap is a set of IDMapping, such as the contents of /proc/self/uid_map or
+// /proc/self/gid_map. It is used to translate the "uid" and "gid" values of
+// a manifest created inside a user namespace (such as a rootless container) to
+// the IDs used on the host.
+type IDMap []IDMapping
+
+// ParseIDMap parses an IDMap in the format of user_namespaces(7), with one
+// "<container-id> <host-id> <size>" mapping per line. For convenience on the
+// command line, mappings can also be separated by commas, and the fields by
+// colons, as in "0:100000:65536".
+func ParseIDMap(s string) (IDMap, error) {
+	return ReadIDMap(strings.NewReader(strings.ReplaceAll(s, ",", "\n")))
+}
+
+// ReadIDMap reads an IDMap, as with ParseIDMap, from r.
+func ReadIDMap(r io.Reader) (IDMap, error) {
+	var m IDMap
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.FieldsFunc(line, func(r rune) bool {
+			return r == ':' || r == ' ' || r == '\t'
+		})
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid id mapping %q: expected 3 fields", line)
+		}
+		var vals [3]int64
+		for i, f := range fields {
+			v, err := strconv.ParseInt(f, 10, 64)
+			if err != nil || v < 0 || v > 1<<32-1 {
+				return nil, fmt.Errorf("invalid id mapping %q: bad id %q", line, f)
+			}
+			vals[i] = v
+		}
+		mapping := IDMapping{ContainerID: vals[0], HostID: vals[1], Size: vals[2]}
+		if mapping.Size == 0 {
+			return nil, fmt.Errorf("invalid id mapping %q: empty range", line)
+		}
+		for _, other := range m {
+			if overlaps(mapping.ContainerID, other.ContainerID, mapping.Size, other.Size) {
+				return nil, fmt.Errorf("invalid id mapping %q: overlaps with another mapping", line)
+			}
+		}
+		m = append(m, mapping)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func overlaps(a, b, aSize, bSize int64) bool {
+	return a < b+bSize && b < a+aSize
+}
+
+// ToHost returns the host ID for the container ID id. It returns false if id
+// is not mapped.
+func (m IDMap) ToHost(id int64) (int64, bool) {
+	for _, mapping := range m {
+		if id >= mapping.ContainerID && id-mapping.ContainerID < mapping.Size {
+			return mapping.HostID + (id - mapping.ContainerID), true
+		}
+	}
+	return -1, false
+}
+
+// ToContainer returns the container ID for the host ID id. It returns false if
+// id is not mapped.
+func (m IDMap) ToContainer(id int64) (int64, bool) {
+	for _, mapping := range m {
+		if id >= mapping.HostID && id-mapping.HostID < mapping.Size {
+			return mapping.ContainerID + (id - mapping.HostID), true
+		}
+	}
+	return -1, false
+}
+
+// mapKeyVal translates the value of the "uid" or "gid" KeyVal kv, from a
+// container ID to a host ID, using m. It is an error for the ID not to be
+// mapped. An empty m leaves kv alone.
+func (m IDMap) mapKeyVal(kv KeyVal) (KeyVal, error) {
+	if len(m) == 0 {
+		return kv, nil
+	}
+	id, err := strconv.ParseInt(kv.Value(), 10, 64)
+	if err != nil {
+		return kv, fmt.Errorf("invalid %s %q: %w", kv.Keyword(), kv.Value(), err)
+	}
+	host, ok := m.ToHost(id)
+	if !ok {
+		return kv, fmt.Errorf("%s %d is not mapped", kv.Keyword(), id)
+	}
+	return kv.NewValue(strconv.FormatInt(host, 10)), nil
+}
+
+// mapIDKeyVal translates kv with uidMap if it is a "uid", or gidMap if it is a
+// "gid". Other keywords are returned unchanged.
+func mapIDKeyVal(kv KeyVal, uidMap, gidMap IDMap) (KeyVal, error) {
+	switch kv.Keyword() {
+	case "uid":
+		return uidMap.mapKeyVal(kv)
+	case "gid":
+		return gidMap.mapKeyVal(kv)
+	}
+	return kv, nil
+}
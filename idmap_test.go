@@ -0,0 +1,146 @@
+package mtree
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseIDMap(t *testing.T) {
+	m, err := ParseIDMap("0:100000:1000,1000:1000:1")
+	require.NoError(t, err)
+	assert.Equal(t, IDMap{{0, 100000, 1000}, {1000, 1000, 1}}, m)
+
+	m2, err := ReadIDMap(strings.NewReader("         0     100000       1000\n      1000       1000          1\n"))
+	require.NoError(t, err)
+	assert.Equal(t, m, m2)
+
+	for _, test := range []struct {
+		id, host int64
+		ok       bool
+	}{
+		{0, 100000, true},
+		{999, 100999, true},
+		{1000, 1000, true},
+		{1001, -1, false},
+	} {
+		host, ok := m.ToHost(test.id)
+		assert.Equal(t, test.ok, ok, "ToHost(%d)", test.id)
+		assert.Equal(t, test.host, host, "ToHost(%d)", test.id)
+		if ok {
+			id, ok := m.ToContainer(host)
+			assert.True(t, ok)
+			assert.Equal(t, test.id, id, "ToContainer(%d)", host)
+		}
+	}
+
+	for _, bad := range []string{
+		"0:100000",
+		"0:100000:0",
+		"a:100000:1",
+		"0:-1:1",
+		"0:100000:10,5:0:1",
+	} {
+		_, err := ParseIDMap(bad)
+		assert.Error(t, err, "ParseIDMap(%q)", bad)
+	}
+}
+
+func TestIDMapCompareCheckUpdate(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	require.NoError(t, os.WriteFile(file, []byte("data"), 0644))
+	info, err := os.Lstat(file)
+	require.NoError(t, err)
+	uid, gid, ok := statIDs(info)
+	require.True(t, ok)
+
+	if uid == 1000 || gid == 1000 {
+		t.Skip("test requires the files not to be owned by 1000")
+	}
+
+	// Pretend the manifest was created in a namespace where the current
+	// owner of the file is uid/gid 1000.
+	dh, err := ParseSpec(strings.NewReader("/set uid=1000 gid=1000\n. type=dir\n    file type=file\n..\n"))
+	require.NoError(t, err)
+	uidMap := IDMap{{1000, int64(uid), 1}}
+	gidMap := IDMap{{1000, int64(gid), 1}}
+
+	res, err := Check(dir, dh, []Keyword{"uid", "gid"}, nil)
+	require.NoError(t, err)
+	assert.NotEmpty(t, res, "ids should differ without the maps")
+
+	res, err = CheckWithOptions(dir, dh, &CheckOptions{
+		Keywords: []Keyword{"uid", "gid"},
+		UIDMap:   uidMap,
+		GIDMap:   gidMap,
+	})
+	require.NoError(t, err)
+	assert.Empty(t, res)
+
+	// A uid outside of the map is reported as modified.
+	res, err = CheckWithOptions(dir, dh, &CheckOptions{
+		Keywords: []Keyword{"uid", "gid"},
+		UIDMap:   IDMap{{0, int64(uid), 1}},
+		GIDMap:   gidMap,
+	})
+	require.NoError(t, err)
+	if assert.Len(t, res, 2) {
+		assert.Equal(t, Modified, res[0].Type())
+	}
+
+	res, err = UpdateWithOptions(dir, dh, &UpdateOptions{
+		Keywords: []Keyword{"uid", "gid"},
+		UIDMap:   uidMap,
+		GIDMap:   gidMap,
+	})
+	require.NoError(t, err)
+	assert.Empty(t, res)
+
+	// Update refuses to apply an unmapped id.
+	res, err = UpdateWithOptions(dir, dh, &UpdateOptions{
+		Keywords: []Keyword{"uid"},
+		UIDMap:   IDMap{{0, int64(uid), 1}},
+	})
+	require.NoError(t, err)
+	if assert.Len(t, res, 2) {
+		assert.Equal(t, ErrorDifference, res[0].Type())
+	}
+	info, err = os.Lstat(file)
+	require.NoError(t, err)
+	newUID, _, _ := statIDs(info)
+	assert.Equal(t, uid, newUID)
+}
+
+func TestIDMapUpdateChown(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("chown to another uid requires root")
+	}
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "file"), []byte("data"), 0644))
+
+	dh, err := ParseSpec(strings.NewReader("/set uid=1000 gid=1000\n. type=dir\n    file type=file\n..\n"))
+	require.NoError(t, err)
+	idMap := IDMap{{0, 100000, 65536}}
+	res, err := UpdateWithOptions(dir, dh, &UpdateOptions{
+		Keywords: []Keyword{"uid", "gid"},
+		UIDMap:   idMap,
+		GIDMap:   idMap,
+	})
+	require.NoError(t, err)
+	assert.Empty(t, res)
+
+	info, err := os.Lstat(filepath.Join(dir, "file"))
+	require.NoError(t, err)
+	uid, gid, _ := statIDs(info)
+	assert.Equal(t, 101000, uid)
+	assert.Equal(t, 101000, gid)
+
+	res, err = CheckWithOptions(dir, dh, &CheckOptions{UIDMap: idMap, GIDMap: idMap})
+	require.NoError(t, err)
+	assert.Empty(t, res)
+}
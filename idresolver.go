@@ -0,0 +1,318 @@
+package mtree
+
+import (
+	"archive/tar"
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// IDResolver maps between user and group IDs and their names, for the "uname"
+// and "gname" keywords.
+type IDResolver interface {
+	// LookupUID returns the name of the user with the given uid.
+	LookupUID(uid int) (string, error)
+
+	// LookupGID returns the name of the group with the given gid.
+	LookupGID(gid int) (string, error)
+
+	// LookupUser returns the uid of the user with the given name.
+	LookupUser(name string) (int, error)
+
+	// LookupGroup returns the gid of the group with the given name.
+	LookupGroup(name string) (int, error)
+}
+
+// HostIDResolver is an IDResolver which uses the user and group database of
+// the host, through os/user. Results are cached.
+var HostIDResolver = NewCachingIDResolver(hostIDResolver{})
+
+type hostIDResolver struct{}
+
+func (hostIDResolver) LookupUID(uid int) (string, error) {
+	u, err := user.LookupId(strconv.Itoa(uid))
+	if err != nil {
+		return "", err
+	}
+	return u.Username, nil
+}
+
+func (hostIDResolver) LookupGID(gid int) (string, error) {
+	g, err := lookupGroupID(strconv.Itoa(gid))
+	if err != nil {
+		return "", err
+	}
+	return g.Name, nil
+}
+
+func (hostIDResolver) LookupUser(name string) (int, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(u.Uid)
+}
+
+func (hostIDResolver) LookupGroup(name string) (int, error) {
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(g.Gid)
+}
+
+// NewCachingIDResolver returns an IDResolver which caches the results
+// (including failures) of r.
+func NewCachingIDResolver(r IDResolver) IDResolver {
+	return &cachingIDResolver{
+		resolver: r,
+		names:    map[string]idResult{},
+		ids:      map[string]idResult{},
+	}
+}
+
+type idResult struct {
+	name string
+	id   int
+	err  error
+}
+
+type cachingIDResolver struct {
+	resolver IDResolver
+	mu       sync.Mutex
+	// names is keyed by "u<uid>" and "g<gid>", ids by "u<name>" and "g<name>"
+	names map[string]idResult
+	ids   map[string]idResult
+}
+
+func (c *cachingIDResolver) lookupName(key string, fn func() (string, error)) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	res, ok := c.names[key]
+	if !ok {
+		res.name, res.err = fn()
+		c.names[key] = res
+	}
+	return res.name, res.err
+}
+
+func (c *cachingIDResolver) lookupID(key string, fn func() (int, error)) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	res, ok := c.ids[key]
+	if !ok {
+		res.id, res.err = fn()
+		c.ids[key] = res
+	}
+	return res.id, res.err
+}
+
+func (c *cachingIDResolver) LookupUID(uid int) (string, error) {
+	return c.lookupName("u"+strconv.Itoa(uid), func() (string, error) { return c.resolver.LookupUID(uid) })
+}
+
+func (c *cachingIDResolver) LookupGID(gid int) (string, error) {
+	return c.lookupName("g"+strconv.Itoa(gid), func() (string, error) { return c.resolver.LookupGID(gid) })
+}
+
+func (c *cachingIDResolver) LookupUser(name string) (int, error) {
+	return c.lookupID("u"+name, func() (int, error) { return c.resolver.LookupUser(name) })
+}
+
+func (c *cachingIDResolver) LookupGroup(name string) (int, error) {
+	return c.lookupID("g"+name, func() (int, error) { return c.resolver.LookupGroup(name) })
+}
+
+// NewDBIDResolver returns an IDResolver which reads the user and group
+// database from the passwd(5) and group(5) files in dbdir, in the same way as
+// "mtree -N dbdir". A missing file is treated as an empty database. The files
+// are only read once, on first use.
+func NewDBIDResolver(dbdir string) IDResolver {
+	return &fileIDResolver{
+		passwd: filepath.Join(dbdir, "passwd"),
+		group:  filepath.Join(dbdir, "group"),
+	}
+}
+
+// NewRootIDResolver returns an IDResolver which reads the user and group
+// database of the root filesystem at root (such as a container rootfs or
+// chroot), from <root>/etc/passwd and <root>/etc/group.
+func NewRootIDResolver(root string) IDResolver {
+	return NewDBIDResolver(filepath.Join(root, "etc"))
+}
+
+type fileIDResolver struct {
+	passwd, group string
+
+	once          sync.Once
+	err           error
+	users, groups map[string]int
+	uids, gids    map[int]string
+}
+
+func (f *fileIDResolver) load() error {
+	f.once.Do(func() {
+		f.users, f.uids, f.err = readIDFile(f.passwd)
+		if f.err == nil {
+			f.groups, f.gids, f.err = readIDFile(f.group)
+		}
+	})
+	return f.err
+}
+
+// readIDFile reads the name and ID (the first and third fields) of each entry
+// in a passwd(5) or group(5) file. As with getpwnam(3), the first entry for a
+// name or ID wins.
+func readIDFile(path string) (map[string]int, map[int]string, error) {
+	ids := map[string]int{}
+	names := map[int]string{}
+	fh, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ids, names, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	defer fh.Close()
+	if err := parseIDFile(fh, ids, names); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return ids, names, nil
+}
+
+func parseIDFile(r io.Reader, ids map[string]int, names map[int]string) error {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		// Skip comments and NIS compat entries.
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "+") || strings.HasPrefix(line, "-") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) < 3 || fields[0] == "" {
+			continue
+		}
+		id, err := strconv.Atoi(fields[2])
+		if err != nil || id < 0 {
+			continue
+		}
+		if _, ok := ids[fields[0]]; !ok {
+			ids[fields[0]] = id
+		}
+		if _, ok := names[id]; !ok {
+			names[id] = fields[0]
+		}
+	}
+	return s.Err()
+}
+
+func (f *fileIDResolver) LookupUID(uid int) (string, error) {
+	if err := f.load(); err != nil {
+		return "", err
+	}
+	if name, ok := f.uids[uid]; ok {
+		return name, nil
+	}
+	return "", user.UnknownUserIdError(uid)
+}
+
+func (f *fileIDResolver) LookupGID(gid int) (string, error) {
+	if err := f.load(); err != nil {
+		return "", err
+	}
+	if name, ok := f.gids[gid]; ok {
+		return name, nil
+	}
+	return "", user.UnknownGroupIdError(strconv.Itoa(gid))
+}
+
+func (f *fileIDResolver) LookupUser(name string) (int, error) {
+	if err := f.load(); err != nil {
+		return -1, err
+	}
+	if uid, ok := f.users[name]; ok {
+		return uid, nil
+	}
+	return -1, user.UnknownUserError(name)
+}
+
+func (f *fileIDResolver) LookupGroup(name string) (int, error) {
+	if err := f.load(); err != nil {
+		return -1, err
+	}
+	if gid, ok := f.groups[name]; ok {
+		return gid, nil
+	}
+	return -1, user.UnknownGroupError(name)
+}
+
+// withIDResolver returns a copy of the registry where the "uname" and "gname"
+// keywords are collected and updated using res. Keywords which the caller has
+// registered themselves are left alone.
+func (r *KeywordRegistry) withIDResolver(res IDResolver) *KeywordRegistry {
+	c := r.Clone()
+	if spec, ok := c.specs["uname"]; ok && spec.resolvesIDs {
+		spec.Collect = unameResolverKeywordFunc(res, true)
+		spec.Update = unameUpdateKeywordFunc(res)
+		c.specs["uname"] = spec
+	}
+	if spec, ok := c.specs["gname"]; ok && spec.resolvesIDs {
+		spec.Collect = gnameResolverKeywordFunc(res, true)
+		spec.Update = gnameUpdateKeywordFunc(res)
+		c.specs["gname"] = spec
+	}
+	return c
+}
+
+// unameResolverKeywordFunc is like unameKeywordFunc, except that the name is
+// looked up with res. If strict, then it is an error for there to be no such
+// user, rather than the file having no value.
+func unameResolverKeywordFunc(res IDResolver, strict bool) KeywordFunc {
+	return func(path string, info os.FileInfo, r io.Reader) ([]KeyVal, error) {
+		if hdr, ok := info.Sys().(*tar.Header); ok {
+			return []KeyVal{KeyVal(fmt.Sprintf("uname=%s", hdr.Uname))}, nil
+		}
+		uid, _, ok := statIDs(info)
+		if !ok {
+			return nil, nil
+		}
+		name, err := res.LookupUID(uid)
+		if err != nil {
+			if !strict {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("uname for %q: %w", path, err)
+		}
+		return []KeyVal{KeyVal(fmt.Sprintf("uname=%s", name))}, nil
+	}
+}
+
+// gnameResolverKeywordFunc is like gnameKeywordFunc, except that the name is
+// looked up with res. If strict, then it is an error for there to be no such
+// group, rather than the file having no value.
+func gnameResolverKeywordFunc(res IDResolver, strict bool) KeywordFunc {
+	return func(path string, info os.FileInfo, r io.Reader) ([]KeyVal, error) {
+		if hdr, ok := info.Sys().(*tar.Header); ok {
+			return []KeyVal{KeyVal(fmt.Sprintf("gname=%s", hdr.Gname))}, nil
+		}
+		_, gid, ok := statIDs(info)
+		if !ok {
+			return nil, nil
+		}
+		name, err := res.LookupGID(gid)
+		if err != nil {
+			if !strict {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("gname for %q: %w", path, err)
+		}
+		return []KeyVal{KeyVal(fmt.Sprintf("gname=%s", name))}, nil
+	}
+}
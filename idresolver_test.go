@@ -0,0 +1,191 @@
+package mtree
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeIDFiles(t *testing.T, root, passwd, group string) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "etc"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "etc", "passwd"), []byte(passwd), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "etc", "group"), []byte(group), 0644))
+}
+
+func TestRootIDResolver(t *testing.T) {
+	root := t.TempDir()
+	writeIDFiles(t, root, `# comment
+root:x:0:0:root:/root:/bin/sh
+alice:x:1000:1000::/home/alice:/bin/sh
++nis
+alias:x:1000:1000::/home/alias:/bin/sh
+broken:x:notanumber:0::/:/bin/false
+`, `root:x:0:
+staff:x:50:alice
+`)
+
+	res := NewRootIDResolver(root)
+	name, err := res.LookupUID(1000)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", name, "the first entry for an id wins")
+	uid, err := res.LookupUser("alias")
+	require.NoError(t, err)
+	assert.Equal(t, 1000, uid)
+	gid, err := res.LookupGroup("staff")
+	require.NoError(t, err)
+	assert.Equal(t, 50, gid)
+	name, err = res.LookupGID(0)
+	require.NoError(t, err)
+	assert.Equal(t, "root", name)
+
+	_, err = res.LookupUID(1234)
+	assert.ErrorIs(t, err, user.UnknownUserIdError(1234))
+	_, err = res.LookupUser("broken")
+	assert.ErrorIs(t, err, user.UnknownUserError("broken"))
+	_, err = res.LookupGroup("nis")
+	assert.Error(t, err)
+
+	// A missing database is empty rather than an error.
+	res = NewDBIDResolver(filepath.Join(root, "nonexistent"))
+	_, err = res.LookupUID(0)
+	assert.ErrorIs(t, err, user.UnknownUserIdError(0))
+}
+
+type countingIDResolver struct {
+	calls int
+}
+
+func (c *countingIDResolver) LookupUID(uid int) (string, error) {
+	c.calls++
+	if uid == 0 {
+		return "root", nil
+	}
+	return "", user.UnknownUserIdError(uid)
+}
+
+func (c *countingIDResolver) LookupGID(gid int) (string, error) {
+	c.calls++
+	return fmt.Sprintf("group%d", gid), nil
+}
+
+func (c *countingIDResolver) LookupUser(name string) (int, error) {
+	c.calls++
+	return -1, errors.New("not implemented")
+}
+
+func (c *countingIDResolver) LookupGroup(name string) (int, error) {
+	c.calls++
+	return 0, nil
+}
+
+func TestCachingIDResolver(t *testing.T) {
+	inner := &countingIDResolver{}
+	res := NewCachingIDResolver(inner)
+	for i := 0; i < 3; i++ {
+		name, err := res.LookupUID(0)
+		require.NoError(t, err)
+		assert.Equal(t, "root", name)
+		_, err = res.LookupUID(1)
+		assert.Error(t, err)
+		_, err = res.LookupUser("root")
+		assert.Error(t, err)
+		name, err = res.LookupGID(0)
+		require.NoError(t, err)
+		assert.Equal(t, "group0", name)
+	}
+	assert.Equal(t, 4, inner.calls, "each lookup should only be made once")
+}
+
+func TestWalkIDResolver(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "file"), []byte("data"), 0644))
+	info, err := os.Lstat(filepath.Join(dir, "file"))
+	require.NoError(t, err)
+	uid, gid, ok := statIDs(info)
+	require.True(t, ok)
+
+	db := t.TempDir()
+	writeIDFiles(t, db,
+		fmt.Sprintf("chrootuser:x:%d:%d::/:/bin/sh\n", uid, gid),
+		fmt.Sprintf("chrootgroup:x:%d:\n", gid))
+	res := NewRootIDResolver(db)
+
+	keywords := []Keyword{"type", "uname", "gname"}
+	dh, err := WalkWithOptions(dir, &WalkOptions{Keywords: keywords, IDResolver: res})
+	require.NoError(t, err)
+	var found bool
+	for _, e := range dh.Entries {
+		if e.Name == "file" {
+			found = true
+			assert.Equal(t, []KeyVal{"uname=chrootuser"}, HasKeyword(e.AllKeys(), "uname"))
+			assert.Equal(t, []KeyVal{"gname=chrootgroup"}, HasKeyword(e.AllKeys(), "gname"))
+		}
+	}
+	require.True(t, found, "file entry should be present")
+
+	res2, err := CheckWithOptions(dir, dh, &CheckOptions{IDResolver: res})
+	require.NoError(t, err)
+	assert.Empty(t, res2)
+
+	// Update turns the names back into IDs using the same database.
+	res2, err = UpdateWithOptions(dir, dh, &UpdateOptions{Keywords: []Keyword{"uname", "gname"}, IDResolver: res})
+	require.NoError(t, err)
+	assert.Empty(t, res2)
+
+	// A failed lookup is an error, rather than silently dropping the keyword.
+	_, err = WalkWithOptions(dir, &WalkOptions{Keywords: keywords, IDResolver: NewRootIDResolver(t.TempDir())})
+	assert.ErrorIs(t, err, user.UnknownUserIdError(uid))
+
+	// The resolver does not replace a "uname" registered by the caller.
+	registry := DefaultKeywordRegistry.Clone()
+	require.NoError(t, registry.Register(KeywordSpec{
+		Name: "uname",
+		Collect: func(path string, info os.FileInfo, r io.Reader) ([]KeyVal, error) {
+			return []KeyVal{"uname=custom"}, nil
+		},
+	}))
+	dh, err = WalkWithOptions(dir, &WalkOptions{Keywords: keywords, IDResolver: res, Registry: registry})
+	require.NoError(t, err)
+	for _, e := range dh.Entries {
+		if e.Name == "file" {
+			assert.Equal(t, []KeyVal{"uname=custom"}, HasKeyword(e.AllKeys(), "uname"))
+			assert.Equal(t, []KeyVal{"gname=chrootgroup"}, HasKeyword(e.AllKeys(), "gname"))
+		}
+	}
+}
+
+func TestWalkUnknownHostID(t *testing.T) {
+	dir := t.TempDir()
+	fn := filepath.Join(dir, "file")
+	require.NoError(t, os.WriteFile(fn, []byte("data"), 0644))
+	// An ID which is very unlikely to have a name on the host.
+	const unknownID = 1<<31 - 3
+	if err := os.Lchown(fn, unknownID, unknownID); err != nil {
+		t.Skipf("cannot chown to an unknown ID: %v", err)
+	}
+	if _, err := HostIDResolver.LookupUID(unknownID); err == nil {
+		t.Skip("the unknown ID has a name on this host")
+	}
+
+	// Without an IDResolver, the file has no value.
+	dh, err := Walk(dir, nil, []Keyword{"type", "uname", "gname"}, nil)
+	require.NoError(t, err)
+	for _, e := range dh.Entries {
+		if e.Name == "file" {
+			assert.Empty(t, HasKeyword(e.Keywords, "uname"))
+			assert.Empty(t, HasKeyword(e.Keywords, "gname"))
+		}
+	}
+
+	// With one, a failed lookup is an error, even for the host's database.
+	_, err = WalkWithOptions(dir, &WalkOptions{Keywords: []Keyword{"type", "uname"}, IDResolver: HostIDResolver})
+	assert.ErrorIs(t, err, user.UnknownUserIdError(unknownID))
+}
@@ -0,0 +1,202 @@
+package mtree
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha3"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vbatts/go-mtree/xattr"
+
+	//nolint:staticcheck // SA1019 yes ripemd160 is deprecated, but IMA may use it
+	"golang.org/x/crypto/ripemd160"
+)
+
+// imaXattr is the extended attribute in which IMA stores the hash or signature
+// of a file for appraisal.
+const imaXattr = "security.ima"
+
+// The types of the security.ima value, as in the kernel's
+// security/integrity/integrity.h.
+const (
+	imaXattrDigest         = 0x01 // IMA_XATTR_DIGEST
+	imaXattrDigsig         = 0x03 // EVM_IMA_XATTR_DIGSIG
+	imaXattrDigestNG       = 0x04 // IMA_XATTR_DIGEST_NG
+	imaXattrPortableDigsig = 0x05 // EVM_XATTR_PORTABLE_DIGSIG
+	imaXattrVerityDigsig   = 0x06 // IMA_VERITY_DIGSIG
+)
+
+// imaStale is appended to the "ima" value of a file whose contents don't
+// match the digest in its security.ima.
+const imaStale = ";stale"
+
+// imaInvalid prefixes the "ima" value of a file whose security.ima can't be
+// decoded, followed by the value in hex.
+const imaInvalid = "invalid:"
+
+// imaHashAlgos are the hash algorithms of IMA, indexed by their number in
+// <linux/hash_info.h>, with their names as the kernel gives them. Algorithms
+// which aren't available here have a nil constructor, so digests using them
+// can't be checked.
+var imaHashAlgos = []struct {
+	name string
+	new  func() hash.Hash
+}{
+	{"md4", nil},
+	{"md5", md5.New},
+	{"sha1", sha1.New},
+	{"rmd160", ripemd160.New},
+	{"sha256", sha256.New},
+	{"sha384", sha512.New384},
+	{"sha512", sha512.New},
+	{"sha224", sha256.New224},
+	{"rmd128", nil},
+	{"rmd256", nil},
+	{"rmd320", nil},
+	{"wp256", nil},
+	{"wp384", nil},
+	{"wp512", nil},
+	{"tgr128", nil},
+	{"tgr160", nil},
+	{"tgr192", nil},
+	{"sm3", nil},
+	{"streebog256", nil},
+	{"streebog512", nil},
+	{"sha3-256", func() hash.Hash { return sha3.New256() }},
+	{"sha3-384", func() hash.Hash { return sha3.New384() }},
+	{"sha3-512", func() hash.Hash { return sha3.New512() }},
+}
+
+// imaValue is a decoded security.ima value.
+type imaValue struct {
+	typ    byte
+	algo   byte
+	digest []byte // of digest types
+	keyID  uint32 // of signature types
+}
+
+// decodeIMA decodes a security.ima value: either a digest (of the legacy
+// SHA-1 type, or one giving its hash algorithm), or a signature_v2_hdr followed
+// by a signature. The header is version 3 for fs-verity signatures, and
+// version 2 otherwise.
+func decodeIMA(buf []byte) (imaValue, error) {
+	if len(buf) == 0 {
+		return imaValue{}, fmt.Errorf("empty %s", imaXattr)
+	}
+	v := imaValue{typ: buf[0]}
+	switch v.typ {
+	case imaXattrDigest:
+		v.algo = 2 // HASH_ALGO_SHA1
+		v.digest = buf[1:]
+	case imaXattrDigestNG:
+		if len(buf) < 2 {
+			return v, fmt.Errorf("invalid %s: too short", imaXattr)
+		}
+		v.algo = buf[1]
+		v.digest = buf[2:]
+	case imaXattrDigsig, imaXattrPortableDigsig, imaXattrVerityDigsig:
+		// struct signature_v2_hdr
+		if len(buf) < 9 {
+			return v, fmt.Errorf("invalid %s: too short", imaXattr)
+		}
+		version := byte(2)
+		if v.typ == imaXattrVerityDigsig {
+			version = 3
+		}
+		if buf[1] != version {
+			return v, fmt.Errorf("invalid %s: unsupported signature version %d", imaXattr, buf[1])
+		}
+		v.algo = buf[2]
+		v.keyID = binary.BigEndian.Uint32(buf[3:7])
+	default:
+		return v, fmt.Errorf("invalid %s: unknown type %#x", imaXattr, v.typ)
+	}
+	if int(v.algo) >= len(imaHashAlgos) {
+		return v, fmt.Errorf("invalid %s: unknown hash algorithm %d", imaXattr, v.algo)
+	}
+	if v.digest != nil && len(v.digest) == 0 {
+		return v, fmt.Errorf("invalid %s: empty digest", imaXattr)
+	}
+	return v, nil
+}
+
+// formatIMA returns the "ima" value of v: "<algorithm>:<digest>" for digests,
+// or "<type>:<algorithm>:<key ID>" for signatures, such as "sig:sha256:1a2b3c4d".
+func formatIMA(v imaValue) string {
+	algo := imaHashAlgos[v.algo].name
+	switch v.typ {
+	case imaXattrDigsig:
+		return fmt.Sprintf("sig:%s:%08x", algo, v.keyID)
+	case imaXattrPortableDigsig:
+		return fmt.Sprintf("portable-sig:%s:%08x", algo, v.keyID)
+	case imaXattrVerityDigsig:
+		return fmt.Sprintf("verity-sig:%s:%08x", algo, v.keyID)
+	}
+	return algo + ":" + hex.EncodeToString(v.digest)
+}
+
+// compareIMA flags files whose IMA digest is stale or whose security.ima is
+// invalid, as well as changes to the digest or signature.
+func compareIMA(old, new KeyVal, opts *CompareOptions) bool {
+	if strings.HasSuffix(new.Value(), imaStale) || strings.HasPrefix(new.Value(), imaInvalid) {
+		return false
+	}
+	return old.Value() == new.Value()
+}
+
+// imaKeywordFunc returns the IMA digest or signature of regular files, from
+// their security.ima extended attribute. The contents of files with a digest
+// are hashed with its algorithm, and the value ends with ";stale" if they
+// don't match. A security.ima which can't be decoded is given as "invalid:"
+// followed by its value in hex, rather than stopping the walk. Files without
+// security.ima have no value. For tar archives, the extended attribute is read
+// from the "SCHILY.xattr." PAX records.
+func imaKeywordFunc(path string, info os.FileInfo, r io.Reader) ([]KeyVal, error) {
+	var buf []byte
+	if hdr, ok := info.Sys().(*tar.Header); ok {
+		v, ok := hdr.PAXRecords["SCHILY.xattr."+imaXattr]
+		if !ok || !info.Mode().IsRegular() {
+			return nil, nil
+		}
+		buf = []byte(v)
+	} else {
+		if !info.Mode().IsRegular() {
+			return nil, nil
+		}
+		names, err := xattr.List(path)
+		if err != nil || !slices.Contains(names, imaXattr) {
+			return nil, nil
+		}
+		if buf, err = xattr.Get(path, imaXattr); err != nil {
+			return nil, fmt.Errorf("ima for %q: %w", path, err)
+		}
+	}
+	v, err := decodeIMA(buf)
+	if err != nil {
+		logrus.Debugf("ima for %q: %v", path, err)
+		return []KeyVal{KeyVal("ima=" + imaInvalid + hex.EncodeToString(buf))}, nil
+	}
+	value := formatIMA(v)
+	if newHash := imaHashAlgos[v.algo].new; v.digest != nil && newHash != nil && r != nil {
+		h := newHash()
+		if _, err := io.Copy(h, r); err != nil {
+			return nil, fmt.Errorf("ima for %q: %w", path, err)
+		}
+		if !bytes.Equal(h.Sum(nil), v.digest) {
+			value += imaStale
+		}
+	}
+	return []KeyVal{KeyVal("ima=" + value)}, nil
+}
@@ -0,0 +1,44 @@
+package mtree
+
+import (
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vbatts/go-mtree/xattr"
+)
+
+func TestIMACheck(t *testing.T) {
+	// /tmp is often tmpfs, which may not support security xattrs.
+	dir, err := os.MkdirTemp(".", "test.ima.")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "file")
+	require.NoError(t, os.WriteFile(file, []byte("hello"), 0644))
+	sum := sha256.Sum256([]byte("hello"))
+	if err := xattr.Set(file, imaXattr, append([]byte{0x04, 4}, sum[:]...)); err != nil {
+		t.Skipf("skipping: cannot set %s in %q: %v", imaXattr, dir, err)
+	}
+
+	keywords := []Keyword{"type", "ima"}
+	dh, err := Walk(dir, nil, keywords, nil)
+	require.NoError(t, err)
+	res, err := Check(dir, dh, keywords, nil)
+	require.NoError(t, err)
+	assert.Empty(t, res)
+
+	// Changing the contents without updating security.ima makes it stale.
+	require.NoError(t, os.WriteFile(file, []byte("world"), 0644))
+	res, err = Check(dir, dh, keywords, nil)
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	assert.Equal(t, Modified, res[0].Type())
+	assert.Equal(t, "file", res[0].Path())
+	require.Len(t, res[0].Diff(), 1)
+	assert.Equal(t, "ima", string(res[0].Diff()[0].Name()))
+}
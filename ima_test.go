@@ -0,0 +1,93 @@
+package mtree
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeIMA(t *testing.T) {
+	sum := sha256.Sum256([]byte("hello"))
+	for _, test := range []struct {
+		name  string
+		buf   []byte
+		value string
+	}{
+		{"digest-ng", append([]byte{0x04, 4}, sum[:]...), "sha256:2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"},
+		{"digest", []byte{0x01, 0xaa, 0xbb}, "sha1:aabb"},
+		{"signature", []byte{0x03, 2, 6, 0x1a, 0x2b, 0x3c, 0x4d, 0, 1, 0xff}, "sig:sha512:1a2b3c4d"},
+		{"verity-signature", []byte{0x06, 3, 4, 0, 0, 0, 1, 0, 0}, "verity-sig:sha256:00000001"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			v, err := decodeIMA(test.buf)
+			require.NoError(t, err)
+			assert.Equal(t, test.value, formatIMA(v))
+		})
+	}
+
+	for _, buf := range [][]byte{
+		{},
+		{0x04},
+		{0x04, 4},
+		{0x04, 200, 1},
+		{0x02, 1},
+		{0x03, 1, 4, 0, 0, 0, 1, 0, 0},
+		{0x03, 2, 4},
+		{0x06, 2, 4, 0, 0, 0, 1, 0, 0},
+		{0x05, 3, 4, 0, 0, 0, 1, 0, 0},
+	} {
+		_, err := decodeIMA(buf)
+		assert.Error(t, err, "%x", buf)
+	}
+}
+
+func TestCompareIMA(t *testing.T) {
+	assert.True(t, compareIMA("ima=sha256:aabb", "ima=sha256:aabb", nil))
+	assert.False(t, compareIMA("ima=sha256:aabb", "ima=sha256:aacc", nil))
+	// Stale digests are always flagged.
+	assert.False(t, compareIMA("ima=sha256:aabb", "ima=sha256:aabb;stale", nil))
+	assert.False(t, compareIMA("ima=sha256:aabb;stale", "ima=sha256:aabb;stale", nil))
+	// As are invalid values.
+	assert.False(t, compareIMA("ima=invalid:02", "ima=invalid:02", nil))
+}
+
+func TestIMATar(t *testing.T) {
+	sum := sha256.Sum256([]byte("hello"))
+	ima := string(append([]byte{0x04, 4}, sum[:]...))
+
+	var files []tarFile
+	for name, data := range map[string]string{"good": "hello", "stale": "world"} {
+		files = append(files, tarFile{
+			Header: tar.Header{
+				Name:       name,
+				Mode:       0644,
+				Typeflag:   tar.TypeReg,
+				PAXRecords: map[string]string{"SCHILY.xattr." + imaXattr: ima},
+			},
+			Data: []byte(data),
+		})
+	}
+	// A security.ima which can't be decoded doesn't stop the others from
+	// being collected.
+	files = append(files, tarFile{
+		Header: tar.Header{
+			Name:       "invalid",
+			Mode:       0644,
+			Typeflag:   tar.TypeReg,
+			PAXRecords: map[string]string{"SCHILY.xattr." + imaXattr: "\x02\x01"},
+		},
+		Data: []byte("hello"),
+	})
+	dh := tarHierarchy(t, &TarStreamerOptions{Keywords: []Keyword{"type", "ima"}}, files...)
+
+	values := map[string][]KeyVal{}
+	for _, e := range dh.Entries {
+		values[e.Name] = HasKeyword(e.Keywords, "ima")
+	}
+	assert.Equal(t, []KeyVal{"ima=sha256:2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"}, values["good"])
+	assert.Equal(t, []KeyVal{"ima=sha256:2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824;stale"}, values["stale"])
+	assert.Equal(t, []KeyVal{"ima=invalid:0201"}, values["invalid"])
+}
@@ -0,0 +1,153 @@
+package mtree
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vbatts/go-mtree/pkg/govis"
+)
+
+// The KeywordCompareFuncs for the builtin keywords. Values which cannot be
+// parsed are compared as plain strings, so that a malformed value is only
+// ever equal to an identical one.
+
+// compareDecimal compares numeric values written in decimal, such as "uid"
+// and "size".
+func compareDecimal(old, new KeyVal, opts *CompareOptions) bool {
+	a, errA := strconv.ParseInt(old.Value(), 10, 64)
+	b, errB := strconv.ParseInt(new.Value(), 10, 64)
+	if errA != nil || errB != nil {
+		return old.Value() == new.Value()
+	}
+	return a == b
+}
+
+// compareTime compares "time" values (and other timestamps), which are seconds
+// since the epoch with an optional decimal fraction, so that "1.5" and
+// "1.500000000" are equal. The times are truncated to opts.TimePrecision, and
+// then must be within opts.TimeTolerance of each other.
+func compareTime(old, new KeyVal, opts *CompareOptions) bool {
+	a, errA := parseTimeValue(old.Value())
+	b, errB := parseTimeValue(new.Value())
+	if errA != nil || errB != nil {
+		return old.Value() == new.Value()
+	}
+	return timesEqual(a, b, opts)
+}
+
+// compareTarTime compares "tar_time" values, which only have whole seconds as
+// tar archives do not store nanosecond precision. Either value may be a "time"
+// value instead (which is an equivalent of "tar_time"), which is truncated to
+// whole seconds.
+func compareTarTime(old, new KeyVal, opts *CompareOptions) bool {
+	a, errA := parseTimeValue(old.Value())
+	b, errB := parseTimeValue(new.Value())
+	if errA != nil || errB != nil {
+		return old.Value() == new.Value()
+	}
+	return timesEqual(a.Truncate(time.Second), b.Truncate(time.Second), opts)
+}
+
+// timesEqual returns whether a and b are equal, after truncating them to
+// opts.TimePrecision and allowing for opts.TimeTolerance.
+func timesEqual(a, b time.Time, opts *CompareOptions) bool {
+	if opts.TimePrecision > 0 {
+		a = a.Truncate(opts.TimePrecision)
+		b = b.Truncate(opts.TimePrecision)
+	}
+	diff := a.Sub(b)
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff <= opts.TimeTolerance
+}
+
+// ParseTimePrecision parses the name of a time precision, for use as
+// CompareOptions.TimePrecision. As well as any time.ParseDuration value (such
+// as "2s" for FAT filesystems), the bare units "ns", "us" (or "µs"), "ms" and
+// "s" are accepted.
+func ParseTimePrecision(s string) (time.Duration, error) {
+	switch s {
+	case "ns":
+		return time.Nanosecond, nil
+	case "us", "µs":
+		return time.Microsecond, nil
+	case "ms":
+		return time.Millisecond, nil
+	case "s":
+		return time.Second, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time precision %q: %w", s, err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("invalid time precision %q: must be positive", s)
+	}
+	return d, nil
+}
+
+// parseTimeValue parses a "time" keyword value of the form "sec.nsec", where
+// the fractional part is a decimal fraction of a second of up to 9 digits. The
+// sign applies to the whole value, so "-1.5" is a second and a half before the
+// epoch.
+func parseTimeValue(v string) (time.Time, error) {
+	secStr, fracStr, _ := strings.Cut(v, ".")
+	sec, err := strconv.ParseInt(secStr, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: %w", v, err)
+	}
+	var nsec int64
+	if fracStr != "" {
+		if len(fracStr) > 9 {
+			return time.Time{}, fmt.Errorf("invalid time %q: more than nanosecond precision", v)
+		}
+		nsec, err = strconv.ParseInt(fracStr+strings.Repeat("0", 9-len(fracStr)), 10, 64)
+		if err != nil || nsec < 0 {
+			return time.Time{}, fmt.Errorf("invalid time %q: bad fraction", v)
+		}
+		if strings.HasPrefix(secStr, "-") {
+			nsec = -nsec
+		}
+	}
+	return time.Unix(sec, nsec), nil
+}
+
+// compareDigest compares message digests, which can be written either in hex
+// (of any case) or in base64.
+func compareDigest(old, new KeyVal, opts *CompareOptions) bool {
+	a, errA := decodeDigest(old.Value())
+	b, errB := decodeDigest(new.Value())
+	if errA != nil || errB != nil {
+		return old.Value() == new.Value()
+	}
+	return bytes.Equal(a, b)
+}
+
+func decodeDigest(v string) ([]byte, error) {
+	if sum, err := hex.DecodeString(v); err == nil {
+		return sum, nil
+	}
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if sum, err := enc.DecodeString(v); err == nil {
+			return sum, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid digest %q", v)
+}
+
+// compareLink compares "link" values after decoding them, so that different
+// (but equivalent) vis(3) encodings of the same target are equal.
+func compareLink(old, new KeyVal, opts *CompareOptions) bool {
+	a, errA := govis.Unvis(old.Value(), DefaultVisFlags)
+	b, errB := govis.Unvis(new.Value(), DefaultVisFlags)
+	if errA != nil || errB != nil {
+		return old.Value() == new.Value()
+	}
+	return a == b
+}
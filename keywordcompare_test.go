@@ -0,0 +1,182 @@
+package mtree
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyValEqual(t *testing.T) {
+	for _, test := range []struct {
+		a, b  KeyVal
+		equal bool
+	}{
+		{"mode=0755", "mode=755", true},
+		{"mode=0755", "mode=0644", false},
+		{"mode=bogus", "mode=bogus", true},
+		{"mode=bogus", "mode=0755", false},
+		{"uid=0", "uid=000", true},
+		{"uid=0", "uid=1", false},
+		{"gid=100", "gid=0100", true},
+		{"size=42", "size=042", true},
+		{"nlink=1", "nlink=2", false},
+		{"time=1.5", "time=1.500000000", true},
+		{"time=1", "time=1.000000000", true},
+		{"time=1.5", "time=1.050000000", false},
+		{"time=1.0000000001", "time=1.0000000001", true},
+		{"time=1.0000000001", "time=1.000000000", false},
+		{"time=-1.5", "time=-1.500000000", true},
+		{"time=-1.5", "time=-0.500000000", false},
+		{"time=-0.5", "time=0.5", false},
+		{"tar_time=12.000000000", "tar_time=12.0", true},
+		{"sha1digest=DA39A3EE5E6B4B0D3255BFEF95601890AFD80709", "sha1digest=da39a3ee5e6b4b0d3255bfef95601890afd80709", true},
+		{"sha1digest=2jmj7l5rSw0yVb/vlWAYkK/YBwk=", "sha1digest=da39a3ee5e6b4b0d3255bfef95601890afd80709", true},
+		{"sha1digest=2jmj7l5rSw0yVb/vlWAYkK/YBwk", "sha1digest=da39a3ee5e6b4b0d3255bfef95601890afd80709", true},
+		{"sha1digest=0000", "sha1digest=da39a3ee5e6b4b0d3255bfef95601890afd80709", false},
+		{"link=a\\040b", "link=a\\sb", true},
+		{"link=a\\040b", "link=a\\040c", false},
+		{"uid=0", "gid=0", false},
+		{"type=file", "type=file", true},
+		{"type=file", "type=FILE", false},
+	} {
+		t.Run(string(test.a)+"~"+string(test.b), func(t *testing.T) {
+			assert.Equal(t, test.equal, test.a.Equal(test.b))
+			assert.Equal(t, test.equal, test.b.Equal(test.a))
+		})
+	}
+}
+
+func TestCompareNormalisedValues(t *testing.T) {
+	old, err := ParseSpec(strings.NewReader(`
+.               type=dir mode=0755 time=1.5
+    file        type=file mode=0644 uid=0 size=5 sha256digest=2CF24DBA5FB0A30E26E83B2AC5B9E29E1B161E5C1FA7425E73043362938B9824
+..
+`))
+	require.NoError(t, err)
+	gnu, err := ParseSpec(strings.NewReader(`
+.               type=dir mode=755 time=1.500000000
+    file        type=file mode=644 uid=00 size=5 sha256digest=LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ=
+..
+`))
+	require.NoError(t, err)
+
+	res, err := Compare(old, gnu, nil)
+	require.NoError(t, err)
+	if !assert.Empty(t, res, "equivalent values should compare equal") {
+		pprintInodeDeltas(t, res)
+	}
+}
+
+func TestCompareTarTimeEquivalent(t *testing.T) {
+	tarDh, err := ParseSpec(strings.NewReader(`
+.               type=dir tar_time=10.000000000
+    a           type=file tar_time=10.000000000
+    b           type=file tar_time=10.000000000
+..
+`))
+	require.NoError(t, err)
+	fsDh, err := ParseSpec(strings.NewReader(`
+.               type=dir time=10.500000000
+    a           type=file time=10.999999999
+    b           type=file time=11.000000000
+..
+`))
+	require.NoError(t, err)
+
+	for _, keys := range [][]Keyword{nil, {"type", "time"}, {"type", "tar_time"}} {
+		res, err := Compare(tarDh, fsDh, keys)
+		require.NoError(t, err)
+		if assert.Len(t, res, 1, "keywords %v", keys) {
+			assert.Equal(t, "b", res[0].Path())
+			assert.Equal(t, []KeyDelta{{diff: Modified, name: "tar_time", old: "10.000000000", new: "11.000000000"}}, res[0].Diff())
+		}
+	}
+
+	// Without an equivalent, "time" is compared as before.
+	registry := DefaultKeywordRegistry.Clone()
+	spec, _ := registry.Lookup("tar_time")
+	spec.Equivalents = nil
+	require.NoError(t, registry.Register(spec))
+	res, err := CompareWithOptions(tarDh, fsDh, &CompareOptions{Registry: registry})
+	require.NoError(t, err)
+	assert.Len(t, res, 3)
+}
+
+func TestCompareTimePrecision(t *testing.T) {
+	for _, test := range []struct {
+		a, b      KeyVal
+		precision time.Duration
+		tolerance time.Duration
+		equal     bool
+	}{
+		{"time=10.123456789", "time=10.123456000", 0, 0, false},
+		{"time=10.123456789", "time=10.123456000", time.Microsecond, 0, true},
+		{"time=10.123456789", "time=10.000000000", time.Microsecond, 0, false},
+		{"time=10.123456789", "time=10.000000000", time.Second, 0, true},
+		{"time=10.123456789", "time=11.000000000", time.Second, 0, false},
+		{"time=11.000000000", "time=10.500000000", 2 * time.Second, 0, true},
+		{"time=12.000000000", "time=11.900000000", 2 * time.Second, 0, false},
+		{"time=10.000000000", "time=11.900000000", 0, 2 * time.Second, true},
+		{"time=10.000000000", "time=12.000000001", 0, 2 * time.Second, false},
+		{"time=-1.5", "time=-2.000000000", 0, time.Second, true},
+		{"time=-1.5", "time=-0.400000000", 0, time.Second, false},
+		{"time=12.000000000", "time=11.900000000", 2 * time.Second, 2 * time.Second, true},
+		{"tar_time=10.000000000", "tar_time=11.000000000", 0, time.Second, true},
+	} {
+		opts := &CompareOptions{TimePrecision: test.precision, TimeTolerance: test.tolerance}
+		assert.Equalf(t, test.equal, compareTime(test.a, test.b, opts), "%s ~ %s (precision=%s, tolerance=%s)", test.a, test.b, test.precision, test.tolerance)
+		assert.Equalf(t, test.equal, compareTime(test.b, test.a, opts), "%s ~ %s (precision=%s, tolerance=%s)", test.b, test.a, test.precision, test.tolerance)
+	}
+}
+
+func TestParseTimePrecision(t *testing.T) {
+	for give, expect := range map[string]time.Duration{
+		"ns": time.Nanosecond,
+		"us": time.Microsecond,
+		"µs": time.Microsecond,
+		"s":  time.Second,
+		"2s": 2 * time.Second,
+	} {
+		got, err := ParseTimePrecision(give)
+		require.NoErrorf(t, err, "parse %q", give)
+		assert.Equalf(t, expect, got, "parse %q", give)
+	}
+	for _, bad := range []string{"", "fortnight", "0s", "-1s"} {
+		_, err := ParseTimePrecision(bad)
+		assert.Errorf(t, err, "parse %q", bad)
+	}
+}
+
+func TestCheckTimeTolerance(t *testing.T) {
+	dir := t.TempDir()
+	fn := filepath.Join(dir, "file")
+	require.NoError(t, os.WriteFile(fn, []byte("data"), 0644))
+	mtime := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, os.Chtimes(fn, mtime, mtime))
+
+	dh, err := Walk(dir, nil, []Keyword{"type", "time"}, nil)
+	require.NoError(t, err)
+
+	// Simulate copying to a filesystem with 2 second granularity.
+	mtime = mtime.Add(1500 * time.Millisecond)
+	require.NoError(t, os.Chtimes(fn, mtime, mtime))
+
+	res, err := Check(dir, dh, nil, nil)
+	require.NoError(t, err)
+	if assert.Len(t, res, 1) {
+		assert.Equal(t, "file", res[0].Path())
+	}
+
+	res, err = CheckWithOptions(dir, dh, &CheckOptions{TimePrecision: 2 * time.Second})
+	require.NoError(t, err)
+	assert.Empty(t, res)
+
+	res, err = CheckWithOptions(dir, dh, &CheckOptions{TimeTolerance: 2 * time.Second})
+	require.NoError(t, err)
+	assert.Empty(t, res)
+}
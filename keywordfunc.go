@@ -5,6 +5,7 @@ import (
 	"crypto/md5"
 	"crypto/sha1"
 	"crypto/sha256"
+	"crypto/sha3"
 	"crypto/sha512"
 	"fmt"
 	"hash"
@@ -12,6 +13,7 @@ import (
 	"os"
 
 	"github.com/vbatts/go-mtree/pkg/govis"
+	"golang.org/x/crypto/blake2b"
 
 	//nolint:staticcheck // SA1019 yes ripemd160 is deprecated, but this is for mtree compatibility
 	"golang.org/x/crypto/ripemd160"
@@ -27,6 +29,10 @@ type KeywordFunc func(path string, info os.FileInfo, r io.Reader) ([]KeyVal, err
 
 var (
 	// KeywordFuncs is the map of all keywords (and the functions to produce them)
+	//
+	// Deprecated: Register keywords with DefaultKeywordRegistry (or a clone
+	// of it) instead. Keywords added here are still found by
+	// DefaultKeywordRegistry, but only if they have not been registered.
 	KeywordFuncs = map[Keyword]KeywordFunc{
 		"size":            sizeKeywordFunc,                                      // The size, in bytes, of the file
 		"type":            typeKeywordFunc,                                      // The type of the file
@@ -55,6 +61,16 @@ var (
 		"sha512256":       hasherKeywordFunc("sha512digest", sha512.New512_256), // The SHA512/256 message digest of the file
 		"sha512256digest": hasherKeywordFunc("sha512digest", sha512.New512_256), // A synonym for `sha512256`
 
+		// These are not upstreamed keywords, but more modern digests.
+		"sha3_256":         hasherKeywordFunc("sha3_256digest", newSHA3_256),     // The SHA3-256 message digest of the file
+		"sha3_256digest":   hasherKeywordFunc("sha3_256digest", newSHA3_256),     // A synonym for `sha3_256`
+		"sha3_512":         hasherKeywordFunc("sha3_512digest", newSHA3_512),     // The SHA3-512 message digest of the file
+		"sha3_512digest":   hasherKeywordFunc("sha3_512digest", newSHA3_512),     // A synonym for `sha3_512`
+		"blake2b256":       hasherKeywordFunc("blake2b256digest", newBLAKE2b256), // The BLAKE2b-256 message digest of the file
+		"blake2b256digest": hasherKeywordFunc("blake2b256digest", newBLAKE2b256), // A synonym for `blake2b256`
+		"blake2b512":       hasherKeywordFunc("blake2b512digest", newBLAKE2b512), // The BLAKE2b-512 message digest of the file
+		"blake2b512digest": hasherKeywordFunc("blake2b512digest", newBLAKE2b512), // A synonym for `blake2b512`
+
 		"flags": flagsKeywordFunc, // NOTE: this is a noop, but here to support the presence of the "flags" keyword.
 
 		// This is not an upstreamed keyword, but used to vary from "time", as tar
@@ -111,9 +127,15 @@ var (
 			if _, err := io.Copy(h, r); err != nil {
 				return nil, err
 			}
-			return []KeyVal{KeyVal(fmt.Sprintf("%s=%x", KeywordSynonym(name), h.Sum(nil)))}, nil
+			return []KeyVal{KeyVal(fmt.Sprintf("%s=%x", name, h.Sum(nil)))}, nil
 		}
 	}
+	// The constructors of the SHA-3 and BLAKE2b hashes, as used by
+	// hasherKeywordFunc. BLAKE2b is used without a key, so can't fail.
+	newSHA3_256        = func() hash.Hash { return sha3.New256() }
+	newSHA3_512        = func() hash.Hash { return sha3.New512() }
+	newBLAKE2b256      = func() hash.Hash { h, _ := blake2b.New256(nil); return h }
+	newBLAKE2b512      = func() hash.Hash { h, _ := blake2b.New512(nil); return h }
 	tartimeKeywordFunc = func(path string, info os.FileInfo, r io.Reader) ([]KeyVal, error) {
 		return []KeyVal{KeyVal(fmt.Sprintf("tar_time=%d.%9.9d", info.ModTime().Unix(), 0))}, nil
 	}
@@ -64,6 +64,12 @@ var (
 		}
 		return nil, nil
 	}
+	blocksKeywordFunc = func(path string, info os.FileInfo, r io.Reader) ([]KeyVal, error) {
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			return []KeyVal{KeyVal(fmt.Sprintf("blocks=%d", stat.Blocks))}, nil
+		}
+		return nil, nil
+	}
 	xattrKeywordFunc = func(path string, info os.FileInfo, r io.Reader) ([]KeyVal, error) {
 		return nil, nil
 	}
@@ -17,11 +17,6 @@ import (
 )
 
 var (
-	// this is bsd specific https://www.freebsd.org/cgi/man.cgi?query=chflags&sektion=2
-	flagsKeywordFunc = func(path string, info os.FileInfo, r io.Reader) ([]KeyVal, error) {
-		return nil, nil
-	}
-
 	unameKeywordFunc = func(path string, info os.FileInfo, r io.Reader) ([]KeyVal, error) {
 		if hdr, ok := info.Sys().(*tar.Header); ok {
 			return []KeyVal{KeyVal(fmt.Sprintf("uname=%s", hdr.Uname))}, nil
@@ -68,6 +63,12 @@ var (
 		}
 		return nil, nil
 	}
+	blocksKeywordFunc = func(path string, info os.FileInfo, r io.Reader) ([]KeyVal, error) {
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			return []KeyVal{KeyVal(fmt.Sprintf("blocks=%d", stat.Blocks))}, nil
+		}
+		return nil, nil
+	}
 	xattrKeywordFunc = func(path string, info os.FileInfo, r io.Reader) ([]KeyVal, error) {
 		if hdr, ok := info.Sys().(*tar.Header); ok {
 			if len(hdr.PAXRecords) == 0 {
@@ -83,17 +84,15 @@ var (
 			}
 			return klist, nil
 		}
-		if !info.Mode().IsRegular() && !info.Mode().IsDir() {
-			return nil, nil
-		}
-
-		xlist, err := xattr.List(path)
+		// Symlinks are not followed, so that the extended attributes of the
+		// symlink itself are collected.
+		xlist, err := xattr.Llist(path)
 		if err != nil {
 			return nil, nil
 		}
 		klist := make([]KeyVal, len(xlist))
 		for i := range xlist {
-			data, err := xattr.Get(path, xlist[i])
+			data, err := xattr.Lget(path, xlist[i])
 			if err != nil {
 				return nil, nil
 			}
@@ -0,0 +1,296 @@
+package mtree
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+
+	//nolint:staticcheck // SA1019 yes ripemd160 is deprecated, but this is for mtree compatibility
+	"golang.org/x/crypto/ripemd160"
+)
+
+// KeywordCompareFunc is the type of a function which returns whether the
+// values of a keyword in two entries are equivalent. Both old and new have the
+// same Keyword(). opts are the options of the comparison, and are never nil.
+type KeywordCompareFunc func(old, new KeyVal, opts *CompareOptions) bool
+
+// KeywordSpec describes a keyword and how it is collected, compared and
+// updated.
+type KeywordSpec struct {
+	// Name is the canonical name of the keyword. Keywords such as "xattr",
+	// which are written with a suffix ("xattr.user.foo"), are registered
+	// with just the prefix, unless each suffix is a separate keyword (as
+	// with "exec.<name>").
+	Name Keyword
+
+	// Synonyms are other names for the keyword, which are converted to Name
+	// by KeywordRegistry.Canonical.
+	Synonyms []Keyword
+
+	// Collect produces the keyword's values for a file. A keyword without a
+	// Collect function can be read from a manifest, but not generated.
+	Collect KeywordFunc
+
+	// Update restores the keyword's value to a file. If nil, the keyword
+	// cannot be updated.
+	Update UpdateKeywordFunc
+
+	// Compare returns whether two values of the keyword are equivalent. If
+	// nil, then KeyVal.Equal is used.
+	Compare KeywordCompareFunc
+
+	// Equivalents are other keywords whose values can be compared with the
+	// keyword's, such as "time" for "tar_time". If one entry has the keyword
+	// and the other only has an equivalent, then Compare is given the
+	// equivalent's value in its place.
+	Equivalents []Keyword
+
+	// IsBsd is whether the keyword is in the upstream FreeBSD mtree(8).
+	IsBsd bool
+
+	// IsDefault is whether the keyword is in the default set of keywords.
+	IsDefault bool
+
+	// ConsumesContent is whether Collect reads the content of regular
+	// files. Files are only opened for keywords which need them.
+	ConsumesContent bool
+
+	// Volatile is whether the keyword's value can never be restored by
+	// Update, such as "ctime" (which changes whenever a file is updated) and
+	// "btime". Asking Update for a volatile keyword results in an
+	// ErrorDifference for each value, and Compare marks the discrepancies in
+	// it as volatile (see KeyDelta.Volatile).
+	Volatile bool
+
+	// resolvesIDs is whether Collect and Update look up names with an
+	// IDResolver, which are replaced by withIDResolver.
+	resolvesIDs bool
+}
+
+// KeywordRegistry is a set of KeywordSpec, which is consulted by Walk,
+// Compare, Check and Update to find out how to handle each keyword. It is
+// safe for concurrent use.
+type KeywordRegistry struct {
+	mu       sync.RWMutex
+	specs    map[Keyword]KeywordSpec
+	synonyms map[Keyword]Keyword
+
+	// legacy is whether keywords which are missing from the registry are
+	// looked up in KeywordFuncs and UpdateKeywordFuncs.
+	legacy bool
+}
+
+// NewKeywordRegistry returns an empty KeywordRegistry. Most callers will want
+// to start from DefaultKeywordRegistry.Clone() instead.
+func NewKeywordRegistry() *KeywordRegistry {
+	return &KeywordRegistry{
+		specs:    map[Keyword]KeywordSpec{},
+		synonyms: map[Keyword]Keyword{},
+	}
+}
+
+// DefaultKeywordRegistry is the registry of all of the keywords supported by
+// this package. It is used whenever a nil registry is given.
+var DefaultKeywordRegistry = newDefaultKeywordRegistry()
+
+// Register adds spec to the registry, replacing any keyword already registered
+// with the same name. It is an error for the name or synonyms of spec to be
+// the name or synonym of a different keyword.
+func (r *KeywordRegistry) Register(spec KeywordSpec) error {
+	if spec.Name == "" {
+		return fmt.Errorf("keyword registry: keyword must have a name")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, name := range append([]Keyword{spec.Name}, spec.Synonyms...) {
+		if canon, ok := r.synonyms[name]; ok && canon != spec.Name {
+			return fmt.Errorf("keyword registry: %q is already a synonym of %q", name, canon)
+		}
+		if _, ok := r.specs[name]; ok && name != spec.Name {
+			return fmt.Errorf("keyword registry: %q is already registered", name)
+		}
+	}
+	if old, ok := r.specs[spec.Name]; ok {
+		for _, syn := range old.Synonyms {
+			delete(r.synonyms, syn)
+		}
+	}
+	spec.Synonyms = append([]Keyword(nil), spec.Synonyms...)
+	for _, syn := range spec.Synonyms {
+		if syn != spec.Name {
+			r.synonyms[syn] = spec.Name
+		}
+	}
+	r.specs[spec.Name] = spec
+	return nil
+}
+
+// Canonical returns the canonical name for the keyword name, or name itself
+// if it isn't a synonym.
+func (r *KeywordRegistry) Canonical(name string) Keyword {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.canonical(Keyword(name))
+}
+
+func (r *KeywordRegistry) canonical(name Keyword) Keyword {
+	if canon, ok := r.synonyms[name]; ok {
+		return canon
+	}
+	return name
+}
+
+// Lookup returns the KeywordSpec for kw, which may be a synonym. If kw itself
+// is not registered, then its Prefix is looked up, so that "xattr.user.foo"
+// finds the spec for "xattr".
+func (r *KeywordRegistry) Lookup(kw Keyword) (KeywordSpec, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if spec, ok := r.specs[r.canonical(kw)]; ok {
+		return spec, true
+	}
+	if spec, ok := r.specs[r.canonical(kw.Prefix())]; ok {
+		return spec, true
+	}
+	if r.legacy {
+		return legacyKeywordSpec(kw)
+	}
+	return KeywordSpec{}, false
+}
+
+// lookupSelector returns the specs of the keywords selected by selector: the
+// keyword it names, or if that isn't registered, the keywords registered with
+// a suffix which it matches (so that "exec" and "exec.*" find all of the
+// "exec.<name>" keywords).
+func (r *KeywordRegistry) lookupSelector(selector Keyword) []KeywordSpec {
+	if spec, ok := r.Lookup(selector); ok {
+		return []KeywordSpec{spec}
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var specs []KeywordSpec
+	for name, spec := range r.specs {
+		if name != name.Prefix() && MatchKeyword(selector, name) {
+			specs = append(specs, spec)
+		}
+	}
+	sort.Slice(specs, func(i, j int) bool { return specs[i].Name < specs[j].Name })
+	return specs
+}
+
+// equivalent returns whether either of the keywords a and b is one of the
+// Equivalents of the other.
+func (r *KeywordRegistry) equivalent(a, b Keyword) bool {
+	for _, pair := range [][2]Keyword{{a, b}, {b, a}} {
+		if spec, ok := r.Lookup(pair[0]); ok && slices.Contains(spec.Equivalents, pair[1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// legacyKeywordSpec returns a KeywordSpec for keywords which have been added
+// directly to KeywordFuncs or UpdateKeywordFuncs.
+func legacyKeywordSpec(kw Keyword) (KeywordSpec, bool) {
+	for _, name := range []Keyword{kw, kw.Prefix()} {
+		collect, hasCollect := KeywordFuncs[name]
+		update, hasUpdate := UpdateKeywordFuncs[name]
+		if hasCollect || hasUpdate {
+			return KeywordSpec{
+				Name:            name,
+				Collect:         collect,
+				Update:          update,
+				ConsumesContent: true,
+			}, true
+		}
+	}
+	return KeywordSpec{}, false
+}
+
+// Keywords returns the canonical names of all of the registered keywords, in
+// sorted order.
+func (r *KeywordRegistry) Keywords() []Keyword {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	kws := make([]Keyword, 0, len(r.specs))
+	for name := range r.specs {
+		kws = append(kws, name)
+	}
+	sort.Slice(kws, func(i, j int) bool { return kws[i] < kws[j] })
+	return kws
+}
+
+// Clone returns a copy of the registry, which can be modified without
+// affecting r.
+func (r *KeywordRegistry) Clone() *KeywordRegistry {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	c := NewKeywordRegistry()
+	for name, spec := range r.specs {
+		c.specs[name] = spec
+	}
+	for syn, name := range r.synonyms {
+		c.synonyms[syn] = name
+	}
+	c.legacy = r.legacy
+	return c
+}
+
+func newDefaultKeywordRegistry() *KeywordRegistry {
+	r := NewKeywordRegistry()
+	r.legacy = true
+	for _, spec := range []KeywordSpec{
+		{Name: "size", Collect: sizeKeywordFunc, Compare: compareDecimal},
+		{Name: "type", Collect: typeKeywordFunc},
+		{Name: "uid", Collect: uidKeywordFunc, Update: uidUpdateKeywordFunc, Compare: compareDecimal},
+		{Name: "gid", Collect: gidKeywordFunc, Update: gidUpdateKeywordFunc, Compare: compareDecimal},
+		{Name: "mode", Collect: modeKeywordFunc, Update: modeUpdateKeywordFunc, Compare: compareMode},
+		{Name: "link", Collect: linkKeywordFunc, Update: linkUpdateKeywordFunc, Compare: compareLink},
+		{Name: "nlink", Collect: nlinkKeywordFunc, Compare: compareDecimal},
+		{Name: "time", Collect: timeKeywordFunc, Update: timeUpdateKeywordFunc, Compare: compareTime},
+		{Name: "uname", Collect: unameResolverKeywordFunc(HostIDResolver, false), Update: unameUpdateKeywordFunc(HostIDResolver), resolvesIDs: true},
+		{Name: "gname", Collect: gnameResolverKeywordFunc(HostIDResolver, false), Update: gnameUpdateKeywordFunc(HostIDResolver), resolvesIDs: true},
+		{Name: "flags", Collect: flagsKeywordFunc, Update: flagsUpdateKeywordFunc, Compare: compareFlags},
+		{Name: "cksum", Collect: cksumKeywordFunc, Compare: compareDecimal, ConsumesContent: true},
+		{Name: "md5digest", Synonyms: []Keyword{"md5"}, Collect: hasherKeywordFunc("md5digest", md5.New), Compare: compareDigest, ConsumesContent: true},
+		{Name: "ripemd160digest", Synonyms: []Keyword{"rmd160", "rmd160digest"}, Collect: hasherKeywordFunc("ripemd160digest", ripemd160.New), Compare: compareDigest, ConsumesContent: true},
+		{Name: "sha1digest", Synonyms: []Keyword{"sha1"}, Collect: hasherKeywordFunc("sha1digest", sha1.New), Compare: compareDigest, ConsumesContent: true},
+		{Name: "sha256digest", Synonyms: []Keyword{"sha256"}, Collect: hasherKeywordFunc("sha256digest", sha256.New), Compare: compareDigest, ConsumesContent: true},
+		{Name: "sha384digest", Synonyms: []Keyword{"sha384"}, Collect: hasherKeywordFunc("sha384digest", sha512.New384), Compare: compareDigest, ConsumesContent: true},
+		{Name: "sha512digest", Synonyms: []Keyword{"sha512"}, Collect: hasherKeywordFunc("sha512digest", sha512.New), Compare: compareDigest, ConsumesContent: true},
+		{Name: "sha512256digest", Synonyms: []Keyword{"sha512256"}, Collect: hasherKeywordFunc("sha512digest", sha512.New512_256), Compare: compareDigest, ConsumesContent: true},
+		{Name: "sha3_256digest", Synonyms: []Keyword{"sha3_256"}, Collect: hasherKeywordFunc("sha3_256digest", newSHA3_256), Compare: compareDigest, ConsumesContent: true},
+		{Name: "sha3_512digest", Synonyms: []Keyword{"sha3_512"}, Collect: hasherKeywordFunc("sha3_512digest", newSHA3_512), Compare: compareDigest, ConsumesContent: true},
+		{Name: "blake2b256digest", Synonyms: []Keyword{"blake2b256"}, Collect: hasherKeywordFunc("blake2b256digest", newBLAKE2b256), Compare: compareDigest, ConsumesContent: true},
+		{Name: "blake2b512digest", Synonyms: []Keyword{"blake2b512"}, Collect: hasherKeywordFunc("blake2b512digest", newBLAKE2b512), Compare: compareDigest, ConsumesContent: true},
+		{Name: "tar_time", Equivalents: []Keyword{"time"}, Collect: tartimeKeywordFunc, Update: tartimeUpdateKeywordFunc, Compare: compareTarTime},
+		{Name: "btime", Collect: btimeKeywordFunc, Compare: compareTime, Volatile: true},
+		{Name: "ctime", Collect: ctimeKeywordFunc, Compare: compareTime, Volatile: true},
+		{Name: "atime", Collect: atimeKeywordFunc, Update: atimeUpdateKeywordFunc, Compare: compareTime},
+		{Name: "mntid", Collect: mntidKeywordFunc, Compare: compareDecimal},
+		{Name: "acl", Collect: aclKeywordFunc("acl", aclAccessXattr), Update: aclUpdateKeywordFunc(aclAccessXattr), Compare: compareACL},
+		{Name: "default_acl", Collect: aclKeywordFunc("default_acl", aclDefaultXattr), Update: aclUpdateKeywordFunc(aclDefaultXattr), Compare: compareACL},
+		{Name: "caps", Collect: capsKeywordFunc, Update: capsUpdateKeywordFunc, Compare: compareCaps},
+		{Name: "blocks", Collect: blocksKeywordFunc, Compare: compareBlocks},
+		{Name: "sparse", Collect: sparseKeywordFunc, Compare: compareSparse},
+		{Name: "elf.buildid", Collect: elfKeywordFunc, ConsumesContent: true},
+		{Name: "ima", Collect: imaKeywordFunc, Compare: compareIMA, ConsumesContent: true},
+		{Name: "fsverity", Collect: fsverityKeywordFunc, Compare: compareFsverity, ConsumesContent: true},
+		{Name: "dirdigest", Collect: dirKeywordFunc, Compare: compareDigest},
+		{Name: "dirhash", Collect: dirKeywordFunc},
+		{Name: "xattr", Synonyms: []Keyword{"xattrs"}, Collect: xattrKeywordFunc, Update: xattrUpdateKeywordFunc},
+	} {
+		spec.IsDefault = InKeywordSlice(spec.Name, DefaultKeywords)
+		spec.IsBsd = InKeywordSlice(spec.Name, BsdKeywords)
+		if err := r.Register(spec); err != nil {
+			panic(err)
+		}
+	}
+	return r
+}
@@ -0,0 +1,182 @@
+package mtree
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeywordRegistryLookup(t *testing.T) {
+	r := DefaultKeywordRegistry
+
+	for _, test := range []struct {
+		give   Keyword
+		expect Keyword
+	}{
+		{"size", "size"},
+		{"sha1", "sha1digest"},
+		{"rmd160", "ripemd160digest"},
+		{"xattrs", "xattr"},
+		{"xattr.user.foo", "xattr"},
+	} {
+		t.Run(string(test.give), func(t *testing.T) {
+			spec, ok := r.Lookup(test.give)
+			require.True(t, ok)
+			assert.Equal(t, test.expect, spec.Name)
+		})
+	}
+
+	_, ok := r.Lookup("no-such-keyword")
+	assert.False(t, ok)
+
+	spec, _ := r.Lookup("sha256")
+	assert.True(t, spec.IsBsd)
+	assert.False(t, spec.IsDefault)
+	assert.True(t, spec.ConsumesContent)
+	spec, _ = r.Lookup("uid")
+	assert.True(t, spec.IsDefault)
+	assert.False(t, spec.ConsumesContent)
+	assert.NotNil(t, spec.Update)
+
+	assert.Contains(t, r.Keywords(), Keyword("sha512256digest"))
+	assert.NotContains(t, r.Keywords(), Keyword("sha512256"))
+}
+
+func TestKeywordRegistryRegister(t *testing.T) {
+	r := DefaultKeywordRegistry.Clone()
+	require.NoError(t, r.Register(KeywordSpec{Name: "lines", Synonyms: []Keyword{"nlines"}}))
+	assert.Equal(t, Keyword("lines"), r.Canonical("nlines"))
+
+	// The clone must not affect the default registry.
+	_, ok := DefaultKeywordRegistry.Lookup("lines")
+	assert.False(t, ok)
+	assert.Equal(t, Keyword("nlines"), KeywordSynonym("nlines"))
+
+	// Names and synonyms cannot be reused by another keyword.
+	assert.Error(t, r.Register(KeywordSpec{Name: "other", Synonyms: []Keyword{"sha1"}}))
+	assert.Error(t, r.Register(KeywordSpec{Name: "other", Synonyms: []Keyword{"size"}}))
+	assert.Error(t, r.Register(KeywordSpec{Name: "sha1"}))
+	assert.Error(t, r.Register(KeywordSpec{}))
+
+	// Re-registering replaces the old spec and its synonyms.
+	require.NoError(t, r.Register(KeywordSpec{Name: "lines", Synonyms: []Keyword{"linecount"}}))
+	assert.Equal(t, Keyword("nlines"), r.Canonical("nlines"))
+	assert.Equal(t, Keyword("lines"), r.Canonical("linecount"))
+}
+
+func TestKeywordRegistryCustomKeyword(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "file"), []byte("a\nb\n"), 0644))
+
+	var updated []string
+	r := DefaultKeywordRegistry.Clone()
+	require.NoError(t, r.Register(KeywordSpec{
+		Name: "lines",
+		Collect: func(path string, info os.FileInfo, rd io.Reader) ([]KeyVal, error) {
+			if rd == nil {
+				return nil, nil
+			}
+			buf, err := io.ReadAll(rd)
+			if err != nil {
+				return nil, err
+			}
+			return []KeyVal{KeyVal(fmt.Sprintf("lines=%d", strings.Count(string(buf), "\n")))}, nil
+		},
+		Update: func(path string, kv KeyVal) (os.FileInfo, error) {
+			updated = append(updated, path)
+			return os.Lstat(path)
+		},
+		// Only treat the line count as changed if it has grown.
+		Compare: func(old, new KeyVal, opts *CompareOptions) bool {
+			return new.Value() <= old.Value()
+		},
+		ConsumesContent: true,
+	}))
+
+	keywords := []Keyword{"type", "lines"}
+	_, err := Walk(dir, nil, keywords, nil)
+	assert.Error(t, err, "lines is not in the default registry")
+
+	dh, err := WalkWithOptions(dir, &WalkOptions{Keywords: keywords, Registry: r})
+	require.NoError(t, err)
+	var found bool
+	for _, e := range dh.Entries {
+		if e.Name == "file" {
+			assert.Equal(t, []KeyVal{"lines=2"}, HasKeyword(e.Keywords, "lines"))
+			found = true
+		}
+	}
+	require.True(t, found, "file entry should be present")
+
+	// So does the tar streamer.
+	tdh := tarHierarchy(t, &TarStreamerOptions{Keywords: keywords, Registry: r}, tarFile{
+		Header: tar.Header{Name: "file", Mode: 0644, Typeflag: tar.TypeReg},
+		Data:   []byte("a\nb\n"),
+	})
+	found = false
+	for _, e := range tdh.Entries {
+		if e.Name == "file" {
+			assert.Equal(t, []KeyVal{"lines=2"}, HasKeyword(e.Keywords, "lines"))
+			found = true
+		}
+	}
+	require.True(t, found, "file entry should be present in the tar hierarchy")
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "file"), []byte("a\n"), 0644))
+	res, err := CheckWithOptions(dir, dh, &CheckOptions{Registry: r})
+	require.NoError(t, err)
+	assert.Empty(t, res, "shrinking is not a change for lines")
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "file"), []byte("a\nb\nc\n"), 0644))
+	res, err = CheckWithOptions(dir, dh, &CheckOptions{Registry: r})
+	require.NoError(t, err)
+	if assert.Len(t, res, 1) {
+		assert.Equal(t, "file", res[0].Path())
+	}
+
+	res, err = UpdateWithOptions(dir, dh, &UpdateOptions{Keywords: []Keyword{"lines"}, Registry: r})
+	require.NoError(t, err)
+	assert.Empty(t, res)
+	assert.Equal(t, []string{"file"}, updated)
+}
+
+func TestKeywordsModernDigests(t *testing.T) {
+	expected := map[Keyword]string{
+		"sha3_256digest":   "3338be694f50c5f338814986cdf0686453a888b84f424d792af4b9202398f392",
+		"sha3_512digest":   "75d527c368f2efe848ecf6b073a36767800805e9eef2b1857d5f984f036eb6df891d75f72d9b154518c1cd58835286d1da9a38deba3de98b5a53e5ed78a84976",
+		"blake2b256digest": "324dcf027dd4a30a932c441f365a25e86b173defa4b8e58948253471b81b72cf",
+		"blake2b512digest": "e4cfa39a3d37be31c59609e807970799caa68a19bfaa15135f165085e01d41a65ba1e1b146aeb6bd0092b49eac214c103ccfa3a365954bbbe52f74a2b3620c94",
+	}
+	keywords := []Keyword{"type", "sha3_256", "sha3_512digest", "blake2b256", "blake2b512digest"}
+
+	for kw := range expected {
+		assert.False(t, kw.Bsd(), "%s is not an upstream keyword", kw)
+	}
+
+	dhs := walkAndTarHierarchies(t, nil, keywords, map[string][]byte{"file": []byte("hello")})
+	for name, dh := range dhs {
+		t.Run(name, func(t *testing.T) {
+			found := false
+			for _, e := range dh.Entries {
+				if e.Name != "file" {
+					continue
+				}
+				found = true
+				for kw, digest := range expected {
+					kvs := HasKeyword(e.Keywords, kw)
+					if assert.Len(t, kvs, 1, kw) {
+						assert.Equal(t, digest, kvs[0].Value(), kw)
+					}
+				}
+			}
+			assert.True(t, found, "file entry should be present")
+		})
+	}
+}
@@ -2,6 +2,7 @@ package mtree
 
 import (
 	"fmt"
+	"path"
 	"strings"
 
 	"github.com/vbatts/go-mtree/pkg/govis"
@@ -37,13 +38,18 @@ func (k Keyword) Suffix() string {
 	return string(k)
 }
 
-// Default returns whether this keyword is in the default set of keywords
+// Default returns whether this keyword is in the default set of keywords, as
+// registered in DefaultKeywordRegistry.
 func (k Keyword) Default() bool {
-	return InKeywordSlice(k, DefaultKeywords)
+	spec, ok := DefaultKeywordRegistry.Lookup(k)
+	return ok && spec.IsDefault
 }
 
 // Bsd returns whether this keyword is in the upstream FreeBSD mtree(8)
 func (k Keyword) Bsd() bool {
+	if spec, ok := DefaultKeywordRegistry.Lookup(k); ok && spec.IsBsd {
+		return true
+	}
 	return InKeywordSlice(k, BsdKeywords)
 }
 
@@ -62,6 +68,54 @@ func InKeywordSlice(a Keyword, list []Keyword) bool {
 	}
 	return false
 }
+
+// MatchKeyword reports whether the keyword kw is selected by selector. A
+// selector without a "." (such as "xattr") selects every keyword with that
+// prefix, while one with a "." selects a single keyword (such as
+// "xattr.user.foo") or, using the wildcards of path.Match, a namespace of them
+// (such as "xattr.user.*"). Synonyms (such as "sha1" for "sha1digest") match
+// each other.
+func MatchKeyword(selector, kw Keyword) bool {
+	selector, kw = canonicalKeyword(selector), canonicalKeyword(kw)
+	if selector == kw {
+		return true
+	}
+	if !strings.Contains(string(selector), ".") {
+		return selector == kw.Prefix()
+	}
+	matched, err := path.Match(string(selector), string(kw))
+	return err == nil && matched
+}
+
+// canonicalKeyword returns kw with its prefix replaced by the canonical name,
+// so that "xattrs.user.foo" becomes "xattr.user.foo".
+func canonicalKeyword(kw Keyword) Keyword {
+	prefix, suffix, ok := strings.Cut(string(kw), ".")
+	if !ok {
+		return KeywordSynonym(prefix)
+	}
+	return KeywordSynonym(prefix) + "." + Keyword(suffix)
+}
+
+// MatchKeywords reports whether the keyword kw is selected by selectors, a
+// list of keyword selectors as taken by MatchKeyword. A selector starting with
+// "!" deselects the keywords it matches, regardless of the other selectors, so
+// that "xattr,!xattr.security.*" selects all but the security extended
+// attributes.
+func MatchKeywords(selectors []Keyword, kw Keyword) bool {
+	matched := false
+	for _, selector := range selectors {
+		if negated, ok := strings.CutPrefix(string(selector), "!"); ok {
+			if MatchKeyword(Keyword(negated), kw) {
+				return false
+			}
+		} else if MatchKeyword(selector, kw) {
+			matched = true
+		}
+	}
+	return matched
+}
+
 func inKeyValSlice(a KeyVal, list []KeyVal) bool {
 	for _, b := range list {
 		if b == a {
@@ -131,13 +185,19 @@ func (kv KeyVal) NewValue(newval string) KeyVal {
 	return KeyVal(fmt.Sprintf("%s=%s", kv.Keyword(), newval))
 }
 
-// Equal returns whether two KeyVal are equivalent. This takes
-// care of certain odd cases such as tar_mtime, and should be used over
-// using == comparisons directly unless you really know what you're
-// doing.
+// Equal returns whether two KeyVal are equivalent. The values are compared
+// using the KeywordCompareFunc registered for the keyword in
+// DefaultKeywordRegistry (so that "mode=0755" and "mode=755" are equal), and
+// this should be used over using == comparisons directly unless you really
+// know what you're doing.
 func (kv KeyVal) Equal(b KeyVal) bool {
-	// TODO: Implement handling of tar_mtime.
-	return kv.Keyword() == b.Keyword() && kv.Value() == b.Value()
+	if kv.Keyword() != b.Keyword() {
+		return false
+	}
+	if spec, ok := DefaultKeywordRegistry.Lookup(kv.Keyword()); ok && spec.Compare != nil {
+		return spec.Compare(kv, b, &CompareOptions{})
+	}
+	return kv.Value() == b.Value()
 }
 
 func keywordPrefixes(kvset []Keyword) []Keyword {
@@ -148,12 +208,20 @@ func keywordPrefixes(kvset []Keyword) []Keyword {
 	return kvs
 }
 
+// suffixSelected reports whether kw, if it has a suffix (such as
+// "xattr.user.foo"), is selected by keyset with MatchKeywords, so that keyset
+// can pick out namespaces of such keywords. Keywords without a suffix are
+// always selected.
+func suffixSelected(kw Keyword, keyset []Keyword) bool {
+	return kw == kw.Prefix() || MatchKeywords(keyset, kw)
+}
+
 // keyvalSelector takes an array of KeyVal ("keyword=value") and filters out
 // that only the set of keywords
 func keyvalSelector(keyval []KeyVal, keyset []Keyword) []KeyVal {
 	retList := []KeyVal{}
 	for _, kv := range keyval {
-		if InKeywordSlice(kv.Keyword().Prefix(), keywordPrefixes(keyset)) {
+		if InKeywordSlice(kv.Keyword().Prefix(), keywordPrefixes(keyset)) && suffixSelected(kv.Keyword(), keyset) {
 			retList = append(retList, kv)
 		}
 	}
@@ -296,30 +364,8 @@ var (
 
 // KeywordSynonym returns the canonical name for keywords that have synonyms,
 // and just returns the name provided if there is no synonym. In this way it
-// ought to be safe to wrap any keyword name.
+// ought to be safe to wrap any keyword name. The synonyms are those registered
+// in DefaultKeywordRegistry.
 func KeywordSynonym(name string) Keyword {
-	var retname string
-	switch name {
-	case "md5":
-		retname = "md5digest"
-	case "rmd160":
-		retname = "ripemd160digest"
-	case "rmd160digest":
-		retname = "ripemd160digest"
-	case "sha1":
-		retname = "sha1digest"
-	case "sha256":
-		retname = "sha256digest"
-	case "sha384":
-		retname = "sha384digest"
-	case "sha512":
-		retname = "sha512digest"
-	case "sha512256":
-		retname = "sha512256digest"
-	case "xattrs":
-		retname = "xattr"
-	default:
-		retname = name
-	}
-	return Keyword(retname)
+	return DefaultKeywordRegistry.Canonical(name)
 }
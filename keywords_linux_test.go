@@ -6,6 +6,7 @@ package mtree
 import (
 	"os"
 	"path/filepath"
+	"sort"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -69,3 +70,92 @@ func TestXattr(t *testing.T) {
 	_, err = xattrKeywordFunc(filepath.Join(dir, "symlink"), linkstat, nil)
 	require.NoError(t, err, "xattr keyword fn broken symlink")
 }
+
+func TestXattrNamespaces(t *testing.T) {
+	testDir, present := os.LookupEnv("MTREE_TESTDIR")
+	if present == false {
+		testDir = "."
+	}
+	dir, err := os.MkdirTemp(testDir, "test.xattrs.")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "file")
+	require.NoError(t, os.WriteFile(file, []byte("howdy"), 0o644))
+	link := filepath.Join(dir, "symlink")
+	require.NoError(t, os.Symlink("file", link))
+
+	if err := xattr.Set(file, "user.test", []byte("user")); err != nil {
+		t.Skipf("skipping: %q does not support xattrs", dir)
+	}
+	// Only privileged namespaces can be set on symlinks.
+	if err := xattr.Set(file, "trusted.test", []byte("trusted")); err != nil {
+		t.Skipf("skipping: cannot set trusted xattrs on %q: %v", file, err)
+	}
+	require.NoError(t, xattr.Lset(link, "trusted.test", []byte("symlink")))
+
+	for _, test := range []struct {
+		name     string
+		keywords []Keyword
+		file     []KeyVal
+		link     []KeyVal
+	}{
+		{
+			name:     "All",
+			keywords: []Keyword{"xattr"},
+			file:     []KeyVal{"xattr.trusted.test=dHJ1c3RlZA==", "xattr.user.test=dXNlcg=="},
+			link:     []KeyVal{"xattr.trusted.test=c3ltbGluaw=="},
+		},
+		{
+			name:     "Glob",
+			keywords: []Keyword{"xattr.user.*"},
+			file:     []KeyVal{"xattr.user.test=dXNlcg=="},
+		},
+		{
+			name:     "Negated",
+			keywords: []Keyword{"xattr", "!xattr.trusted.*"},
+			file:     []KeyVal{"xattr.user.test=dXNlcg=="},
+		},
+		{
+			name:     "Multiple",
+			keywords: []Keyword{"xattr.user.*", "xattr.trusted.test"},
+			file:     []KeyVal{"xattr.trusted.test=dHJ1c3RlZA==", "xattr.user.test=dXNlcg=="},
+			link:     []KeyVal{"xattr.trusted.test=c3ltbGluaw=="},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			dh, err := Walk(dir, nil, test.keywords, nil)
+			require.NoError(t, err, "walk")
+
+			got := map[string][]KeyVal{}
+			for _, e := range dh.Entries {
+				if e.Type != RelativeType {
+					continue
+				}
+				// The /set keywords are not of interest.
+				kvs := e.Keywords
+				sort.Slice(kvs, func(i, j int) bool { return kvs[i] < kvs[j] })
+				got[e.Name] = kvs
+			}
+			assert.Equal(t, test.file, got["file"], "file keywords")
+			assert.Equal(t, test.link, got["symlink"], "symlink keywords")
+
+			// The walked manifest checks cleanly with the same selectors.
+			res, err := Check(dir, dh, test.keywords, nil)
+			require.NoError(t, err, "check")
+			if !assert.Empty(t, res) {
+				pprintInodeDeltas(t, res)
+			}
+		})
+	}
+
+	// Changes outside the selected namespace are ignored.
+	dh, err := Walk(dir, nil, []Keyword{"xattr.user.*"}, nil)
+	require.NoError(t, err, "walk")
+	require.NoError(t, xattr.Set(file, "trusted.test", []byte("changed")))
+	res, err := Check(dir, dh, []Keyword{"xattr.user.*"}, nil)
+	require.NoError(t, err, "check")
+	if !assert.Empty(t, res) {
+		pprintInodeDeltas(t, res)
+	}
+}
@@ -144,6 +144,12 @@ func TestKeywordSynonym(t *testing.T) {
 		{give: "sha384digest", expect: "sha384digest"},
 		{give: "sha512", expect: "sha512digest"},
 		{give: "sha512digest", expect: "sha512digest"},
+		{give: "sha3_256", expect: "sha3_256digest"},
+		{give: "sha3_256digest", expect: "sha3_256digest"},
+		{give: "sha3_512", expect: "sha3_512digest"},
+		{give: "blake2b256", expect: "blake2b256digest"},
+		{give: "blake2b512", expect: "blake2b512digest"},
+		{give: "blake2b512digest", expect: "blake2b512digest"},
 		{give: "xattr", expect: "xattr"},
 		{give: "xattrs", expect: "xattr"},
 	} {
@@ -153,3 +159,30 @@ func TestKeywordSynonym(t *testing.T) {
 		})
 	}
 }
+
+func TestMatchKeywords(t *testing.T) {
+	for _, test := range []struct {
+		selectors []Keyword
+		kw        Keyword
+		expect    bool
+	}{
+		{selectors: []Keyword{"xattr"}, kw: "xattr.user.foo", expect: true},
+		{selectors: []Keyword{"xattrs"}, kw: "xattr.user.foo", expect: true},
+		{selectors: []Keyword{"xattr.user.foo"}, kw: "xattr.user.foo", expect: true},
+		{selectors: []Keyword{"xattr.user.foo"}, kw: "xattr.user.bar", expect: false},
+		{selectors: []Keyword{"xattr.user.*"}, kw: "xattr.user.foo", expect: true},
+		{selectors: []Keyword{"xattr.user.*"}, kw: "xattr.user.foo.bar", expect: true},
+		{selectors: []Keyword{"xattr.user.*"}, kw: "xattr.security.selinux", expect: false},
+		{selectors: []Keyword{"xattr.user.*", "xattr.trusted.*"}, kw: "xattr.trusted.foo", expect: true},
+		{selectors: []Keyword{"xattr", "!xattr.security.*"}, kw: "xattr.user.foo", expect: true},
+		{selectors: []Keyword{"xattr", "!xattr.security.*"}, kw: "xattr.security.selinux", expect: false},
+		{selectors: []Keyword{"!xattr.security.*"}, kw: "xattr.user.foo", expect: false},
+		{selectors: []Keyword{"sha1"}, kw: "sha1digest", expect: true},
+		{selectors: []Keyword{"type", "mode"}, kw: "size", expect: false},
+	} {
+		t.Run(fmt.Sprintf("%v/%s", test.selectors, test.kw), func(t *testing.T) {
+			got := MatchKeywords(test.selectors, test.kw)
+			assert.Equal(t, test.expect, got)
+		})
+	}
+}
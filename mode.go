@@ -0,0 +1,200 @@
+package mtree
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// modeSpecial is the set of os.FileMode bits which are stored in the "mode"
+// keyword, as well as the permission bits.
+const modeSpecial = os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+// ParseMode parses the value of a "mode" keyword, which can either be an octal
+// number (such as "0755" or "4755") or a chmod(1) style symbolic mode (such as
+// "u=rwx,go=rx" or "a=rx,u+ws"). Symbolic modes are applied to an initial
+// mode of 0, and no umask is applied when the user classes are omitted (so "+x"
+// is the same as "a+x"). The returned mode only has the permission bits and
+// os.ModeSetuid, os.ModeSetgid and os.ModeSticky set.
+func ParseMode(s string) (os.FileMode, error) {
+	if s == "" {
+		return 0, fmt.Errorf("invalid mode: empty")
+	}
+	if s[0] >= '0' && s[0] <= '7' {
+		v, err := strconv.ParseUint(s, 8, 32)
+		if err != nil || v&^0o7777 != 0 {
+			return 0, fmt.Errorf("invalid mode %q", s)
+		}
+		return unixToFileMode(uint32(v)), nil
+	}
+	v, err := parseSymbolicMode(s)
+	if err != nil {
+		return 0, err
+	}
+	return unixToFileMode(v), nil
+}
+
+// unixToFileMode converts the permission and special bits of a stat(2) mode to
+// an os.FileMode.
+func unixToFileMode(v uint32) os.FileMode {
+	mode := os.FileMode(v & 0o777)
+	if v&0o4000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if v&0o2000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if v&0o1000 != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode
+}
+
+// fileModeToUnix converts the permission and special bits of an os.FileMode
+// to a stat(2) mode.
+func fileModeToUnix(mode os.FileMode) uint32 {
+	v := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		v |= 0o4000
+	}
+	if mode&os.ModeSetgid != 0 {
+		v |= 0o2000
+	}
+	if mode&os.ModeSticky != 0 {
+		v |= 0o1000
+	}
+	return v
+}
+
+// parseSymbolicMode applies the chmod(1) symbolic mode s to a mode of 0.
+func parseSymbolicMode(s string) (uint32, error) {
+	const (
+		userBits  = 0o4700
+		groupBits = 0o2070
+		otherBits = 0o1007
+	)
+	var mode uint32
+	for _, clause := range strings.Split(s, ",") {
+		var who uint32
+		i := 0
+	who:
+		for ; i < len(clause); i++ {
+			switch clause[i] {
+			case 'u':
+				who |= userBits
+			case 'g':
+				who |= groupBits
+			case 'o':
+				who |= otherBits
+			case 'a':
+				who |= userBits | groupBits | otherBits
+			default:
+				break who
+			}
+		}
+		if who == 0 {
+			who = userBits | groupBits | otherBits
+		}
+		if i == len(clause) {
+			return 0, fmt.Errorf("invalid mode %q: missing operator in %q", s, clause)
+		}
+		for i < len(clause) {
+			op := clause[i]
+			if op != '+' && op != '-' && op != '=' {
+				return 0, fmt.Errorf("invalid mode %q: unexpected %q in %q", s, op, clause)
+			}
+			i++
+
+			var perm uint32
+			switch {
+			case i < len(clause) && strings.IndexByte("ugo", clause[i]) >= 0:
+				// Copy the permissions of another class, such as "g=u".
+				var rwx uint32
+				switch clause[i] {
+				case 'u':
+					rwx = (mode >> 6) & 0o7
+				case 'g':
+					rwx = (mode >> 3) & 0o7
+				case 'o':
+					rwx = mode & 0o7
+				}
+				perm = rwx<<6 | rwx<<3 | rwx
+				i++
+			default:
+				for ; i < len(clause) && strings.IndexByte("rwxXst", clause[i]) >= 0; i++ {
+					switch clause[i] {
+					case 'r':
+						perm |= 0o444
+					case 'w':
+						perm |= 0o222
+					case 'x':
+						perm |= 0o111
+					case 'X':
+						// Without knowing the file type, this is only
+						// executable if it already is for someone.
+						if mode&0o111 != 0 {
+							perm |= 0o111
+						}
+					case 's':
+						perm |= 0o6000
+					case 't':
+						perm |= 0o1000
+					}
+				}
+			}
+			perm &= who
+
+			switch op {
+			case '+':
+				mode |= perm
+			case '-':
+				mode &^= perm
+			case '=':
+				mode = mode&^(who&0o777) | perm
+				// Only clear the special bits which could have been set by
+				// this clause's classes.
+				mode &^= who & 0o7000 &^ perm
+			}
+		}
+	}
+	return mode, nil
+}
+
+// formatSymbolicMode returns mode in the chmod(1) symbolic form accepted by
+// ParseMode, such as "u=rwx,g=rx,o=rx".
+func formatSymbolicMode(mode os.FileMode) string {
+	v := fileModeToUnix(mode)
+	class := func(name string, shift uint, special uint32, specialChar byte) string {
+		b := []byte(name + "=")
+		rwx := (v >> shift) & 0o7
+		if rwx&0o4 != 0 {
+			b = append(b, 'r')
+		}
+		if rwx&0o2 != 0 {
+			b = append(b, 'w')
+		}
+		if rwx&0o1 != 0 {
+			b = append(b, 'x')
+		}
+		if v&special != 0 {
+			b = append(b, specialChar)
+		}
+		return string(b)
+	}
+	return strings.Join([]string{
+		class("u", 6, 0o4000, 's'),
+		class("g", 3, 0o2000, 's'),
+		class("o", 0, 0o1000, 't'),
+	}, ",")
+}
+
+// compareMode compares "mode" values, which may be octal or symbolic.
+func compareMode(old, new KeyVal, opts *CompareOptions) bool {
+	a, errA := ParseMode(old.Value())
+	b, errB := ParseMode(new.Value())
+	if errA != nil || errB != nil {
+		return old.Value() == new.Value()
+	}
+	return a == b
+}
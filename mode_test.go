@@ -0,0 +1,115 @@
+package mtree
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMode(t *testing.T) {
+	for _, test := range []struct {
+		give   string
+		expect uint32
+	}{
+		{"0755", 0o755},
+		{"755", 0o755},
+		{"4755", 0o4755},
+		{"01777", 0o1777},
+		{"u=rwx,go=rx", 0o755},
+		{"u=rw,g=r,o=r", 0o644},
+		{"a=r,u+w", 0o644},
+		{"=rx,u+w", 0o755},
+		{"+x", 0o111},
+		{"u=rwxs,go=rx", 0o4755},
+		{"u=rwx,g=rxs,o=", 0o2750},
+		{"a=rwx,o+t", 0o1777},
+		{"a=rwxt", 0o1777},
+		{"u=rwx,g=u,o=g-w", 0o775},
+		{"u=rwx,g=u-w,o=g", 0o755},
+		{"a=rwx,go-w", 0o755},
+		{"u=rws,u=rw", 0o600},
+		{"a=rX", 0o444},
+		{"u=x,a+rX", 0o555},
+		{"u=", 0},
+	} {
+		t.Run(test.give, func(t *testing.T) {
+			mode, err := ParseMode(test.give)
+			require.NoError(t, err)
+			assert.Equalf(t, test.expect, fileModeToUnix(mode), "got %o", fileModeToUnix(mode))
+		})
+	}
+
+	for _, bad := range []string{"", "0999", "77777", "u", "u=z", "x=r", "rwx", "u=r,", "0755,u=r"} {
+		_, err := ParseMode(bad)
+		assert.Errorf(t, err, "parse %q", bad)
+	}
+}
+
+func TestFormatSymbolicMode(t *testing.T) {
+	for _, v := range []uint32{0, 0o644, 0o755, 0o4755, 0o2750, 0o1777, 0o7000} {
+		str := formatSymbolicMode(unixToFileMode(v))
+		mode, err := ParseMode(str)
+		require.NoErrorf(t, err, "parse %q", str)
+		assert.Equalf(t, v, fileModeToUnix(mode), "round-trip of %o via %q", v, str)
+	}
+	assert.Equal(t, "u=rwx,g=rx,o=rx", formatSymbolicMode(0o755))
+	assert.Equal(t, "u=rwxs,g=rx,o=rxt", formatSymbolicMode(unixToFileMode(0o5755)))
+}
+
+func TestWriteSymbolicModes(t *testing.T) {
+	dh, err := ParseSpec(strings.NewReader(`
+/set type=file mode=0644
+.               type=dir mode=0755
+    file        mode=4755
+..
+`))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	_, err = dh.WriteToWithOptions(&buf, &WriteOptions{SymbolicModes: true})
+	require.NoError(t, err)
+	assert.Contains(t, buf.String(), "/set type=file mode=u=rw,g=r,o=r")
+	assert.Contains(t, buf.String(), ". type=dir mode=u=rwx,g=rx,o=rx")
+	assert.Contains(t, buf.String(), "file mode=u=rwxs,g=rx,o=rx")
+	assert.NotContains(t, buf.String(), "0755")
+
+	// The symbolic manifest is equivalent to the original.
+	symDh, err := ParseSpec(&buf)
+	require.NoError(t, err)
+	res, err := Compare(dh, symDh, nil)
+	require.NoError(t, err)
+	if !assert.Empty(t, res) {
+		pprintInodeDeltas(t, res)
+	}
+
+	// The original hierarchy is unchanged.
+	buf.Reset()
+	_, err = dh.WriteTo(&buf)
+	require.NoError(t, err)
+	assert.Contains(t, buf.String(), "mode=4755")
+}
+
+func TestUpdateSymbolicMode(t *testing.T) {
+	dir := t.TempDir()
+	fn := filepath.Join(dir, "file")
+	require.NoError(t, os.WriteFile(fn, []byte("data"), 0600))
+
+	for _, test := range []struct {
+		value  string
+		expect os.FileMode
+	}{
+		{"u=rwx,go=rx", 0o755},
+		{"2750", 0o750 | os.ModeSetgid},
+		{"u=rwxs,go=rx", 0o755 | os.ModeSetuid},
+		{"0644", 0o644},
+	} {
+		info, err := modeUpdateKeywordFunc(fn, KeyVal("mode="+test.value))
+		require.NoErrorf(t, err, "update mode=%s", test.value)
+		assert.Equalf(t, test.expect, info.Mode()&(os.ModePerm|modeSpecial), "update mode=%s", test.value)
+	}
+}
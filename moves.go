@@ -0,0 +1,240 @@
+package mtree
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+)
+
+// moveDigestKeywords are the keywords whose values identify the contents of a
+// file, in the order in which they are used to pair moved paths.
+var moveDigestKeywords = []Keyword{
+	"sha512digest",
+	"sha384digest",
+	"sha256digest",
+	"sha512256digest",
+	"sha3_512digest",
+	"sha3_256digest",
+	"blake2b512digest",
+	"blake2b256digest",
+	"fsverity",
+	"sha1digest",
+	"ripemd160digest",
+	"md5digest",
+}
+
+// moveIdentity returns what identifies e as the same file at another path:
+// the first of its moveDigestKeywords, or otherwise its type, size and link
+// target. Directories are only identified by their type, as their size
+// depends on the filesystem, so they are only paired by their subtrees.
+func moveIdentity(e Entry) string {
+	keys := map[Keyword]string{}
+	for _, kv := range e.AllKeys() {
+		keys[KeywordSynonym(string(kv.Keyword()))] = kv.Value()
+	}
+	if keys["type"] == "dir" {
+		return "type=dir"
+	}
+	for _, kw := range moveDigestKeywords {
+		if v, ok := keys[kw]; ok {
+			if sum, err := decodeDigest(v); err == nil {
+				v = hex.EncodeToString(sum)
+			}
+			return string(kw) + "=" + v
+		}
+	}
+	return fmt.Sprintf("type=%s size=%s link=%s", keys["type"], keys["size"], keys["link"])
+}
+
+// moveSide holds the Missing or the Extra deltas of a comparison, for pairing
+// them with each other.
+type moveSide struct {
+	deltas map[string]InodeDelta
+	// subtrees are the identities of the directories among deltas, which
+	// cover the relative paths and identities of everything beneath them.
+	subtrees map[string]string
+}
+
+func newMoveSide(deltas []InodeDelta, diff DifferenceType) moveSide {
+	side := moveSide{deltas: map[string]InodeDelta{}, subtrees: map[string]string{}}
+	entry := func(d InodeDelta) Entry {
+		if diff == Missing {
+			return d.old
+		}
+		return d.new
+	}
+	for _, d := range deltas {
+		if d.diff == diff {
+			side.deltas[d.path] = d
+		}
+	}
+
+	lines := map[string][]string{}
+	for p, d := range side.deltas {
+		identity := moveIdentity(entry(d))
+		for dir := p; ; dir = path.Dir(dir) {
+			if parent, ok := side.deltas[dir]; ok && entry(parent).IsDir() {
+				rel := strings.TrimPrefix(strings.TrimPrefix(p, dir), "/")
+				lines[dir] = append(lines[dir], rel+"\x00"+identity)
+			}
+			if dir == "." || dir == "/" {
+				break
+			}
+		}
+	}
+	for dir, l := range lines {
+		slices.Sort(l)
+		sum := sha256.Sum256([]byte(strings.Join(l, "\n")))
+		side.subtrees[dir] = hex.EncodeToString(sum[:])
+	}
+	return side
+}
+
+// sortedPaths returns the paths of the deltas of side, with directories
+// before their contents.
+func (side moveSide) sortedPaths() []string {
+	paths := make([]string, 0, len(side.deltas))
+	for p := range side.deltas {
+		paths = append(paths, p)
+	}
+	slices.Sort(paths)
+	return paths
+}
+
+// uniquePairs returns the keys of old which map to a value that only one
+// key of old and one key of new map to, with the key of new that it pairs
+// with.
+func uniquePairs(old, new map[string]string) map[string]string {
+	var (
+		oldCount = map[string]int{}
+		newKeys  = map[string][]string{}
+	)
+	for _, v := range old {
+		oldCount[v]++
+	}
+	for k, v := range new {
+		newKeys[v] = append(newKeys[v], k)
+	}
+	pairs := map[string]string{}
+	for k, v := range old {
+		if oldCount[v] == 1 && len(newKeys[v]) == 1 {
+			pairs[k] = newKeys[v][0]
+		}
+	}
+	return pairs
+}
+
+// detectMoves replaces the Missing and Extra deltas of results which are the
+// same file at another path with Moved deltas, which hold the differences
+// between the keys of the two entries as found by opts. Whole directories
+// which were moved (with everything beneath them the same) are reported as a
+// single Moved delta for the directory. Files and directories are only paired
+// if there is exactly one candidate for each.
+func detectMoves(results []InodeDelta, opts *CompareOptions) ([]InodeDelta, error) {
+	var (
+		missing = newMoveSide(results, Missing)
+		extra   = newMoveSide(results, Extra)
+		moves   = map[string]string{}
+		// pairedNew are the paths of extra which have been paired.
+		pairedNew = map[string]bool{}
+	)
+	if len(missing.deltas) == 0 || len(extra.deltas) == 0 {
+		return results, nil
+	}
+
+	// Pair up whole directories first, starting from the top so that
+	// subdirectories of a moved directory are covered by it.
+	dirPairs := uniquePairs(missing.subtrees, extra.subtrees)
+	movedOld, movedNew := map[string]bool{}, map[string]bool{}
+	for _, p := range missing.sortedPaths() {
+		gnu, ok := dirPairs[p]
+		if !ok || inDirs(p, movedOld) || inDirs(gnu, movedNew) {
+			continue
+		}
+		moves[p] = gnu
+		pairedNew[gnu] = true
+		movedOld[p] = true
+		movedNew[gnu] = true
+	}
+
+	// Then pair up whatever files are left.
+	var (
+		oldIdentities = map[string]string{}
+		newIdentities = map[string]string{}
+	)
+	for p, d := range missing.deltas {
+		if !d.old.IsDir() && !inDirs(p, movedOld) && !mapContains(moves, p) {
+			oldIdentities[p] = moveIdentity(d.old)
+		}
+	}
+	for p, d := range extra.deltas {
+		if !d.new.IsDir() && !inDirs(p, movedNew) && !pairedNew[p] {
+			newIdentities[p] = moveIdentity(d.new)
+		}
+	}
+	for p, gnu := range uniquePairs(oldIdentities, newIdentities) {
+		moves[p] = gnu
+		pairedNew[gnu] = true
+	}
+
+	filtered := make([]InodeDelta, 0, len(results))
+	for _, d := range results {
+		switch {
+		case d.diff == Missing && (mapContains(moves, d.path) || inDirs(d.path, movedOld)):
+		case d.diff == Extra && (pairedNew[d.path] || inDirs(d.path, movedNew)):
+		default:
+			filtered = append(filtered, d)
+		}
+	}
+	for old, gnu := range moves {
+		oldEntry, newEntry := missing.deltas[old].old, extra.deltas[gnu].new
+		delta, changed, err := diffEntries(gnu, &oldEntry, &newEntry, opts)
+		if err != nil {
+			return nil, err
+		}
+		var keys []KeyDelta
+		if changed && delta.diff == Modified {
+			keys = delta.keys
+		}
+		filtered = append(filtered, InodeDelta{
+			diff:    Moved,
+			path:    gnu,
+			oldPath: old,
+			old:     oldEntry,
+			new:     newEntry,
+			keys:    keys,
+		})
+	}
+	return filtered, nil
+}
+
+// detectMovesFunc runs compare, which calls its argument with each of the
+// discrepancies of a comparison made with opts, and then calls fn with them
+// in hierarchy order after replacing moved entries with Moved deltas, until
+// fn returns false.
+func detectMovesFunc(opts *CompareOptions, compare func(func(InodeDelta) bool) error, fn func(InodeDelta) bool) error {
+	// Moves can only be found once all of the Missing and Extra entries are
+	// known.
+	var results []InodeDelta
+	err := compare(func(delta InodeDelta) bool {
+		results = append(results, delta)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	results, err = detectMoves(results, opts)
+	if err != nil {
+		return err
+	}
+	SortInodeDeltas(results)
+	for _, delta := range results {
+		if !fn(delta) {
+			break
+		}
+	}
+	return nil
+}
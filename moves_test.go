@@ -0,0 +1,198 @@
+package mtree
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// moveTree creates files with the given contents beneath dir, with a nil
+// content creating a directory.
+func moveTree(t *testing.T, dir string, files map[string][]byte) {
+	t.Helper()
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		if data == nil {
+			require.NoError(t, os.MkdirAll(path, 0755))
+		} else {
+			require.NoError(t, os.WriteFile(path, data, 0644))
+		}
+	}
+}
+
+// deltaSummary returns the type and paths of each of deltas.
+func deltaSummary(deltas []InodeDelta) []string {
+	var summary []string
+	for _, d := range deltas {
+		s := string(d.Type()) + " " + d.Path()
+		if d.Type() == Moved {
+			s += " <- " + d.OldPath()
+		}
+		summary = append(summary, s)
+	}
+	return summary
+}
+
+func TestDetectMoves(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		keywords []Keyword
+		old, new map[string][]byte
+		expected []string
+	}{
+		{
+			name:     "Subtree",
+			keywords: []Keyword{"type", "size", "sha256digest"},
+			old: map[string][]byte{
+				"src/a":       []byte("a\n"),
+				"src/empty":   {},
+				"src/empty2":  {},
+				"src/sub/b":   []byte("b\n"),
+				"src/sub/dir": nil,
+				"other":       []byte("other\n"),
+			},
+			new: map[string][]byte{
+				"dst/moved/a":       []byte("a\n"),
+				"dst/moved/empty":   {},
+				"dst/moved/empty2":  {},
+				"dst/moved/sub/b":   []byte("b\n"),
+				"dst/moved/sub/dir": nil,
+				"other":             []byte("other\n"),
+			},
+			expected: []string{
+				"extra dst",
+				"moved dst/moved <- src",
+			},
+		},
+		{
+			name:     "ChangedSubtree",
+			keywords: []Keyword{"type", "size", "sha256digest"},
+			old: map[string][]byte{
+				"src/a":     []byte("a\n"),
+				"src/b":     []byte("b\n"),
+				"src/empty": {},
+			},
+			new: map[string][]byte{
+				"dst/a":     []byte("a\n"),
+				"dst/b":     []byte("changed\n"),
+				"dst/empty": {},
+			},
+			// The directories aren't paired, as what is beneath them differs.
+			expected: []string{
+				"extra dst",
+				"extra dst/b",
+				"missing src",
+				"missing src/b",
+				"moved dst/a <- src/a",
+				"moved dst/empty <- src/empty",
+			},
+		},
+		{
+			name:     "Identity",
+			keywords: []Keyword{"type", "size"},
+			old: map[string][]byte{
+				"a":     []byte("12345"),
+				"b":     []byte("123"),
+				"c":     []byte("123"),
+				"d/dir": nil,
+			},
+			new: map[string][]byte{
+				"A":     []byte("abcde"),
+				"B":     []byte("abc"),
+				"C":     []byte("abc"),
+				"d/dir": nil,
+			},
+			expected: []string{
+				"extra B",
+				"extra C",
+				"missing b",
+				"missing c",
+				"moved A <- a",
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			oldDir, newDir := t.TempDir(), t.TempDir()
+			moveTree(t, oldDir, test.old)
+			moveTree(t, newDir, test.new)
+			oldDh, err := Walk(oldDir, nil, test.keywords, nil)
+			require.NoError(t, err)
+			newDh, err := Walk(newDir, nil, test.keywords, nil)
+			require.NoError(t, err)
+
+			res, err := CompareWithOptions(oldDh, newDh, &CompareOptions{
+				Keywords:    test.keywords,
+				DetectMoves: true,
+			})
+			require.NoError(t, err)
+			pprintInodeDeltas(t, res)
+			assert.ElementsMatch(t, test.expected, deltaSummary(res))
+
+			res, err = CompareWithOptions(oldDh, newDh, &CompareOptions{Keywords: test.keywords})
+			require.NoError(t, err)
+			for _, d := range res {
+				assert.NotEqual(t, Moved, d.Type(), "moves should only be detected with DetectMoves")
+			}
+		})
+	}
+}
+
+func TestMovedDelta(t *testing.T) {
+	oldDir, newDir := t.TempDir(), t.TempDir()
+	moveTree(t, oldDir, map[string][]byte{"old": []byte("data\n")})
+	moveTree(t, newDir, map[string][]byte{"new": []byte("data\n")})
+	keywords := []Keyword{"type", "sha256digest"}
+	oldDh, err := Walk(oldDir, nil, keywords, nil)
+	require.NoError(t, err)
+	newDh, err := Walk(newDir, nil, keywords, nil)
+	require.NoError(t, err)
+
+	res, err := CompareWithOptions(oldDh, newDh, &CompareOptions{DetectMoves: true})
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	d := res[0]
+	assert.Equal(t, Moved, d.Type())
+	assert.Equal(t, "new", d.Path())
+	assert.Equal(t, "old", d.OldPath())
+	require.NotNil(t, d.Old())
+	require.NotNil(t, d.New())
+	assert.Equal(t, "old", d.Old().Name)
+	assert.Equal(t, "new", d.New().Name)
+	assert.Equal(t, `"new": moved from "old"`, d.String())
+
+	buf, err := json.Marshal(d)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"type":"moved","path":"new","old_path":"old","keys":null}`, string(buf))
+}
+
+func TestMovedDeltaKeys(t *testing.T) {
+	oldDir, newDir := t.TempDir(), t.TempDir()
+	moveTree(t, oldDir, map[string][]byte{"old": []byte("data\n")})
+	moveTree(t, newDir, map[string][]byte{"new": []byte("data\n")})
+	require.NoError(t, os.Chmod(filepath.Join(newDir, "new"), 0600))
+	keywords := []Keyword{"type", "mode", "sha256digest"}
+	oldDh, err := Walk(oldDir, nil, keywords, nil)
+	require.NoError(t, err)
+	newDh, err := Walk(newDir, nil, keywords, nil)
+	require.NoError(t, err)
+
+	// The other differences of a moved file are reported with it.
+	res, err := CompareWithOptions(oldDh, newDh, &CompareOptions{DetectMoves: true})
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	assert.Equal(t, Moved, res[0].Type())
+	assert.Equal(t, []KeyDelta{{diff: Modified, name: "mode", old: "0644", new: "0600"}}, res[0].Diff())
+	assert.Equal(t, `"new": moved from "old": keyword "mode": expected 0644; got 0600`, res[0].String())
+
+	// Only for the keywords being compared.
+	res, err = CompareWithOptions(oldDh, newDh, &CompareOptions{Keywords: []Keyword{"type", "sha256digest"}, DetectMoves: true})
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	assert.Equal(t, Moved, res[0].Type())
+	assert.Empty(t, res[0].Diff())
+}
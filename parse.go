@@ -9,17 +9,44 @@ import (
 
 // ParseSpec reads a stream of an mtree specification, and returns the DirectoryHierarchy
 func ParseSpec(r io.Reader) (*DirectoryHierarchy, error) {
-	s := bufio.NewScanner(r)
-	i := int(0)
-	creator := dhCreator{
-		DH: &DirectoryHierarchy{},
+	dh := &DirectoryHierarchy{}
+	sr := NewSpecReader(r)
+	for {
+		e, err := sr.Next()
+		if err == io.EOF {
+			return dh, nil
+		}
+		if err != nil {
+			return dh, err
+		}
+		dh.Entries = append(dh.Entries, e)
 	}
+}
+
+// SpecReader reads the entries of an mtree specification one at a time, as
+// ParseSpec does, without holding all of them in memory. Only the entries of
+// the directories enclosing the current entry (its Parent chain) and the
+// current /set are kept.
+type SpecReader struct {
+	s       *bufio.Scanner
+	i       int
+	creator dhCreator
+}
+
+// NewSpecReader returns a SpecReader reading the specification from r.
+func NewSpecReader(r io.Reader) *SpecReader {
+	return &SpecReader{s: bufio.NewScanner(r)}
+}
+
+// Next returns the next entry of the specification, or io.EOF at its end.
+func (sr *SpecReader) Next() (Entry, error) {
+	s, creator := sr.s, &sr.creator
 	for s.Scan() {
 		str := s.Text()
 		trimmedStr := strings.TrimLeftFunc(str, func(c rune) bool {
 			return c == ' ' || c == '\t'
 		})
-		e := Entry{Pos: i}
+		e := Entry{Pos: sr.i}
 		switch {
 		case strings.HasPrefix(trimmedStr, "#"):
 			e.Raw = str
@@ -106,8 +133,11 @@ func ParseSpec(r io.Reader) (*DirectoryHierarchy, error) {
 			// TODO(vbatts) log a warning?
 			continue
 		}
-		creator.DH.Entries = append(creator.DH.Entries, e)
-		i++
+		sr.i++
+		return e, nil
+	}
+	if err := s.Err(); err != nil {
+		return Entry{}, err
 	}
-	return creator.DH, s.Err()
+	return Entry{}, io.EOF
 }
@@ -0,0 +1,185 @@
+package mtree
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// KeywordRule adjusts the set of keywords used for the paths matching
+// Pattern. Pattern is a path.Match glob which is matched against the path
+// (relative to the root of the hierarchy) and all of its parent directories,
+// so that a rule for "usr" applies to everything beneath "usr/" as well.
+type KeywordRule struct {
+	Pattern string
+	Add     []Keyword
+	Remove  []Keyword
+}
+
+// Match returns whether the rule applies to the relative path p.
+func (r KeywordRule) Match(p string) bool {
+	pattern := cleanRulePath(r.Pattern)
+	for p = cleanRulePath(p); ; p = path.Dir(p) {
+		if ok, _ := path.Match(pattern, p); ok {
+			return true
+		}
+		if p == "." || p == "/" {
+			return false
+		}
+	}
+}
+
+// KeywordRules is an ordered set of KeywordRule. When several rules match the
+// same path, they are applied in order so the last matching rule wins.
+type KeywordRules []KeywordRule
+
+// Apply returns the set of keywords to use for the relative path p, by
+// applying all of the matching rules to keywords. The keywords slice is not
+// modified.
+func (rs KeywordRules) Apply(p string, keywords []Keyword) []Keyword {
+	add, remove := rs.changes(p)
+	if len(add) == 0 && len(remove) == 0 {
+		return keywords
+	}
+	ret := []Keyword{}
+	for _, kw := range keywords {
+		if !InKeywordSlice(KeywordSynonym(string(kw)), remove) {
+			ret = append(ret, kw)
+		}
+	}
+	for _, kw := range add {
+		if !InKeywordSlice(kw, ret) {
+			ret = append(ret, kw)
+		}
+	}
+	return ret
+}
+
+// Removed returns whether keyword kw has been removed by the rules for the
+// relative path p.
+func (rs KeywordRules) Removed(p string, kw Keyword) bool {
+	_, remove := rs.changes(p)
+	return InKeywordSlice(KeywordSynonym(string(kw)), remove)
+}
+
+// BaseKeywords returns the keywords used in dh, leaving out those which are
+// only present because a rule added them for some paths. When dh was created
+// with these rules, this should be used in place of UsedKeywords.
+func (rs KeywordRules) BaseKeywords(dh *DirectoryHierarchy) ([]Keyword, error) {
+	used := []Keyword{}
+	for _, e := range dh.Entries {
+		var add []Keyword
+		switch e.Type {
+		case RelativeType, FullType:
+			p, err := e.Path()
+			if err != nil {
+				return nil, err
+			}
+			add, _ = rs.changes(p)
+		case SpecialType:
+			if e.Name != "/set" {
+				continue
+			}
+		default:
+			continue
+		}
+		for _, kv := range e.Keywords {
+			kw := KeywordSynonym(string(kv.Keyword().Prefix()))
+			if !InKeywordSlice(kw, add) && !InKeywordSlice(kw, used) {
+				used = append(used, kw)
+			}
+		}
+	}
+	return used, nil
+}
+
+// changes returns the net keywords added and removed for path p.
+func (rs KeywordRules) changes(p string) (add, remove []Keyword) {
+	for _, r := range rs {
+		if !r.Match(p) {
+			continue
+		}
+		for _, kw := range r.Add {
+			kw = KeywordSynonym(string(kw))
+			remove = deleteKeyword(remove, kw)
+			if !InKeywordSlice(kw, add) {
+				add = append(add, kw)
+			}
+		}
+		for _, kw := range r.Remove {
+			kw = KeywordSynonym(string(kw))
+			add = deleteKeyword(add, kw)
+			if !InKeywordSlice(kw, remove) {
+				remove = append(remove, kw)
+			}
+		}
+	}
+	return add, remove
+}
+
+func deleteKeyword(list []Keyword, kw Keyword) []Keyword {
+	ret := list[:0]
+	for _, k := range list {
+		if k != kw {
+			ret = append(ret, k)
+		}
+	}
+	return ret
+}
+
+// cleanRulePath makes rule patterns and paths comparable, so that "usr/",
+// "./usr" and "/usr" are all treated as "usr".
+func cleanRulePath(p string) string {
+	p = strings.TrimPrefix(path.Clean("/"+p), "/")
+	if p == "" {
+		return "."
+	}
+	return p
+}
+
+// ParseKeywordRules reads a set of KeywordRules from r. Each line has a path
+// pattern followed by the keywords to add (prefixed by '+') or remove
+// (prefixed by '-'), delimited by comma or space:
+//
+//	# pattern	keywords
+//	usr/		+sha256digest
+//	var/cache/	-sha256digest
+//	tmp/		-time
+//
+// Blank lines and lines beginning with '#' are ignored.
+func ParseKeywordRules(r io.Reader) (KeywordRules, error) {
+	var (
+		rules  KeywordRules
+		lineno int
+	)
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		lineno++
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		f := strings.Fields(strings.Replace(line, ",", " ", -1))
+		if len(f) < 2 {
+			return nil, fmt.Errorf("line %d: expected a pattern and at least one keyword", lineno)
+		}
+		if _, err := path.Match(f[0], ""); err != nil {
+			return nil, fmt.Errorf("line %d: invalid pattern %q: %w", lineno, f[0], err)
+		}
+		rule := KeywordRule{Pattern: f[0]}
+		for _, kw := range f[1:] {
+			switch {
+			case strings.HasPrefix(kw, "+") && len(kw) > 1:
+				rule.Add = append(rule.Add, KeywordSynonym(kw[1:]))
+			case strings.HasPrefix(kw, "-") && len(kw) > 1:
+				rule.Remove = append(rule.Remove, KeywordSynonym(kw[1:]))
+			default:
+				return nil, fmt.Errorf("line %d: keyword %q must be prefixed by '+' or '-'", lineno, kw)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, s.Err()
+}
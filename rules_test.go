@@ -0,0 +1,123 @@
+package mtree
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseKeywordRules(t *testing.T) {
+	rules, err := ParseKeywordRules(strings.NewReader(`
+# comment
+usr/        +sha256
+var/cache/  -sha256digest
+tmp/        -time,+sha1
+`))
+	require.NoError(t, err)
+	assert.Equal(t, KeywordRules{
+		{Pattern: "usr/", Add: []Keyword{"sha256digest"}},
+		{Pattern: "var/cache/", Remove: []Keyword{"sha256digest"}},
+		{Pattern: "tmp/", Add: []Keyword{"sha1digest"}, Remove: []Keyword{"time"}},
+	}, rules)
+
+	for _, bad := range []string{
+		"usr/",
+		"usr/ sha256digest",
+		"usr/ +",
+		"[ +time",
+	} {
+		_, err := ParseKeywordRules(strings.NewReader(bad))
+		assert.Errorf(t, err, "rules %q should fail to parse", bad)
+	}
+}
+
+func TestKeywordRulesApply(t *testing.T) {
+	rules := KeywordRules{
+		{Pattern: "usr", Add: []Keyword{"sha256digest"}},
+		{Pattern: "usr/lib/*.a", Remove: []Keyword{"sha256digest"}},
+		{Pattern: "/tmp/", Remove: []Keyword{"time"}},
+	}
+	base := []Keyword{"type", "time"}
+
+	for _, test := range []struct {
+		path   string
+		expect []Keyword
+	}{
+		{".", []Keyword{"type", "time"}},
+		{"usr", []Keyword{"type", "time", "sha256digest"}},
+		{"usr/bin/ls", []Keyword{"type", "time", "sha256digest"}},
+		{"usr/lib/libc.a", []Keyword{"type", "time"}},
+		{"usrlocal", []Keyword{"type", "time"}},
+		{"tmp/a/b", []Keyword{"type"}},
+	} {
+		t.Run(test.path, func(t *testing.T) {
+			assert.Equal(t, test.expect, rules.Apply(test.path, base))
+		})
+	}
+	assert.Equal(t, []Keyword{"type", "time"}, base, "Apply must not modify keywords")
+	assert.True(t, rules.Removed("tmp/x", "time"))
+	assert.False(t, rules.Removed("usr/x", "time"))
+}
+
+func TestCheckKeywordRules(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"usr", "tmp"} {
+		require.NoError(t, os.Mkdir(filepath.Join(dir, name), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name, "file"), []byte(name), 0644))
+	}
+	rules := KeywordRules{
+		{Pattern: "usr", Add: []Keyword{"sha256digest"}},
+		{Pattern: "tmp", Remove: []Keyword{"time"}},
+	}
+
+	dh, err := WalkWithOptions(dir, &WalkOptions{Keywords: DefaultKeywords, Rules: rules})
+	require.NoError(t, err)
+	for _, e := range dh.Entries {
+		if e.Type != RelativeType {
+			continue
+		}
+		p, err := e.Path()
+		require.NoError(t, err)
+		assert.Equalf(t, p == "usr/file", len(HasKeyword(e.Keywords, "sha256digest")) > 0, "%s: sha256digest", p)
+		assert.Equalf(t, strings.HasPrefix(p, "tmp"), len(HasKeyword(e.Keywords, "time")) == 0, "%s: time", p)
+	}
+
+	res, err := CheckWithOptions(dir, dh, &CheckOptions{Rules: rules})
+	require.NoError(t, err)
+	if !assert.Empty(t, res, "check after no changes should have no diff") {
+		pprintInodeDeltas(t, res)
+	}
+
+	// Changing the time under tmp/ is ignored, under usr/ it is not.
+	newtime := time.Date(2006, time.February, 1, 3, 4, 5, 0, time.UTC)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "tmp", "file"), newtime, newtime))
+	res, err = CheckWithOptions(dir, dh, &CheckOptions{Rules: rules})
+	require.NoError(t, err)
+	if !assert.Empty(t, res, "time under tmp/ should be ignored") {
+		pprintInodeDeltas(t, res)
+	}
+
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "usr", "file"), newtime, newtime))
+	res, err = CheckWithOptions(dir, dh, &CheckOptions{Rules: rules})
+	require.NoError(t, err)
+	if assert.Len(t, res, 1) {
+		assert.Equal(t, "usr/file", res[0].Path())
+		assert.Equal(t, Modified, res[0].Type())
+	}
+
+	// Without the rules, "time" is compared under tmp/ too.
+	newDh, err := Walk(dir, nil, DefaultKeywords, nil)
+	require.NoError(t, err)
+	res, err = Compare(dh, newDh, DefaultKeywords)
+	require.NoError(t, err)
+	var paths []string
+	for _, delta := range res {
+		paths = append(paths, delta.Path())
+	}
+	assert.ElementsMatch(t, []string{"tmp", "tmp/file", "usr/file"}, paths)
+}
@@ -0,0 +1,162 @@
+package mtree
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// selinuxNone is the context in a file_contexts rule for paths whose label
+// should be left alone.
+const selinuxNone = "<<none>>"
+
+// selinuxFileTypes maps the file type column of file_contexts(5) to the
+// values of the "type" keyword.
+var selinuxFileTypes = map[string]string{
+	"--": "file",
+	"-d": "dir",
+	"-l": "link",
+	"-c": "char",
+	"-b": "block",
+	"-p": "fifo",
+	"-s": "socket",
+}
+
+type selinuxRule struct {
+	re       *regexp.Regexp
+	fileType string // the "type" keyword value, or "" for all files
+	context  string
+}
+
+// SELinuxContexts are the rules of an SELinux file_contexts(5) file, giving
+// the label that each path is expected to have.
+type SELinuxContexts struct {
+	rules []selinuxRule
+}
+
+// ParseSELinuxContexts parses a file_contexts(5) file, where each line is a
+// path regular expression, an optional file type (such as "--" for regular
+// files or "-d" for directories) and a security context (or "<<none>>").
+// Blank lines and lines starting with '#' are ignored.
+func ParseSELinuxContexts(r io.Reader) (*SELinuxContexts, error) {
+	c := &SELinuxContexts{}
+	s := bufio.NewScanner(r)
+	for lineNo := 1; s.Scan(); lineNo++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		var rule selinuxRule
+		switch len(fields) {
+		case 2:
+			rule.context = fields[1]
+		case 3:
+			fileType, ok := selinuxFileTypes[fields[1]]
+			if !ok {
+				return nil, fmt.Errorf("file_contexts line %d: unknown file type %q", lineNo, fields[1])
+			}
+			rule.fileType = fileType
+			rule.context = fields[2]
+		default:
+			return nil, fmt.Errorf("file_contexts line %d: expected 2 or 3 fields, got %d", lineNo, len(fields))
+		}
+		// As with libselinux, the expression must match the whole path.
+		re, err := regexp.Compile("^(?:" + fields[0] + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("file_contexts line %d: %w", lineNo, err)
+		}
+		rule.re = re
+		c.rules = append(c.rules, rule)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Lookup returns the context which the absolute path, of the given file type
+// (a "type" keyword value such as "file" or "dir"), is expected to have. The
+// last matching rule wins. It returns false if no rule matches, or the rule is
+// "<<none>>".
+func (c *SELinuxContexts) Lookup(path, fileType string) (string, bool) {
+	for i := len(c.rules) - 1; i >= 0; i-- {
+		rule := c.rules[i]
+		if rule.fileType != "" && rule.fileType != fileType {
+			continue
+		}
+		if rule.re.MatchString(path) {
+			if rule.context == selinuxNone {
+				return "", false
+			}
+			return rule.context, true
+		}
+	}
+	return "", false
+}
+
+// CheckSELinuxContexts walks root, and compares the SELinux label of each path
+// to the one given for it by contexts, as if root were mounted at "/". Each
+// path with a different label (or no label) is returned as a Modified
+// InodeDelta, with a "selinux" KeyDelta whose old and new values are the
+// expected and actual contexts. A missing label is reported as "<<none>>".
+// The Excludes and FsEval of opts are used for the walk; its Keywords are
+// ignored.
+func CheckSELinuxContexts(root string, contexts *SELinuxContexts, opts *WalkOptions) ([]InodeDelta, error) {
+	walkOpts := WalkOptions{Keywords: []Keyword{"type"}}
+	if opts != nil {
+		walkOpts.Excludes = opts.Excludes
+		walkOpts.FsEval = opts.FsEval
+	}
+	dh, err := WalkWithOptions(root, &walkOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []InodeDelta
+	for _, e := range dh.Entries {
+		if e.Type != RelativeType && e.Type != FullType {
+			continue
+		}
+		path, err := e.Path()
+		if err != nil {
+			return nil, err
+		}
+		var fileType string
+		if kvs := HasKeyword(e.AllKeys(), "type"); len(kvs) > 0 {
+			fileType = kvs[0].Value()
+		}
+		want, ok := contexts.Lookup(filepath.Clean("/"+path), fileType)
+		if !ok {
+			continue
+		}
+		got, hasLabel, err := getSELinuxLabel(filepath.Join(root, path))
+		if err != nil {
+			return nil, fmt.Errorf("selinux label for %q: %w", path, err)
+		}
+		if !hasLabel {
+			got = selinuxNone
+		}
+		if got == want {
+			continue
+		}
+		results = append(results, InodeDelta{
+			diff: Modified,
+			path: path,
+			old:  e,
+			new:  e,
+			keys: []KeyDelta{
+				{
+					diff: Modified,
+					name: "selinux",
+					old:  want,
+					new:  got,
+				},
+			},
+		})
+	}
+	return results, nil
+}
@@ -0,0 +1,26 @@
+//go:build linux
+// +build linux
+
+package mtree
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/vbatts/go-mtree/xattr"
+	"golang.org/x/sys/unix"
+)
+
+// getSELinuxLabel returns the SELinux label of path, without following
+// symlinks. It returns false if path has no label, or the filesystem doesn't
+// support labels.
+func getSELinuxLabel(path string) (string, bool, error) {
+	buf, err := xattr.Lget(path, "security.selinux")
+	switch {
+	case errors.Is(err, unix.ENODATA), errors.Is(err, unix.ENOTSUP):
+		return "", false, nil
+	case err != nil:
+		return "", false, err
+	}
+	return strings.TrimRight(string(buf), "\x00"), true, nil
+}
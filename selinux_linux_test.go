@@ -0,0 +1,72 @@
+package mtree
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestCheckSELinuxContexts(t *testing.T) {
+	// /tmp is often tmpfs, which may not support security xattrs.
+	dir, err := os.MkdirTemp(".", "test.selinux.")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "usr", "bin"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "usr", "bin", "ls"), nil, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "usr", "bin", "ping"), nil, 0755))
+	require.NoError(t, os.Symlink("ls", filepath.Join(dir, "usr", "bin", "dir")))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "tmp"), 0755))
+
+	labels := map[string]string{
+		".":            "system_u:object_r:default_t:s0",
+		"usr":          "system_u:object_r:usr_t:s0",
+		"usr/bin":      "system_u:object_r:bin_t:s0",
+		"usr/bin/ls":   "system_u:object_r:bin_t:s0",
+		"usr/bin/dir":  "system_u:object_r:bin_t:s0",
+		"usr/bin/ping": "system_u:object_r:usr_t:s0",
+	}
+	for path, label := range labels {
+		if err := unix.Lsetxattr(filepath.Join(dir, path), "security.selinux", []byte(label+"\x00"), 0); err != nil {
+			t.Skipf("skipping: cannot set selinux labels in %q: %v", dir, err)
+		}
+	}
+
+	c, err := ParseSELinuxContexts(strings.NewReader(testFileContexts))
+	require.NoError(t, err)
+	res, err := CheckSELinuxContexts(dir, c, nil)
+	require.NoError(t, err)
+	if assert.Len(t, res, 1) {
+		assert.Equal(t, Modified, res[0].Type())
+		assert.Equal(t, "usr/bin/ping", res[0].Path())
+		if assert.Len(t, res[0].Diff(), 1) {
+			kd := res[0].Diff()[0]
+			assert.Equal(t, Keyword("selinux"), kd.Name())
+			assert.Equal(t, "system_u:object_r:bin_t:s0", *kd.Old())
+			assert.Equal(t, "system_u:object_r:usr_t:s0", *kd.New())
+		}
+	}
+
+	// Excluded paths aren't checked.
+	res, err = CheckSELinuxContexts(dir, c, &WalkOptions{
+		Excludes: []ExcludeFunc{NewPatternExcluder(dir, []string{"ping"})},
+	})
+	require.NoError(t, err)
+	assert.Empty(t, res)
+
+	// A path with no label is reported as such.
+	require.NoError(t, unix.Lremovexattr(filepath.Join(dir, "usr"), "security.selinux"))
+	res, err = CheckSELinuxContexts(dir, c, &WalkOptions{
+		Excludes: []ExcludeFunc{NewPatternExcluder(dir, []string{"ping"})},
+	})
+	require.NoError(t, err)
+	if assert.Len(t, res, 1) {
+		assert.Equal(t, "usr", res[0].Path())
+		assert.Equal(t, "<<none>>", *res[0].Diff()[0].New())
+	}
+}
@@ -0,0 +1,61 @@
+package mtree
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testFileContexts = `# comment
+/.*                    system_u:object_r:default_t:s0
+/usr(/.*)?             system_u:object_r:usr_t:s0
+/usr/bin(/.*)?         system_u:object_r:bin_t:s0
+/usr/bin/ping   --     system_u:object_r:ping_exec_t:s0
+/usr/bin/ping          system_u:object_r:bin_t:s0
+/tmp(/.*)?             <<none>>
+`
+
+func TestSELinuxContextsLookup(t *testing.T) {
+	c, err := ParseSELinuxContexts(strings.NewReader(testFileContexts))
+	require.NoError(t, err)
+
+	for _, test := range []struct {
+		path, fileType string
+		expect         string
+		ok             bool
+	}{
+		{"/", "dir", "system_u:object_r:default_t:s0", true},
+		{"/usr", "dir", "system_u:object_r:usr_t:s0", true},
+		{"/usr/share/doc", "dir", "system_u:object_r:usr_t:s0", true},
+		{"/usr/bin/ls", "file", "system_u:object_r:bin_t:s0", true},
+		{"/usr/bin", "dir", "system_u:object_r:bin_t:s0", true},
+		// The last matching rule wins, even though the earlier one is more
+		// specific.
+		{"/usr/bin/ping", "file", "system_u:object_r:bin_t:s0", true},
+		{"/usr/binary", "file", "system_u:object_r:usr_t:s0", true},
+		{"/tmp/foo", "file", "", false},
+	} {
+		got, ok := c.Lookup(test.path, test.fileType)
+		assert.Equal(t, test.ok, ok, test.path)
+		assert.Equal(t, test.expect, got, test.path)
+	}
+
+	c, err = ParseSELinuxContexts(strings.NewReader("/usr/bin/ping -- system_u:object_r:ping_exec_t:s0\n/usr/bin/ping -l system_u:object_r:bin_t:s0\n"))
+	require.NoError(t, err)
+	got, _ := c.Lookup("/usr/bin/ping", "file")
+	assert.Equal(t, "system_u:object_r:ping_exec_t:s0", got, "file types which don't match are skipped")
+	_, ok := c.Lookup("/usr/bin/ping", "dir")
+	assert.False(t, ok)
+
+	for _, bad := range []string{
+		"/usr",
+		"/usr -x system_u:object_r:usr_t:s0",
+		"/usr(  system_u:object_r:usr_t:s0",
+		"/usr -- system_u:object_r:usr_t:s0 extra",
+	} {
+		_, err := ParseSELinuxContexts(strings.NewReader(bad))
+		assert.Error(t, err, bad)
+	}
+}
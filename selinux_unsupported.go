@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package mtree
+
+// getSELinuxLabel would return the SELinux label of path, but SELinux is only
+// supported on Linux.
+func getSELinuxLabel(path string) (string, bool, error) {
+	return "", false, nil
+}
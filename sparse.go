@@ -0,0 +1,191 @@
+package mtree
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// sparseMapMax is the most data extents which are written out in a "sparse"
+// value. Files with more (such as fragmented VM images) have a digest of their
+// data map instead.
+const sparseMapMax = 32
+
+// sparseExtent is a region of a file which holds data, rather than a hole.
+type sparseExtent struct {
+	offset int64
+	length int64
+}
+
+// formatSparse returns the "sparse" value of a file of the given size with
+// the data extents. Files without any holes are "none", and files which are
+// entirely a hole are "hole". Otherwise the extents are written as
+// "offset:length" pairs, separated by ",", or as "sha256:<digest>" of those
+// pairs if there are more than sparseMapMax of them.
+func formatSparse(extents []sparseExtent, size int64) string {
+	switch {
+	case size == 0 || (len(extents) == 1 && extents[0] == sparseExtent{0, size}):
+		return "none"
+	case len(extents) == 0:
+		return "hole"
+	}
+	parts := make([]string, len(extents))
+	for i, e := range extents {
+		parts[i] = fmt.Sprintf("%d:%d", e.offset, e.length)
+	}
+	s := strings.Join(parts, ",")
+	if len(extents) > sparseMapMax {
+		sum := sha256.Sum256([]byte(s))
+		return "sha256:" + hex.EncodeToString(sum[:])
+	}
+	return s
+}
+
+// parseSparse parses a "sparse" value written by formatSparse as a list of
+// data extents. Values which are "none" or a digest can't be parsed.
+func parseSparse(s string) ([]sparseExtent, error) {
+	if s == "hole" {
+		return []sparseExtent{}, nil
+	}
+	var extents []sparseExtent
+	for _, part := range strings.Split(s, ",") {
+		offStr, lenStr, ok := strings.Cut(part, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid sparse extent %q", part)
+		}
+		offset, err := strconv.ParseInt(offStr, 10, 64)
+		if err != nil || offset < 0 {
+			return nil, fmt.Errorf("invalid sparse extent %q: bad offset", part)
+		}
+		length, err := strconv.ParseInt(lenStr, 10, 64)
+		if err != nil || length < 0 {
+			return nil, fmt.Errorf("invalid sparse extent %q: bad length", part)
+		}
+		extents = append(extents, sparseExtent{offset, length})
+	}
+	return extents, nil
+}
+
+// sparseCovered returns whether every byte of the extents in inner is also in
+// one of the extents of outer.
+func sparseCovered(inner, outer []sparseExtent) bool {
+	for _, e := range inner {
+		start, end := e.offset, e.offset+e.length
+		for start < end {
+			covered := false
+			for _, o := range outer {
+				if o.offset <= start && start < o.offset+o.length {
+					start = o.offset + o.length
+					covered = true
+					break
+				}
+			}
+			if !covered {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// compareSparse compares "sparse" values so that a file is only flagged if it
+// has lost sparseness: that is, it has data where the old value had a hole.
+// Having more holes (as some filesystems find zeroed blocks) is not a change.
+// Digests of large data maps can only be compared for equality.
+func compareSparse(old, new KeyVal, opts *CompareOptions) bool {
+	if old.Value() == new.Value() || old.Value() == "none" {
+		return true
+	}
+	if new.Value() == "none" {
+		return false
+	}
+	a, errA := parseSparse(old.Value())
+	b, errB := parseSparse(new.Value())
+	if errA != nil || errB != nil {
+		return false
+	}
+	return sparseCovered(b, a)
+}
+
+// compareBlocks compares "blocks" values so that a file is only flagged if it
+// has more blocks allocated than before, as when a sparse file is restored at
+// its full size.
+func compareBlocks(old, new KeyVal, opts *CompareOptions) bool {
+	a, errA := strconv.ParseInt(old.Value(), 10, 64)
+	b, errB := strconv.ParseInt(new.Value(), 10, 64)
+	if errA != nil || errB != nil {
+		return old.Value() == new.Value()
+	}
+	return b <= a
+}
+
+// tarSparseExtents returns the data extents of a sparse file in a tar archive
+// which uses the GNU PAX sparse format 0.0 or 0.1, as Go's archive/tar
+// leaves their data map in the PAX records. The data maps of other sparse
+// formats are not available, so it returns false for those (and for files
+// which are not sparse).
+func tarSparseExtents(hdr *tar.Header) ([]sparseExtent, bool, error) {
+	v, ok := hdr.PAXRecords["GNU.sparse.map"]
+	if !ok {
+		return nil, false, nil
+	}
+	fields := strings.Split(v, ",")
+	if len(fields)%2 != 0 {
+		return nil, false, fmt.Errorf("invalid GNU.sparse.map %q", v)
+	}
+	var extents []sparseExtent
+	for i := 0; i < len(fields); i += 2 {
+		offset, err := strconv.ParseInt(fields[i], 10, 64)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid GNU.sparse.map %q: %w", v, err)
+		}
+		length, err := strconv.ParseInt(fields[i+1], 10, 64)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid GNU.sparse.map %q: %w", v, err)
+		}
+		// GNU tar ends the map with an empty extent at the end of the file.
+		if length == 0 {
+			continue
+		}
+		extents = append(extents, sparseExtent{offset, length})
+	}
+	return extents, true, nil
+}
+
+// sparseKeywordFunc returns the data map of regular files, as found with
+// SEEK_DATA and SEEK_HOLE (see lseek(2)). For tar archives, the map is read
+// from the GNU sparse PAX records where possible.
+func sparseKeywordFunc(path string, info os.FileInfo, r io.Reader) ([]KeyVal, error) {
+	var (
+		extents []sparseExtent
+		ok      bool
+		err     error
+	)
+	if hdr, isTar := info.Sys().(*tar.Header); isTar {
+		extents, ok, err = tarSparseExtents(hdr)
+		if err == nil && !ok && hdr.Typeflag == tar.TypeReg {
+			// Files without a sparse map have no holes, unless they use one
+			// of the sparse formats whose map isn't available.
+			if hdr.PAXRecords["GNU.sparse.major"] == "" {
+				extents, ok = []sparseExtent{{0, hdr.Size}}, true
+			}
+		}
+	} else {
+		if !info.Mode().IsRegular() {
+			return nil, nil
+		}
+		extents, ok, err = sparseDataExtents(path, info.Size())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("sparse for %q: %w", path, err)
+	}
+	if !ok {
+		return nil, nil
+	}
+	return []KeyVal{KeyVal("sparse=" + formatSparse(extents, info.Size()))}, nil
+}
@@ -0,0 +1,44 @@
+//go:build linux
+// +build linux
+
+package mtree
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// sparseDataExtents returns the data extents of the regular file at path, by
+// seeking between its data and holes. It returns false if the filesystem
+// doesn't support SEEK_DATA and SEEK_HOLE.
+func sparseDataExtents(path string, size int64) ([]sparseExtent, bool, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return nil, false, err
+	}
+	defer fh.Close()
+
+	extents := []sparseExtent{}
+	for offset := int64(0); offset < size; {
+		data, err := fh.Seek(offset, unix.SEEK_DATA)
+		if errors.Is(err, unix.ENXIO) {
+			// The rest of the file is a hole.
+			break
+		}
+		if errors.Is(err, unix.EINVAL) {
+			return nil, false, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		hole, err := fh.Seek(data, unix.SEEK_HOLE)
+		if err != nil {
+			return nil, false, err
+		}
+		extents = append(extents, sparseExtent{data, hole - data})
+		offset = hole
+	}
+	return extents, true, nil
+}
@@ -0,0 +1,70 @@
+//go:build linux
+// +build linux
+
+package mtree
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSparseKeywords(t *testing.T) {
+	dir := t.TempDir()
+
+	full := filepath.Join(dir, "full")
+	require.NoError(t, os.WriteFile(full, make([]byte, 8192), 0o644))
+
+	sparse := filepath.Join(dir, "sparse")
+	fh, err := os.Create(sparse)
+	require.NoError(t, err)
+	_, err = fh.WriteAt([]byte("data"), 512<<10)
+	require.NoError(t, err)
+	require.NoError(t, fh.Truncate(1<<20))
+	require.NoError(t, fh.Close())
+
+	info, err := os.Lstat(full)
+	require.NoError(t, err)
+	kvs, err := sparseKeywordFunc(full, info, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []KeyVal{"sparse=none"}, kvs)
+
+	info, err = os.Lstat(sparse)
+	require.NoError(t, err)
+	kvs, err = sparseKeywordFunc(sparse, info, nil)
+	require.NoError(t, err)
+	require.Len(t, kvs, 1)
+	if kvs[0] == "sparse=none" {
+		t.Skipf("skipping: %q does not support sparse files", dir)
+	}
+	extents, err := parseSparse(kvs[0].Value())
+	require.NoError(t, err, "parse sparse value")
+	require.Len(t, extents, 1)
+	assert.LessOrEqual(t, extents[0].offset, int64(512<<10))
+	assert.Greater(t, extents[0].offset+extents[0].length, int64(512<<10))
+
+	kvs, err = blocksKeywordFunc(sparse, info, nil)
+	require.NoError(t, err)
+	require.Len(t, kvs, 1)
+	blocks, err := strconv.ParseInt(kvs[0].Value(), 10, 64)
+	require.NoError(t, err)
+	assert.Less(t, blocks, int64(1<<20)/512, "blocks of sparse file")
+
+	// Filling in the holes is flagged by both keywords.
+	dh, err := Walk(dir, nil, []Keyword{"type", "blocks", "sparse"}, nil)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(sparse, make([]byte, 1<<20), 0o644))
+	res, err := Check(dir, dh, nil, nil)
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	assert.Equal(t, "sparse", res[0].Path())
+	var names []Keyword
+	for _, kd := range res[0].Diff() {
+		names = append(names, kd.Name())
+	}
+	assert.ElementsMatch(t, []Keyword{"blocks", "sparse"}, names)
+}
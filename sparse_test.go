@@ -0,0 +1,138 @@
+package mtree
+
+import (
+	"archive/tar"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSparseFormat(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		extents []sparseExtent
+		size    int64
+		expect  string
+	}{
+		{name: "Empty", size: 0, expect: "none"},
+		{name: "Full", extents: []sparseExtent{{0, 8192}}, size: 8192, expect: "none"},
+		{name: "Hole", extents: []sparseExtent{}, size: 8192, expect: "hole"},
+		{name: "TrailingHole", extents: []sparseExtent{{0, 4096}}, size: 8192, expect: "0:4096"},
+		{name: "Holes", extents: []sparseExtent{{4096, 4096}, {16384, 8192}}, size: 32768, expect: "4096:4096,16384:8192"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got := formatSparse(test.extents, test.size)
+			assert.Equal(t, test.expect, got)
+
+			if got != "none" {
+				extents, err := parseSparse(got)
+				require.NoError(t, err)
+				assert.Equal(t, test.extents, extents)
+			}
+		})
+	}
+
+	// Large data maps are written as a digest.
+	var extents []sparseExtent
+	for i := int64(0); i <= sparseMapMax; i++ {
+		extents = append(extents, sparseExtent{i * 8192, 4096})
+	}
+	got := formatSparse(extents, 1<<20)
+	assert.True(t, strings.HasPrefix(got, "sha256:"), "expected a digest, got %q", got)
+	assert.Len(t, got, len("sha256:")+64)
+	_, err := parseSparse(got)
+	assert.Error(t, err)
+}
+
+func TestSparseCompare(t *testing.T) {
+	for _, test := range []struct {
+		old, new string
+		expect   bool
+	}{
+		{old: "none", new: "none", expect: true},
+		{old: "4096:4096", new: "4096:4096", expect: true},
+		// Losing sparseness is flagged.
+		{old: "4096:4096", new: "none", expect: false},
+		{old: "hole", new: "0:4096", expect: false},
+		{old: "4096:4096", new: "0:8192", expect: false},
+		{old: "4096:4096,16384:4096", new: "4096:4096,12288:8192", expect: false},
+		// Gaining holes is not.
+		{old: "none", new: "4096:4096", expect: true},
+		{old: "0:16384", new: "0:4096,8192:4096", expect: true},
+		{old: "0:8192,8192:8192", new: "4096:8192", expect: true},
+		{old: "4096:4096", new: "hole", expect: true},
+		// Digests can only be equal.
+		{old: "sha256:00", new: "sha256:00", expect: true},
+		{old: "sha256:00", new: "sha256:01", expect: false},
+		{old: "sha256:00", new: "4096:4096", expect: false},
+	} {
+		t.Run(test.old+"/"+test.new, func(t *testing.T) {
+			got := compareSparse(KeyVal("sparse="+test.old), KeyVal("sparse="+test.new), &CompareOptions{})
+			assert.Equal(t, test.expect, got)
+		})
+	}
+}
+
+func TestBlocksCompare(t *testing.T) {
+	for _, test := range []struct {
+		old, new string
+		expect   bool
+	}{
+		{old: "8", new: "8", expect: true},
+		{old: "8", new: "0", expect: true},
+		{old: "8", new: "2048", expect: false},
+		{old: "bad", new: "bad", expect: true},
+		{old: "bad", new: "8", expect: false},
+	} {
+		t.Run(test.old+"/"+test.new, func(t *testing.T) {
+			got := compareBlocks(KeyVal("blocks="+test.old), KeyVal("blocks="+test.new), &CompareOptions{})
+			assert.Equal(t, test.expect, got)
+		})
+	}
+}
+
+func TestSparseTar(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		hdr    tar.Header
+		expect []KeyVal
+	}{
+		{
+			name:   "Regular",
+			hdr:    tar.Header{Typeflag: tar.TypeReg, Size: 8192},
+			expect: []KeyVal{"sparse=none"},
+		},
+		{
+			name: "PAX01",
+			hdr: tar.Header{Typeflag: tar.TypeReg, Size: 1 << 20, PAXRecords: map[string]string{
+				"GNU.sparse.numblocks": "3",
+				"GNU.sparse.map":       "0,4096,524288,4096,1048576,0",
+			}},
+			expect: []KeyVal{"sparse=0:4096,524288:4096"},
+		},
+		{
+			// The data map of the 1.0 format isn't available.
+			name: "PAX10",
+			hdr: tar.Header{Typeflag: tar.TypeReg, Size: 1 << 20, PAXRecords: map[string]string{
+				"GNU.sparse.major": "1",
+				"GNU.sparse.minor": "0",
+			}},
+		},
+		{
+			name: "OldGNU",
+			hdr:  tar.Header{Typeflag: tar.TypeGNUSparse, Size: 1 << 20},
+		},
+		{
+			name: "Dir",
+			hdr:  tar.Header{Typeflag: tar.TypeDir},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			kvs, err := sparseKeywordFunc("file", test.hdr.FileInfo(), nil)
+			require.NoError(t, err)
+			assert.Equal(t, test.expect, kvs)
+		})
+	}
+}
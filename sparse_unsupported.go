@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package mtree
+
+// sparseDataExtents is only supported on Linux.
+func sparseDataExtents(path string, size int64) ([]sparseExtent, bool, error) {
+	return nil, false, nil
+}
@@ -17,3 +17,11 @@ func statIsGID(stat os.FileInfo, gid int) bool {
 	statT := stat.Sys().(*syscall.Stat_t)
 	return statT.Gid == uint32(gid)
 }
+
+func statIDs(stat os.FileInfo) (uid, gid int, ok bool) {
+	statT, ok := stat.Sys().(*syscall.Stat_t)
+	if !ok {
+		return -1, -1, false
+	}
+	return int(statT.Uid), int(statT.Gid), true
+}
@@ -11,3 +11,6 @@ func statIsUID(stat os.FileInfo, uid int) bool {
 func statIsGID(stat os.FileInfo, uid int) bool {
 	return false
 }
+func statIDs(stat os.FileInfo) (uid, gid int, ok bool) {
+	return -1, -1, false
+}
@@ -0,0 +1,97 @@
+//go:build linux
+// +build linux
+
+package mtree
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// statx returns the statx(2) fields in mask for path, without following
+// symlinks.
+func statx(path string, mask int) (*unix.Statx_t, error) {
+	var stx unix.Statx_t
+	if err := unix.Statx(unix.AT_FDCWD, path, unix.AT_SYMLINK_NOFOLLOW, mask, &stx); err != nil {
+		return nil, &os.PathError{Op: "statx", Path: path, Err: err}
+	}
+	return &stx, nil
+}
+
+// statxTimeKeywordFunc returns a KeywordFunc for a timestamp from statx(2),
+// which is written in the same form as "time". If the filesystem doesn't
+// provide the timestamp (as is common for the birth time), no value is
+// returned. For tar archives, the value comes from tarTime, if it is set.
+func statxTimeKeywordFunc(name string, mask int, get func(*unix.Statx_t) unix.StatxTimestamp, tarTime func(*tar.Header) time.Time) KeywordFunc {
+	return func(path string, info os.FileInfo, r io.Reader) ([]KeyVal, error) {
+		if hdr, ok := info.Sys().(*tar.Header); ok {
+			if tarTime == nil {
+				return nil, nil
+			}
+			t := tarTime(hdr)
+			if t.IsZero() {
+				return nil, nil
+			}
+			return []KeyVal{KeyVal(fmt.Sprintf("%s=%d.%9.9d", name, t.Unix(), t.Nanosecond()))}, nil
+		}
+		stx, err := statx(path, mask)
+		if err != nil {
+			return nil, err
+		}
+		if stx.Mask&uint32(mask) == 0 {
+			return nil, nil
+		}
+		ts := get(stx)
+		return []KeyVal{KeyVal(fmt.Sprintf("%s=%d.%9.9d", name, ts.Sec, ts.Nsec))}, nil
+	}
+}
+
+var (
+	btimeKeywordFunc = statxTimeKeywordFunc("btime", unix.STATX_BTIME,
+		func(stx *unix.Statx_t) unix.StatxTimestamp { return stx.Btime }, nil)
+	ctimeKeywordFunc = statxTimeKeywordFunc("ctime", unix.STATX_CTIME,
+		func(stx *unix.Statx_t) unix.StatxTimestamp { return stx.Ctime },
+		func(hdr *tar.Header) time.Time { return hdr.ChangeTime })
+	atimeKeywordFunc = statxTimeKeywordFunc("atime", unix.STATX_ATIME,
+		func(stx *unix.Statx_t) unix.StatxTimestamp { return stx.Atime },
+		func(hdr *tar.Header) time.Time { return hdr.AccessTime })
+
+	// mntidKeywordFunc is the ID of the mount containing the file, as in
+	// /proc/self/mountinfo. A different mntid for the same path means that
+	// something else has been mounted over it.
+	mntidKeywordFunc = func(path string, info os.FileInfo, r io.Reader) ([]KeyVal, error) {
+		if _, ok := info.Sys().(*tar.Header); ok {
+			return nil, nil
+		}
+		stx, err := statx(path, unix.STATX_MNT_ID)
+		if err != nil {
+			return nil, err
+		}
+		if stx.Mask&unix.STATX_MNT_ID == 0 {
+			return nil, nil
+		}
+		return []KeyVal{KeyVal(fmt.Sprintf("mntid=%d", stx.Mnt_id))}, nil
+	}
+)
+
+// atimeUpdateKeywordFunc sets the access time of path, leaving its
+// modification time alone.
+func atimeUpdateKeywordFunc(path string, kv KeyVal) (os.FileInfo, error) {
+	atime, err := parseTimeValue(kv.Value())
+	if err != nil {
+		return nil, err
+	}
+	utimes := []unix.Timespec{
+		unix.NsecToTimespec(atime.UnixNano()),
+		{Nsec: unix.UTIME_OMIT},
+	}
+	if err := unix.UtimesNanoAt(unix.AT_FDCWD, path, utimes, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return nil, &os.PathError{Op: "utimensat", Path: path, Err: err}
+	}
+	return os.Lstat(path)
+}
@@ -0,0 +1,130 @@
+package mtree
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatxKeywords(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	require.NoError(t, os.WriteFile(file, []byte("data"), 0644))
+	atime := time.Unix(1234567890, 500)
+	require.NoError(t, os.Chtimes(file, atime, atime))
+
+	info, err := os.Lstat(file)
+	require.NoError(t, err)
+	stat := info.Sys().(*syscall.Stat_t)
+
+	kvs, err := ctimeKeywordFunc(file, info, nil)
+	require.NoError(t, err)
+	require.Len(t, kvs, 1)
+	ctime, err := parseTimeValue(kvs[0].Value())
+	require.NoError(t, err)
+	assert.Equal(t, time.Unix(stat.Ctim.Unix()), ctime)
+
+	kvs, err = atimeKeywordFunc(file, info, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []KeyVal{"atime=1234567890.000000500"}, kvs)
+
+	kvs, err = mntidKeywordFunc(file, info, nil)
+	require.NoError(t, err)
+	if assert.Len(t, kvs, 1) {
+		assert.Equal(t, Keyword("mntid"), kvs[0].Keyword())
+	}
+
+	// Not all filesystems have birth times, but if they do, the file
+	// can't have been created after its metadata was last changed.
+	kvs, err = btimeKeywordFunc(file, info, nil)
+	require.NoError(t, err)
+	if len(kvs) > 0 {
+		btime, err := parseTimeValue(kvs[0].Value())
+		require.NoError(t, err)
+		assert.False(t, btime.After(ctime))
+	}
+
+	for _, kw := range []Keyword{"btime", "ctime", "atime", "mntid"} {
+		spec, ok := DefaultKeywordRegistry.Lookup(kw)
+		require.True(t, ok, kw)
+		assert.False(t, spec.IsBsd, kw)
+		if kw == "atime" {
+			assert.NotNil(t, spec.Update, kw)
+		} else {
+			assert.Nil(t, spec.Update, kw)
+		}
+		assert.Equal(t, kw == "btime" || kw == "ctime", spec.Volatile, kw)
+	}
+}
+
+func TestStatxAtimeUpdate(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	require.NoError(t, os.WriteFile(file, []byte("data"), 0644))
+	atime := time.Unix(1234567890, 500)
+	mtime := time.Unix(1234567000, 0)
+	require.NoError(t, os.Chtimes(file, atime, mtime))
+
+	keywords := []Keyword{"type", "time", "atime"}
+	dh, err := Walk(dir, nil, keywords, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, os.Chtimes(file, time.Unix(1, 0), time.Unix(2, 0)))
+	res, err := Update(dir, dh, []Keyword{"time", "atime"}, nil)
+	require.NoError(t, err)
+	assert.Empty(t, res)
+
+	// Setting "time" sets the access time too, so the atime must be set
+	// afterwards.
+	info, err := os.Lstat(file)
+	require.NoError(t, err)
+	assert.True(t, mtime.Equal(info.ModTime()), "mtime %s", info.ModTime())
+	kvs, err := atimeKeywordFunc(file, info, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []KeyVal{"atime=1234567890.000000500"}, kvs)
+}
+
+func TestStatxKeywordsCheckUpdate(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	require.NoError(t, os.WriteFile(file, []byte("data"), 0644))
+
+	dh, err := Walk(dir, nil, []Keyword{"type", "mode", "ctime", "mntid"}, nil)
+	require.NoError(t, err)
+	res, err := Check(dir, dh, nil, nil)
+	require.NoError(t, err)
+	assert.Empty(t, res)
+
+	// Changing the metadata of the file changes its ctime, which Update can't
+	// put back.
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, os.Chmod(file, 0600))
+	res, err = Check(dir, dh, nil, nil)
+	require.NoError(t, err)
+	if assert.Len(t, res, 1) {
+		assert.Equal(t, "file", res[0].Path())
+		if assert.Len(t, res[0].Diff(), 2, "mode and ctime") {
+			for _, kd := range res[0].Diff() {
+				assert.Equal(t, kd.Name() == "ctime", kd.Volatile(), kd.Name())
+			}
+		}
+	}
+
+	res, err = Update(dir, dh, []Keyword{"mode", "ctime"}, nil)
+	require.NoError(t, err)
+	if assert.NotEmpty(t, res) {
+		for _, r := range res {
+			assert.Equal(t, ErrorDifference, r.Type())
+			assert.Equal(t, Keyword("ctime"), r.Diff()[0].Name())
+		}
+	}
+
+	res, err = Check(dir, dh, []Keyword{"mode", "mntid"}, nil)
+	require.NoError(t, err)
+	assert.Empty(t, res)
+}
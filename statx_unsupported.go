@@ -0,0 +1,29 @@
+//go:build !linux
+// +build !linux
+
+package mtree
+
+import (
+	"io"
+	"os"
+)
+
+// The statx(2) keywords are only supported on Linux.
+var (
+	btimeKeywordFunc = func(path string, info os.FileInfo, r io.Reader) ([]KeyVal, error) {
+		return nil, nil
+	}
+	ctimeKeywordFunc = func(path string, info os.FileInfo, r io.Reader) ([]KeyVal, error) {
+		return nil, nil
+	}
+	atimeKeywordFunc = func(path string, info os.FileInfo, r io.Reader) ([]KeyVal, error) {
+		return nil, nil
+	}
+	mntidKeywordFunc = func(path string, info os.FileInfo, r io.Reader) ([]KeyVal, error) {
+		return nil, nil
+	}
+)
+
+func atimeUpdateKeywordFunc(path string, kv KeyVal) (os.FileInfo, error) {
+	return os.Lstat(path)
+}
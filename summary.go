@@ -0,0 +1,158 @@
+package mtree
+
+import (
+	"cmp"
+	"fmt"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// summaryTypes are the DifferenceTypes in the order in which DeltaSummary
+// lists them.
+var summaryTypes = []DifferenceType{Modified, Missing, Extra, Moved, Same, ErrorDifference}
+
+// DeltaSummary is the rollup of the deltas beneath a directory, as returned by
+// Summarize.
+type DeltaSummary struct {
+	// Path is the directory which the deltas are beneath.
+	Path string `json:"path"`
+
+	// Counts are the number of deltas of each DifferenceType.
+	Counts map[DifferenceType]int `json:"counts"`
+
+	// Keywords are the number of Modified deltas in which each keyword
+	// changed. Keywords with a suffix (such as "xattr.user.foo") are counted
+	// as their prefix.
+	Keywords map[Keyword]int `json:"keywords,omitempty"`
+
+	// Size is the total "size" of the non-directories of the deltas, from
+	// their entry in the "new" DirectoryHierarchy (or the "old" one, if they
+	// are Missing).
+	Size int64 `json:"size"`
+}
+
+// String returns a one-line description of the summary, such as
+// "usr/lib: 1203 modified (sha256digest 1200, mode 3), 4 extra; 52334 bytes".
+func (s DeltaSummary) String() string {
+	var parts []string
+	for _, typ := range summaryTypes {
+		n, ok := s.Counts[typ]
+		if !ok {
+			continue
+		}
+		part := fmt.Sprintf("%d %s", n, typ)
+		if typ == Modified && len(s.Keywords) > 0 {
+			keywords := make([]Keyword, 0, len(s.Keywords))
+			for kw := range s.Keywords {
+				keywords = append(keywords, kw)
+			}
+			// The most changed keywords first.
+			slices.SortFunc(keywords, func(a, b Keyword) int {
+				if c := cmp.Compare(s.Keywords[b], s.Keywords[a]); c != 0 {
+					return c
+				}
+				return strings.Compare(string(a), string(b))
+			})
+			var counts []string
+			for _, kw := range keywords {
+				counts = append(counts, fmt.Sprintf("%s %d", kw, s.Keywords[kw]))
+			}
+			part += " (" + strings.Join(counts, ", ") + ")"
+		}
+		parts = append(parts, part)
+	}
+	return fmt.Sprintf("%s: %s; %d bytes", s.Path, strings.Join(parts, ", "), s.Size)
+}
+
+// Summarizer rolls up deltas by directory as they are added, for summarizing
+// a stream of deltas (such as from CompareSeq) without holding all of them.
+type Summarizer struct {
+	depth     int
+	summaries map[string]*DeltaSummary
+}
+
+// NewSummarizer returns a Summarizer which groups deltas by their parent
+// directory, cut to its first depth path components. If depth is zero or
+// less, then deltas are grouped by their parent directory.
+func NewSummarizer(depth int) *Summarizer {
+	return &Summarizer{depth: depth, summaries: map[string]*DeltaSummary{}}
+}
+
+// summaryDir returns the directory of the DeltaSummary which the delta of p is
+// rolled up in.
+func (s *Summarizer) summaryDir(p string) string {
+	dir := path.Dir(p)
+	if p == "." || dir == "." || s.depth <= 0 {
+		return dir
+	}
+	parts := strings.Split(dir, "/")
+	if len(parts) > s.depth {
+		// Keep the leading "" of absolute paths.
+		n := s.depth
+		if parts[0] == "" {
+			n++
+		}
+		dir = strings.Join(parts[:min(n, len(parts))], "/")
+	}
+	return dir
+}
+
+// Add rolls delta up into the summary of its directory.
+func (s *Summarizer) Add(delta InodeDelta) {
+	dir := s.summaryDir(delta.Path())
+	summary, ok := s.summaries[dir]
+	if !ok {
+		summary = &DeltaSummary{Path: dir, Counts: map[DifferenceType]int{}}
+		s.summaries[dir] = summary
+	}
+	summary.Counts[delta.Type()]++
+	if delta.Type() == Modified {
+		for _, kd := range delta.Diff() {
+			if summary.Keywords == nil {
+				summary.Keywords = map[Keyword]int{}
+			}
+			summary.Keywords[kd.Name().Prefix()]++
+		}
+	}
+
+	e := delta.New()
+	if e == nil {
+		e = delta.Old()
+	}
+	if e != nil && !e.IsDir() {
+		if kv, ok := e.allKeysMap()["size"]; ok {
+			if size, err := strconv.ParseInt(kv.Value(), 10, 64); err == nil {
+				summary.Size += size
+			}
+		}
+	}
+}
+
+// Summaries returns the summaries of the deltas which have been added, in
+// hierarchy order.
+func (s *Summarizer) Summaries() []DeltaSummary {
+	summaries := make([]DeltaSummary, 0, len(s.summaries))
+	for _, summary := range s.summaries {
+		summaries = append(summaries, *summary)
+	}
+	slices.SortFunc(summaries, func(a, b DeltaSummary) int {
+		return compareEntryKeys(entryKey{path: a.Path, dir: true}, entryKey{path: b.Path, dir: true})
+	})
+	return summaries
+}
+
+// Summarize rolls deltas up by directory, giving the number of deltas of each
+// DifferenceType and of each changed keyword beneath each directory, as well
+// as the total size of the files affected. Deltas are grouped by their parent
+// directory, cut to its first depth path components (so that with a depth of
+// 2, the deltas beneath "usr/lib" are summarized together). If depth is zero
+// or less, then they are grouped by their parent directory.
+func Summarize(deltas []InodeDelta, depth int) []DeltaSummary {
+	s := NewSummarizer(depth)
+	for _, delta := range deltas {
+		s.Add(delta)
+	}
+	return s.Summaries()
+}
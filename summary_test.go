@@ -0,0 +1,94 @@
+package mtree
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummarize(t *testing.T) {
+	keywords := []Keyword{"type", "size", "mode", "sha256digest"}
+
+	dir := t.TempDir()
+	moveTree(t, dir, map[string][]byte{
+		"top":             []byte("top\n"),
+		"usr/lib/a":       []byte("a\n"),
+		"usr/lib/b":       []byte("b\n"),
+		"usr/lib/deep/c":  []byte("c\n"),
+		"usr/bin/d":       []byte("d\n"),
+		"usr/bin/gone":    []byte("gone\n"),
+		"etc/unchanged/e": []byte("e\n"),
+	})
+	oldDh, err := Walk(dir, nil, keywords, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "usr/lib/a"), []byte("changed\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "usr/lib/deep/c"), []byte("changed c\n"), 0644))
+	require.NoError(t, os.Chmod(filepath.Join(dir, "usr/lib/b"), 0600))
+	require.NoError(t, os.Remove(filepath.Join(dir, "usr/bin/gone")))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "usr/bin/new"), []byte("new file\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "new"), []byte("new\n"), 0644))
+	newDh, err := Walk(dir, nil, keywords, nil)
+	require.NoError(t, err)
+
+	// The sizes of directories depend on the filesystem.
+	isModifiedDir := func(d InodeDelta) bool { return d.Type() == Modified && d.Old().IsDir() }
+	res, err := Compare(oldDh, newDh, keywords)
+	require.NoError(t, err)
+	res = slices.DeleteFunc(res, isModifiedDir)
+
+	summaries := Summarize(res, 2)
+	var lines []string
+	for _, s := range summaries {
+		lines = append(lines, s.String())
+	}
+	assert.Equal(t, []string{
+		".: 1 extra; 4 bytes",
+		"usr/bin: 1 missing, 1 extra; 14 bytes",
+		"usr/lib: 3 modified (sha256digest 2, size 2, mode 1); 20 bytes",
+	}, lines)
+
+	lib := summaries[2]
+	assert.Equal(t, "usr/lib", lib.Path)
+	assert.Equal(t, map[DifferenceType]int{Modified: 3}, lib.Counts)
+	assert.Equal(t, map[Keyword]int{"sha256digest": 2, "size": 2, "mode": 1}, lib.Keywords)
+	assert.EqualValues(t, 20, lib.Size)
+
+	buf, err := json.Marshal(lib)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"path":"usr/lib","counts":{"modified":3},"keywords":{"mode":1,"sha256digest":2,"size":2},"size":20}`, string(buf))
+
+	// Without a depth, each parent directory is summarized separately.
+	lines = nil
+	for _, s := range Summarize(res, 0) {
+		lines = append(lines, s.Path)
+	}
+	assert.Equal(t, []string{".", "usr/bin", "usr/lib", "usr/lib/deep"}, lines)
+
+	// With a depth of 1, all of usr is together.
+	lines = nil
+	for _, s := range Summarize(res, 1) {
+		lines = append(lines, s.String())
+	}
+	assert.Equal(t, []string{
+		".: 1 extra; 4 bytes",
+		"usr: 3 modified (sha256digest 2, size 2, mode 1), 1 missing, 1 extra; 34 bytes",
+	}, lines)
+
+	// Adding the deltas one at a time gives the same summaries.
+	s := NewSummarizer(2)
+	for delta, err := range CompareSeq(oldDh, newDh, &CompareOptions{Keywords: keywords}) {
+		require.NoError(t, err)
+		if !isModifiedDir(delta) {
+			s.Add(delta)
+		}
+	}
+	assert.Equal(t, summaries, s.Summaries())
+
+	assert.Empty(t, Summarize(nil, 2))
+}
@@ -6,6 +6,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/sirupsen/logrus"
@@ -27,6 +28,35 @@ var tarDefaultSetKeywords = []KeyVal{
 // NewTarStreamer streams a tar archive and creates a file hierarchy based off
 // of the tar metadata headers
 func NewTarStreamer(r io.Reader, excludes []ExcludeFunc, keywords []Keyword) Streamer {
+	return NewTarStreamerWithOptions(r, &TarStreamerOptions{
+		Excludes: excludes,
+		Keywords: keywords,
+	})
+}
+
+// TarStreamerOptions are the parameters for NewTarStreamerWithOptions.
+type TarStreamerOptions struct {
+	// Excludes are used to skip paths.
+	Excludes []ExcludeFunc
+
+	// Keywords are the set to collect from the archive's entries.
+	Keywords []Keyword
+
+	// Registry is used to find how to collect each keyword. If nil, then
+	// DefaultKeywordRegistry is used.
+	Registry *KeywordRegistry
+}
+
+// NewTarStreamerWithOptions is like NewTarStreamer, but takes its parameters
+// from opts. A nil opts is treated the same as a zero TarStreamerOptions.
+func NewTarStreamerWithOptions(r io.Reader, opts *TarStreamerOptions) Streamer {
+	if opts == nil {
+		opts = &TarStreamerOptions{}
+	}
+	registry := opts.Registry
+	if registry == nil {
+		registry = DefaultKeywordRegistry
+	}
 	pR, pW := io.Pipe()
 	ts := &tarStream{
 		pipeReader: pR,
@@ -34,9 +64,10 @@ func NewTarStreamer(r io.Reader, excludes []ExcludeFunc, keywords []Keyword) Str
 		creator:    dhCreator{DH: &DirectoryHierarchy{}},
 		teeReader:  io.TeeReader(r, pW),
 		tarReader:  tar.NewReader(pR),
-		keywords:   keywords,
+		keywords:   opts.Keywords,
 		hardlinks:  map[string][]string{},
-		excludes:   excludes,
+		excludes:   opts.Excludes,
+		registry:   registry,
 	}
 
 	go ts.readHeaders()
@@ -53,6 +84,8 @@ type tarStream struct {
 	tarReader  *tar.Reader
 	keywords   []Keyword
 	excludes   []ExcludeFunc
+	registry   *KeywordRegistry
+	digester   *dirDigester
 	err        error
 }
 
@@ -71,6 +104,10 @@ func (ts *tarStream) readHeaders() {
 		}
 	}
 	ts.keywords = notimekws
+	digest, hash := selectDirDigests(ts.keywords)
+	if digest || hash {
+		ts.digester = newDirDigester("")
+	}
 	// We have to start with the directory we're in, and anything beyond these
 	// items is determined at the time a tar is extracted.
 	ts.root = &Entry{
@@ -93,6 +130,9 @@ func (ts *tarStream) readHeaders() {
 		e.Pos = len(ts.creator.DH.Entries)
 		ts.creator.DH.Entries = append(ts.creator.DH.Entries, e)
 	}
+	// excluded directories, so that their contents are skipped as well (the
+	// same as filepath.SkipDir when walking)
+	excludedDirs := []string{}
 hdrloop:
 	for {
 		hdr, err := ts.tarReader.Next()
@@ -101,8 +141,17 @@ hdrloop:
 			return
 		}
 
+		name := CleanPath(hdr.Name)
+		for _, dir := range excludedDirs {
+			if strings.HasPrefix(name, dir+string(filepath.Separator)) {
+				continue hdrloop
+			}
+		}
 		for _, ex := range ts.excludes {
-			if ex(hdr.Name, hdr.FileInfo()) {
+			if ex(name, hdr.FileInfo()) {
+				if hdr.FileInfo().IsDir() {
+					excludedDirs = append(excludedDirs, name)
+				}
 				continue hdrloop
 			}
 		}
@@ -143,8 +192,7 @@ hdrloop:
 
 		// Keep track of which files are hardlinks so we can resolve them later
 		if hdr.Typeflag == tar.TypeLink {
-			keyFunc := KeywordFuncs["link"]
-			kvs, err := keyFunc(hdr.Name, hdr.FileInfo(), nil)
+			kvs, err := linkKeywordFunc(hdr.Name, hdr.FileInfo(), nil)
 			if err != nil {
 				logrus.Warn(err)
 				break // XXX is breaking an okay thing to do here?
@@ -163,16 +211,19 @@ hdrloop:
 
 		// now collect keywords on the file
 		for _, keyword := range ts.keywords {
-			if keyFunc, ok := KeywordFuncs[keyword.Prefix()]; ok {
+			if spec, ok := ts.registry.Lookup(keyword); ok && spec.Collect != nil {
 				// We can't extract directories on to disk, so "size" keyword
 				// is irrelevant for now
 				if hdr.FileInfo().IsDir() && keyword == "size" {
 					continue
 				}
-				kvs, err := keyFunc(hdr.Name, hdr.FileInfo(), tmpFile)
+				kvs, err := spec.Collect(hdr.Name, hdr.FileInfo(), tmpFile)
 				if err != nil {
 					ts.setErr(err)
 				}
+				kvs = slices.DeleteFunc(kvs, func(kv KeyVal) bool {
+					return !suffixSelected(kv.Keyword(), ts.keywords)
+				})
 				// for good measure, check that we actually get a value for a keyword
 				if len(kvs) > 0 && kvs[0] != "" {
 					e.Keywords = append(e.Keywords, kvs[0])
@@ -187,6 +238,27 @@ hdrloop:
 				}
 			}
 		}
+		// record the file for the "dirdigest" and "dirhash" of its parents
+		if ts.digester != nil {
+			child, err := dirChildOf(e.Keywords, func(fn KeywordFunc) ([]KeyVal, error) {
+				return fn(hdr.Name, hdr.FileInfo(), nil)
+			}, func() (io.ReadCloser, error) {
+				if _, err := tmpFile.Seek(0, 0); err != nil {
+					return nil, err
+				}
+				return io.NopCloser(tmpFile), nil
+			})
+			if _, err := tmpFile.Seek(0, 0); err != nil {
+				ts.setErr(err)
+			}
+			if err != nil {
+				ts.setErr(err)
+			}
+			if hdr.Typeflag == tar.TypeLink {
+				child.hardlink = hdr.Linkname
+			}
+			ts.digester.add(hdr.Name, child)
+		}
 		// collect meta-set keywords for a directory so that we can build the
 		// actual sets in `flatten`
 		if hdr.FileInfo().IsDir() {
@@ -195,8 +267,8 @@ hdrloop:
 				Type: SpecialType,
 			}
 			for _, setKW := range SetKeywords {
-				if keyFunc, ok := KeywordFuncs[setKW.Prefix()]; ok {
-					kvs, err := keyFunc(hdr.Name, hdr.FileInfo(), tmpFile)
+				if spec, ok := ts.registry.Lookup(setKW); ok && spec.Collect != nil {
+					kvs, err := spec.Collect(hdr.Name, hdr.FileInfo(), tmpFile)
 					if err != nil {
 						ts.setErr(err)
 					}
@@ -433,5 +505,15 @@ func (ts *tarStream) Hierarchy() (*DirectoryHierarchy, error) {
 	}
 	resolveHardlinks(ts.root, ts.hardlinks, InKeywordSlice(Keyword("nlink"), ts.keywords))
 	flatten(ts.root, &ts.creator, ts.keywords)
+	if ts.digester != nil {
+		digest, hash := selectDirDigests(ts.keywords)
+		for dir := range ts.digester.children {
+			ts.digester.digestDirs[dir] = digest
+			ts.digester.hashDirs[dir] = hash
+		}
+		if err := ts.digester.apply(ts.creator.DH); err != nil {
+			return nil, err
+		}
+	}
 	return ts.creator.DH, nil
 }
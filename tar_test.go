@@ -333,3 +333,56 @@ func TestArchiveExcludeNonDirectory(t *testing.T) {
 		}
 	}
 }
+
+// tarFile is an entry of the archive written by tarHierarchy. The Size of a
+// regular file is taken from its Data.
+type tarFile struct {
+	tar.Header
+	Data []byte
+}
+
+// tarHierarchy writes files to a tar archive, and returns the hierarchy given
+// for it by the tar streamer.
+func tarHierarchy(t *testing.T, opts *TarStreamerOptions, files ...tarFile) *DirectoryHierarchy {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, f := range files {
+		hdr := f.Header
+		if hdr.Typeflag == tar.TypeReg {
+			hdr.Size = int64(len(f.Data))
+		}
+		require.NoError(t, tw.WriteHeader(&hdr))
+		_, err := tw.Write(f.Data)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+
+	ts := NewTarStreamerWithOptions(&buf, opts)
+	_, err := io.Copy(io.Discard, ts)
+	require.NoError(t, err)
+	require.NoError(t, ts.Close())
+	dh, err := ts.Hierarchy()
+	require.NoError(t, err)
+	return dh
+}
+
+// walkAndTarHierarchies returns the hierarchies given for the same regular
+// files by Walk and by the tar streamer, keyed by "walk" and "tar". A nil
+// registry is the default one.
+func walkAndTarHierarchies(t *testing.T, registry *KeywordRegistry, keywords []Keyword, files map[string][]byte) map[string]*DirectoryHierarchy {
+	t.Helper()
+	dir := t.TempDir()
+	var tfs []tarFile
+	for name, data := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), data, 0644))
+		tfs = append(tfs, tarFile{
+			Header: tar.Header{Name: name, Mode: 0644, Typeflag: tar.TypeReg},
+			Data:   data,
+		})
+	}
+	dh, err := WalkWithOptions(dir, &WalkOptions{Keywords: keywords, Registry: registry})
+	require.NoError(t, err)
+	tdh := tarHierarchy(t, &TarStreamerOptions{Keywords: keywords, Registry: registry}, tfs...)
+	return map[string]*DirectoryHierarchy{"walk": dh, "tar": tdh}
+}
@@ -0,0 +1,39 @@
+#!/bin/bash
+set -ex
+
+name=$(basename $0)
+root="$(dirname $(dirname $(dirname $0)))"
+gomtree=$(go run ${root}/test/realpath/main.go ${root}/gomtree)
+t=$(mktemp -d /tmp/go-mtree.XXXXXX)
+
+echo "[${name}] Running in ${t}"
+
+mkdir -p ${t}/root/usr/bin ${t}/root/var/cache ${t}/root/tmp
+echo "binary" > ${t}/root/usr/bin/tool
+echo "cached" > ${t}/root/var/cache/blob
+echo "scratch" > ${t}/root/tmp/scratch
+
+cat > ${t}/rules.conf <<RULES
+# pattern   keywords
+usr/        +sha256digest
+var/cache/  -sha256digest
+tmp/        -time
+RULES
+
+# --rules: sha256digest is only collected under usr/, and time is dropped under tmp/
+${gomtree} validate -c --rules ${t}/rules.conf -p ${t}/root > ${t}/root.mtree
+grep -q 'tool .*sha256digest=' ${t}/root.mtree
+(! grep -q 'blob .*sha256digest=' ${t}/root.mtree)
+(! grep -q 'scratch .*time=' ${t}/root.mtree)
+grep -q 'blob .*time=' ${t}/root.mtree
+
+# validating with the same rules passes, even when tmp/ has been touched
+touch -d '2001-01-01' ${t}/root/tmp/scratch
+${gomtree} validate --rules ${t}/rules.conf -f ${t}/root.mtree -p ${t}/root
+
+# but a change of content under usr/ is still caught
+echo "binarY" > ${t}/root/usr/bin/tool
+(! ${gomtree} validate --result-format=json --rules ${t}/rules.conf -f ${t}/root.mtree -p ${t}/root > ${t}/result)
+grep -q '"name":"sha256digest"' ${t}/result
+
+rm -rf ${t}
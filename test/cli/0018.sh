@@ -0,0 +1,60 @@
+#!/bin/bash
+set -ex
+
+name=$(basename $0)
+root="$(dirname $(dirname $(dirname $0)))"
+gomtree=$(go run ${root}/test/realpath/main.go ${root}/gomtree)
+t=$(mktemp -d /tmp/go-mtree.XXXXXX)
+
+echo "[${name}] Running in ${t}"
+
+mkdir -p ${t}/root/src/build ${t}/root/build ${t}/root/doc/api
+echo "main"  > ${t}/root/src/main.c
+echo "obj"   > ${t}/root/src/main.o
+echo "keep"  > ${t}/root/src/keep.o
+echo "out"   > ${t}/root/build/out
+echo "nest"  > ${t}/root/src/build/nested
+echo "html"  > ${t}/root/doc/api/index.html
+echo "text"  > ${t}/root/doc/README
+
+## -X: gitignore(5) patterns
+cat > ${t}/excl.txt <<EOX
+# objects, except the one we want
+*.o
+!keep.o
+# only the top-level build directory
+/build/
+doc/**/*.html
+EOX
+${gomtree} -c -p ${t}/root -X ${t}/excl.txt > ${t}/root.mtree
+(! grep -q 'main\.o' ${t}/root.mtree)
+grep -q 'keep\.o' ${t}/root.mtree
+(! grep -q '^    out' ${t}/root.mtree)
+grep -q 'nested' ${t}/root.mtree
+(! grep -q 'index\.html' ${t}/root.mtree)
+grep -q 'README' ${t}/root.mtree
+
+# Excluded files can change without failing validation.
+echo "changed" > ${t}/root/src/main.o
+${gomtree} -p ${t}/root -f ${t}/root.mtree -X ${t}/excl.txt
+
+## --exclude-per-directory: nested ignore files
+printf '*.c\n' > ${t}/root/src/.mtreeignore
+printf 'README\n' > ${t}/root/doc/.mtreeignore
+${gomtree} -c -p ${t}/root --exclude-per-directory .mtreeignore > ${t}/nested.mtree
+(! grep -q 'main\.c' ${t}/nested.mtree)
+(! grep -q 'README' ${t}/nested.mtree)
+grep -q 'main\.o' ${t}/nested.mtree
+grep -q 'index\.html' ${t}/nested.mtree
+${gomtree} -p ${t}/root -f ${t}/nested.mtree --exclude-per-directory .mtreeignore
+
+## -T: the same patterns apply to tar archives
+tar -C ${t}/root -cf ${t}/root.tar .
+${gomtree} -c -T ${t}/root.tar -X ${t}/excl.txt > ${t}/tar.mtree
+(! grep -q 'main\.o' ${t}/tar.mtree)
+grep -q 'keep\.o' ${t}/tar.mtree
+(! grep -q '^    out' ${t}/tar.mtree)
+grep -q 'nested' ${t}/tar.mtree
+(! grep -q 'index\.html' ${t}/tar.mtree)
+
+rm -rf ${t}
@@ -0,0 +1,35 @@
+#!/bin/bash
+set -ex
+
+name=$(basename $0)
+root="$(dirname $(dirname $(dirname $0)))"
+gomtree=$(go run ${root}/test/realpath/main.go ${root}/gomtree)
+t=$(mktemp -d /tmp/go-mtree.XXXXXX)
+
+echo "[${name}] Running in ${t}"
+
+mkdir -p ${t}/root
+echo "hello" > ${t}/root/file
+touch -d '2020-01-01 00:00:00.250' ${t}/root/file
+
+${gomtree} -c -k type,time -p ${t}/root > ${t}/root.mtree
+${gomtree} -k type,time -p ${t}/root -f ${t}/root.mtree
+
+# Lose the sub-second part of the time, as happens when copying to a
+# filesystem with coarser timestamps.
+touch -d '2020-01-01 00:00:00' ${t}/root/file
+(! ${gomtree} -k type,time -p ${t}/root -f ${t}/root.mtree)
+${gomtree} -k type,time -p ${t}/root -f ${t}/root.mtree --time-precision=s
+${gomtree} -k type,time -p ${t}/root -f ${t}/root.mtree --time-tolerance=1s
+
+# A difference of a whole second is only ignored with enough tolerance.
+touch -d '2020-01-01 00:00:01.500' ${t}/root/file
+(! ${gomtree} -k type,time -p ${t}/root -f ${t}/root.mtree --time-precision=s)
+(! ${gomtree} -k type,time -p ${t}/root -f ${t}/root.mtree --time-tolerance=1s)
+${gomtree} -k type,time -p ${t}/root -f ${t}/root.mtree --time-tolerance=2s
+${gomtree} -k type,time -p ${t}/root -f ${t}/root.mtree --time-precision=2s
+
+# Invalid values are rejected.
+(! ${gomtree} -k type,time -p ${t}/root -f ${t}/root.mtree --time-precision=fortnight)
+
+rm -rf ${t}
@@ -0,0 +1,31 @@
+#!/bin/bash
+set -ex
+
+name=$(basename $0)
+root="$(dirname $(dirname $(dirname $0)))"
+gomtree=$(go run ${root}/test/realpath/main.go ${root}/gomtree)
+t=$(mktemp -d /tmp/go-mtree.XXXXXX)
+
+echo "[${name}] Running in ${t}"
+
+mkdir -p ${t}/root
+echo "hello" > ${t}/root/file
+chmod 0755 ${t}/root/file
+
+# Manifests can be written with symbolic modes, and still validate.
+${gomtree} -c -k type,mode -p ${t}/root --symbolic-modes > ${t}/root.mtree
+grep -q 'mode=u=rwx,g=rx,o=rx' ${t}/root.mtree
+(! grep -q 'mode=0' ${t}/root.mtree)
+${gomtree} -k type,mode -p ${t}/root -f ${t}/root.mtree
+
+# A spec edited by hand to use symbolic modes can be applied with -u,
+# including the setuid bit.
+${gomtree} -c -p ${t}/root > ${t}/octal.mtree
+sed -e 's/^\(    file .*\)mode=0755/\1mode=u=rwxs,go=rx/' ${t}/octal.mtree > ${t}/setuid.mtree
+grep -q 'mode=u=rwxs,go=rx' ${t}/setuid.mtree
+(! ${gomtree} -k type,mode -p ${t}/root -f ${t}/setuid.mtree)
+${gomtree} -u -k type,mode -p ${t}/root -f ${t}/setuid.mtree
+${gomtree} -k type,mode -p ${t}/root -f ${t}/setuid.mtree
+[ "$(stat -c %a ${t}/root/file)" = "4755" ]
+
+rm -rf ${t}
@@ -0,0 +1,31 @@
+#!/bin/bash
+set -ex
+
+name=$(basename $0)
+root="$(dirname $(dirname $(dirname $0)))"
+gomtree=$(go run ${root}/test/realpath/main.go ${root}/gomtree)
+t=$(mktemp -d /tmp/go-mtree.XXXXXX)
+
+echo "[${name}] Running in ${t}"
+
+mkdir -p ${t}/root ${t}/db
+echo "hello" > ${t}/root/file
+uid=$(stat -c %u ${t}/root/file)
+gid=$(stat -c %g ${t}/root/file)
+echo "chrootuser:x:${uid}:${gid}::/:/bin/sh" > ${t}/db/passwd
+echo "chrootgroup:x:${gid}:" > ${t}/db/group
+
+# uname and gname come from the given database, not the host's.
+${gomtree} -c -k type,uname,gname -p ${t}/root -N ${t}/db > ${t}/root.mtree
+grep -q 'uname=chrootuser' ${t}/root.mtree
+grep -q 'gname=chrootgroup' ${t}/root.mtree
+${gomtree} -k type,uname,gname -p ${t}/root -N ${t}/db -f ${t}/root.mtree
+
+# Without the database, the names don't match.
+(! ${gomtree} -k type,uname,gname -p ${t}/root -f ${t}/root.mtree)
+
+# An ID with no entry in the database is an error.
+: > ${t}/db/passwd
+(! ${gomtree} -c -k type,uname -p ${t}/root -N ${t}/db)
+
+rm -rf ${t}
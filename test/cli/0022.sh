@@ -0,0 +1,35 @@
+#!/bin/bash
+set -ex
+
+name=$(basename $0)
+root="$(dirname $(dirname $(dirname $0)))"
+gomtree=$(go run ${root}/test/realpath/main.go ${root}/gomtree)
+t=$(mktemp -d /tmp/go-mtree.XXXXXX)
+
+echo "[${name}] Running in ${t}"
+
+mkdir -p ${t}/root
+echo "hello" > ${t}/root/file
+uid=$(stat -c %u ${t}/root/file)
+gid=$(stat -c %g ${t}/root/file)
+
+# Pretend the spec was created in a user namespace where the owner of the
+# files was 1000:1000.
+${gomtree} -c -p ${t}/root > ${t}/host.mtree
+sed -e "s/uid=${uid}/uid=1000/;s/gid=${gid}/gid=1000/" ${t}/host.mtree > ${t}/ns.mtree
+grep -q 'uid=1000' ${t}/ns.mtree
+
+if [ "${uid}" != 1000 ]; then
+	(! ${gomtree} -k type,uid,gid -p ${t}/root -f ${t}/ns.mtree)
+fi
+${gomtree} -k type,uid,gid -p ${t}/root -f ${t}/ns.mtree --uidmap 1000:${uid}:1 --gidmap 1000:${gid}:1
+
+# The maps can also be read from a uid_map file.
+printf '%10d %10d %10d\n' 1000 ${uid} 1 > ${t}/uid_map
+printf '%10d %10d %10d\n' 1000 ${gid} 1 > ${t}/gid_map
+${gomtree} -k type,uid,gid -p ${t}/root -f ${t}/ns.mtree --uidmap ${t}/uid_map --gidmap ${t}/gid_map
+${gomtree} -u -k type,uid,gid -p ${t}/root -f ${t}/ns.mtree --uidmap ${t}/uid_map --gidmap ${t}/gid_map
+
+(! ${gomtree} -k type,uid,gid -p ${t}/root -f ${t}/ns.mtree --uidmap 1000:${uid})
+
+rm -rf ${t}
@@ -0,0 +1,48 @@
+#!/bin/bash
+set -ex
+
+name=$(basename $0)
+root="$(dirname $(dirname $(dirname $0)))"
+gomtree=$(go run ${root}/test/realpath/main.go ${root}/gomtree)
+t=$(mktemp -d /tmp/go-mtree.XXXXXX)
+
+echo "[${name}] Running in ${t}"
+
+mkdir -p ${t}/root/usr/bin ${t}/root/tmp
+touch ${t}/root/usr/bin/ls ${t}/root/tmp/scratch
+
+cat > ${t}/file_contexts <<EOC
+/.*              system_u:object_r:default_t:s0
+/usr/bin(/.*)?   system_u:object_r:bin_t:s0
+/tmp(/.*)?       <<none>>
+EOC
+
+# Paths without the expected label are reported.
+(! ${gomtree} validate -p ${t}/root --selinux-contexts=${t}/file_contexts > ${t}/out)
+grep -q '"usr/bin/ls": keyword "selinux": expected system_u:object_r:bin_t:s0; got <<none>>' ${t}/out
+(! grep -q 'scratch' ${t}/out)
+
+# Paths with a "<<none>>" rule are not checked.
+printf '/.* <<none>>\n' > ${t}/none_contexts
+${gomtree} validate -p ${t}/root --selinux-contexts=${t}/none_contexts
+
+# The labels are checked as well as the spec.
+${gomtree} validate -c -p ${t}/root > ${t}/root.mtree
+${gomtree} validate -p ${t}/root -f ${t}/root.mtree
+(! ${gomtree} validate -p ${t}/root -f ${t}/root.mtree --selinux-contexts=${t}/file_contexts)
+
+# The labels are reported in hierarchy order along with the spec's results.
+chmod 0600 ${t}/root/tmp/scratch
+(! ${gomtree} validate -p ${t}/root -f ${t}/root.mtree --selinux-contexts=${t}/file_contexts > ${t}/out)
+head -n1 ${t}/out | grep -q '^"\.": keyword "selinux"'
+[ "$(grep -n 'tmp/scratch' ${t}/out | cut -d: -f1)" -lt "$(grep -n 'usr/bin/ls' ${t}/out | cut -d: -f1)" ]
+chmod 0644 ${t}/root/tmp/scratch
+
+setfattr -n security.selinux -v "system_u:object_r:default_t:s0" "${t}/root" || exit 0
+setfattr -n security.selinux -v "system_u:object_r:default_t:s0" "${t}/root/usr"
+setfattr -n security.selinux -v "system_u:object_r:bin_t:s0" "${t}/root/usr/bin"
+setfattr -n security.selinux -v "system_u:object_r:bin_t:s0" "${t}/root/usr/bin/ls"
+setfattr -n security.selinux -v "system_u:object_r:default_t:s0" "${t}/root/tmp"
+${gomtree} validate -p ${t}/root -f ${t}/root.mtree --selinux-contexts=${t}/file_contexts
+
+rm -rf ${t}
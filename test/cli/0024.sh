@@ -0,0 +1,46 @@
+#!/bin/bash
+set -ex
+
+name=$(basename $0)
+root="$(dirname $(dirname $(dirname $0)))"
+gomtree=$(go run ${root}/test/realpath/main.go ${root}/gomtree)
+t=$(mktemp -d /tmp/go-mtree.XXXXXX)
+
+setfattr -n user.has.xattrs -v "true" "${t}" || exit 0
+
+echo "[${name}] Running in ${t}"
+
+## Test that namespaces of extended attributes can be selected.
+
+mkdir -p ${t}/root
+touch ${t}/root/file
+setfattr -n user.mtree.testing -v "apples" ${t}/root/file
+setfattr -n trusted.mtree.testing -v "bananas" ${t}/root/file || exit 0
+
+# Only the selected namespace is collected.
+${gomtree} validate -c -k "xattr.user.*" -p ${t}/root > ${t}/user.mtree
+grep -q 'xattr.user.mtree.testing=' ${t}/user.mtree
+(! grep -q 'xattr.trusted.mtree' ${t}/user.mtree)
+
+# Removed namespaces are left out.
+${gomtree} validate -c -K xattr -R "xattr.trusted.*" -p ${t}/root > ${t}/notrusted.mtree
+grep -q 'xattr.user.mtree.testing=' ${t}/notrusted.mtree
+(! grep -q 'xattr.trusted.mtree' ${t}/notrusted.mtree)
+# The removed namespaces are not keywords of the manifest.
+grep -q '^# *keywords: .*xattr' ${t}/notrusted.mtree
+(! grep -q '^# *keywords: .*!' ${t}/notrusted.mtree)
+
+# Changes to other namespaces are not checked.
+${gomtree} validate -c -k "type,xattr" -p ${t}/root > ${t}/all.mtree
+setfattr -n trusted.mtree.testing -v "cherries" ${t}/root/file
+${gomtree} validate -k "type,xattr.user.*" -p ${t}/root -f ${t}/all.mtree
+(! ${gomtree} validate -k "type,xattr" -p ${t}/root -f ${t}/all.mtree)
+${gomtree} validate -K xattr -R "xattr.trusted.*" -p ${t}/root -f ${t}/all.mtree
+
+# Symlinks have their own extended attributes.
+ln -s file ${t}/root/link
+setfattr -h -n trusted.mtree.link -v "link" ${t}/root/link
+${gomtree} validate -c -k "type,xattr" -p ${t}/root > ${t}/link.mtree
+grep -q '^ *link .*xattr.trusted.mtree.link=' ${t}/link.mtree
+
+rm -rf ${t}
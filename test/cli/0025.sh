@@ -0,0 +1,38 @@
+#!/bin/bash
+set -ex
+
+name=$(basename $0)
+root="$(dirname $(dirname $(dirname $0)))"
+gomtree=$(go run ${root}/test/realpath/main.go ${root}/gomtree)
+t=$(mktemp -d /tmp/go-mtree.XXXXXX)
+
+echo "[${name}] Running in ${t}"
+
+## Test the dirdigest and dirhash keywords of directories.
+
+mkdir -p ${t}/root/sub
+echo hello > ${t}/root/a
+echo x > ${t}/root/sub/b
+ln -s ../a ${t}/root/sub/l
+
+${gomtree} validate -c -K dirdigest,dirhash -p ${t}/root > ${t}/root.mtree
+grep -q '^\. .*dirdigest=' ${t}/root.mtree
+grep -q '^sub .*dirhash=h1:' ${t}/root.mtree
+${gomtree} validate -K dirdigest,dirhash -p ${t}/root -f ${t}/root.mtree
+
+# The same digests are found in a tar archive.
+tar -C ${t}/root -cf ${t}/root.tar .
+${gomtree} validate -c -k type,dirdigest,dirhash -T ${t}/root.tar > ${t}/tar.mtree
+${gomtree} validate -k type,dirdigest,dirhash -p ${t}/root -f ${t}/tar.mtree
+
+# Changes to the contents change the digests of the parents.
+echo y > ${t}/root/sub/b
+(! ${gomtree} validate -k type,dirdigest -p ${t}/root -f ${t}/tar.mtree)
+
+# The contents of unchanged directories are skipped with --trust-dirdigests.
+echo x > ${t}/root/sub/b
+chmod 0600 ${t}/root/sub/b
+(! ${gomtree} validate -K dirdigest -p ${t}/root -f ${t}/root.mtree)
+${gomtree} validate -K dirdigest --trust-dirdigests -p ${t}/root -f ${t}/root.mtree
+
+rm -rf ${t}
@@ -0,0 +1,44 @@
+#!/bin/bash
+set -ex
+
+name=$(basename $0)
+root="$(dirname $(dirname $(dirname $0)))"
+gomtree=$(go run ${root}/test/realpath/main.go ${root}/gomtree)
+t=$(mktemp -d /tmp/go-mtree.XXXXXX)
+
+echo "[${name}] Running in ${t}"
+
+## Test keywords backed by external commands.
+
+mkdir -p ${t}/root
+echo "MIT License" > ${t}/root/LICENSE
+cat > ${t}/license <<'EOS'
+#!/bin/sh
+if [ "$MTREE_TYPE" = file ]; then head -n 1; fi
+EOS
+chmod +x ${t}/license
+
+${gomtree} validate -c -K exec.license --exec exec.license=${t}/license -p ${t}/root > ${t}/root.mtree
+grep -q 'LICENSE .*exec.license=MIT\\040License' ${t}/root.mtree
+${gomtree} validate --exec exec.license=${t}/license -p ${t}/root -f ${t}/root.mtree
+${gomtree} validate --exec exec.license=${t}/license --exec-jobs 1 -p ${t}/root -f ${t}/root.mtree
+
+# So are archives.
+tar -C ${t}/root -cf ${t}/root.tar .
+${gomtree} validate -c -K exec.license --exec exec.license=${t}/license -T ${t}/root.tar > ${t}/tar.mtree
+grep -q 'LICENSE .*exec.license=MIT\\040License' ${t}/tar.mtree
+${gomtree} validate -k exec.license --exec exec.license=${t}/license -T ${t}/root.tar -f ${t}/root.mtree
+
+# The command must be given to validate the keyword.
+(! ${gomtree} validate -p ${t}/root -f ${t}/root.mtree)
+
+# Changes to the value are reported.
+echo "Apache License" > ${t}/root/LICENSE
+(! ${gomtree} validate -k exec.license --exec exec.license=${t}/license -p ${t}/root -f ${t}/root.mtree)
+
+# Commands which run too long are killed.
+printf '#!/bin/sh\nsleep 5\n' > ${t}/slow
+chmod +x ${t}/slow
+(! ${gomtree} validate -c -K exec.slow --exec exec.slow=${t}/slow --exec-timeout 100ms -p ${t}/root)
+
+rm -rf ${t}
@@ -0,0 +1,42 @@
+#!/bin/bash
+set -ex
+
+name=$(basename $0)
+root="$(dirname $(dirname $(dirname $0)))"
+gomtree=$(go run ${root}/test/realpath/main.go ${root}/gomtree)
+t=$(mktemp -d /tmp/go-mtree.XXXXXX)
+
+echo "[${name}] Running in ${t}"
+
+## Test that moved paths are reported with --detect-moves.
+
+mkdir -p ${t}/root/src/sub
+echo a > ${t}/root/src/a
+echo b > ${t}/root/src/sub/b
+echo c > ${t}/root/c
+chmod 0644 ${t}/root/c
+
+${gomtree} validate -c -k type,size,mode,sha256digest -p ${t}/root > ${t}/root.mtree
+
+mv ${t}/root/src ${t}/root/dst
+mv ${t}/root/c ${t}/root/renamed
+chmod 0600 ${t}/root/renamed
+
+${gomtree} validate --detect-moves -p ${t}/root -f ${t}/root.mtree > ${t}/out
+cat ${t}/out
+[ "$(wc -l < ${t}/out)" -eq 2 ]
+grep -q '"dst": moved from "src"' ${t}/out
+grep -q '"renamed": moved from "c": keyword "mode": expected 0644; got 0600' ${t}/out
+
+${gomtree} validate --detect-moves --result-format=json -p ${t}/root -f ${t}/root.mtree > ${t}/out.json
+grep -q '"type":"moved","path":"dst","old_path":"src"' ${t}/out.json
+
+# Moves are only reported as missing and extra paths without --detect-moves.
+${gomtree} validate -p ${t}/root -f ${t}/root.mtree > ${t}/out
+grep -q '"src/sub/b": missing path' ${t}/out
+(! grep -q moved ${t}/out)
+
+# Like missing and extra paths, moved paths are failures with --strict.
+(! ${gomtree} validate --strict --detect-moves -p ${t}/root -f ${t}/root.mtree)
+
+rm -rf ${t}
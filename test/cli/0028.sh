@@ -0,0 +1,45 @@
+#!/bin/bash
+set -ex
+
+name=$(basename $0)
+root="$(dirname $(dirname $(dirname $0)))"
+gomtree=$(go run ${root}/test/realpath/main.go ${root}/gomtree)
+t=$(mktemp -d /tmp/go-mtree.XXXXXX)
+
+echo "[${name}] Running in ${t}"
+
+## Test comparing two manifests with --stream.
+
+mkdir -p ${t}/root/sub/deep ${t}/root/other
+echo a > ${t}/root/a
+echo b > ${t}/root/sub/b
+echo c > ${t}/root/sub/deep/c
+echo d > ${t}/root/other/d
+
+${gomtree} validate -c -K sha256digest -p ${t}/root > ${t}/old.mtree
+${gomtree} validate --stream -f ${t}/old.mtree -f ${t}/old.mtree
+
+echo changed > ${t}/root/sub/b
+rm ${t}/root/other/d
+echo e > ${t}/root/sub/deep/e
+${gomtree} validate -c -K sha256digest -p ${t}/root > ${t}/new.mtree
+
+# The results are the same as without --stream.
+for format in bsd json path; do
+	(! ${gomtree} validate --strict --result-format=${format} -f ${t}/old.mtree -f ${t}/new.mtree) > ${t}/expected.${format}
+	(! ${gomtree} validate --strict --stream --result-format=${format} -f ${t}/old.mtree -f ${t}/new.mtree) > ${t}/got.${format}
+	diff -u ${t}/expected.${format} ${t}/got.${format}
+done
+grep -q '"sub/b": keyword "sha256digest"' ${t}/got.bsd
+
+# Manifests which aren't in path order are sorted first.
+tar -C ${t}/root -cf ${t}/root.tar .
+${gomtree} validate -c -k type,size,sha256digest -T ${t}/root.tar > ${t}/tar.mtree
+${gomtree} validate --stream -k type,sha256digest -f ${t}/tar.mtree -f ${t}/new.mtree
+(! ${gomtree} validate --stream -k type,sha256digest -f ${t}/tar.mtree -f ${t}/old.mtree) > ${t}/out
+grep -q '"sub/b": keyword "sha256digest"' ${t}/out
+
+# Two manifests are needed.
+(! ${gomtree} validate --stream -f ${t}/old.mtree -p ${t}/root)
+
+rm -rf ${t}
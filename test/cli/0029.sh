@@ -0,0 +1,43 @@
+#!/bin/bash
+set -ex
+
+name=$(basename $0)
+root="$(dirname $(dirname $(dirname $0)))"
+gomtree=$(go run ${root}/test/realpath/main.go ${root}/gomtree)
+t=$(mktemp -d /tmp/go-mtree.XXXXXX)
+
+echo "[${name}] Running in ${t}"
+
+## Test the summary result format.
+
+mkdir -p ${t}/root/usr/lib/deep ${t}/root/usr/bin
+echo a > ${t}/root/usr/lib/a
+echo b > ${t}/root/usr/lib/b
+echo c > ${t}/root/usr/lib/deep/c
+echo d > ${t}/root/usr/bin/d
+
+${gomtree} validate -c -k type,size,mode,sha256digest -p ${t}/root > ${t}/old.mtree
+
+echo changed > ${t}/root/usr/lib/a
+echo changed > ${t}/root/usr/lib/deep/c
+chmod 0600 ${t}/root/usr/lib/b
+echo new > ${t}/root/usr/bin/new
+
+${gomtree} validate -c -k type,size,mode,sha256digest -p ${t}/root > ${t}/new.mtree
+
+(! ${gomtree} validate -k type,mode,sha256digest --result-format=summary -f ${t}/old.mtree -f ${t}/new.mtree) > ${t}/out
+cat ${t}/out
+grep -qx 'usr/lib: 3 modified (sha256digest 2, mode 1); 18 bytes' ${t}/out
+grep -qx 'usr/bin: 1 extra; 4 bytes' ${t}/out
+
+(! ${gomtree} validate -k type,mode,sha256digest --result-format=summary --summary-depth=0 -f ${t}/old.mtree -f ${t}/new.mtree) > ${t}/out
+grep -qx 'usr/lib/deep: 1 modified (sha256digest 1); 8 bytes' ${t}/out
+
+# The same summary is given when streaming the manifests.
+(! ${gomtree} validate -k type,mode,sha256digest --result-format=summary -f ${t}/old.mtree -f ${t}/new.mtree) > ${t}/expected
+(! ${gomtree} validate -k type,mode,sha256digest --result-format=summary --stream -f ${t}/old.mtree -f ${t}/new.mtree) > ${t}/got
+diff -u ${t}/expected ${t}/got
+(! ${gomtree} validate -k type,mode,sha256digest --result-format=summary --summary-depth=0 --stream -f ${t}/old.mtree -f ${t}/new.mtree) > ${t}/got
+grep -qx 'usr/lib/deep: 1 modified (sha256digest 1); 8 bytes' ${t}/got
+
+rm -rf ${t}
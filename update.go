@@ -21,6 +21,52 @@ var DefaultUpdateKeywords = []Keyword{
 
 // Update attempts to set the attributes of root directory path, given the values of `keywords` in dh DirectoryHierarchy.
 func Update(root string, dh *DirectoryHierarchy, keywords []Keyword, fs FsEval) ([]InodeDelta, error) {
+	return UpdateWithOptions(root, dh, &UpdateOptions{
+		Keywords: keywords,
+		FsEval:   fs,
+	})
+}
+
+// UpdateOptions are the parameters for UpdateWithOptions.
+type UpdateOptions struct {
+	// Keywords are the set to update. The recommended default list is
+	// DefaultUpdateKeywords.
+	Keywords []Keyword
+
+	// FsEval is the interface to use in evaluating files. If nil, then
+	// DefaultFsEval is used.
+	FsEval FsEval
+
+	// Registry is used to find how to update each keyword. If nil, then
+	// DefaultKeywordRegistry is used.
+	Registry *KeywordRegistry
+
+	// IDResolver is used to find the IDs for the "uname" and "gname"
+	// keywords. If nil, then HostIDResolver is used.
+	IDResolver IDResolver
+
+	// UIDMap and GIDMap translate the "uid" and "gid" values of the
+	// DirectoryHierarchy (such as a manifest created inside a user
+	// namespace) to the IDs which are set on disk. It is an error for a value
+	// not to be mapped.
+	UIDMap IDMap
+	GIDMap IDMap
+}
+
+// UpdateWithOptions is like Update, but takes its parameters from opts. A nil
+// opts is treated the same as a zero UpdateOptions.
+func UpdateWithOptions(root string, dh *DirectoryHierarchy, opts *UpdateOptions) ([]InodeDelta, error) {
+	if opts == nil {
+		opts = &UpdateOptions{}
+	}
+	keywords := opts.Keywords
+	registry := opts.Registry
+	if registry == nil {
+		registry = DefaultKeywordRegistry
+	}
+	if opts.IDResolver != nil {
+		registry = registry.withIDResolver(opts.IDResolver)
+	}
 	creator := dhCreator{DH: dh}
 	curDir, err := os.Getwd()
 	if err == nil {
@@ -42,6 +88,13 @@ func Update(root string, dh *DirectoryHierarchy, keywords []Keyword, fs FsEval)
 	h := &pathUpdateHeap{}
 	heap.Init(h)
 
+	// Access times are set after the modification times, as setting "time"
+	// sets the access time as well. Capabilities are set after the owner, as
+	// changing it clears them. Flags are set last, as immutable or
+	// append-only flags would stop the other attributes from being updated
+	// (and any which are already set on disk are cleared first).
+	var atimeUpdates, capUpdates, flagUpdates []pathUpdate
+
 	results := []InodeDelta{}
 	for i, e := range creator.DH.Entries {
 		switch e.Type {
@@ -65,17 +118,92 @@ func Update(root string, dh *DirectoryHierarchy, keywords []Keyword, fs FsEval)
 			kvToUpdate = keyvalSelector(e.AllKeys(), keywords)
 			logrus.Debugf("kvToUpdate(%q): %#v", pathname, kvToUpdate)
 
+			// An immutable or append-only file can't have its other
+			// attributes updated, so unlock it until its flags are set.
+			if len(HasKeyword(kvToUpdate, "flags")) > 0 {
+				if spec, ok := registry.Lookup("flags"); ok && spec.Update != nil {
+					if err := clearLockingFlags(pathname); err != nil {
+						results = append(results, InodeDelta{
+							diff: ErrorDifference,
+							path: pathname,
+							old:  e,
+							keys: []KeyDelta{
+								{
+									diff: ErrorDifference,
+									name: "flags",
+									err:  err,
+								},
+							}})
+					}
+				}
+			}
+
 			for _, kv := range kvToUpdate {
-				if !InKeywordSlice(kv.Keyword().Prefix(), keywordPrefixes(keywords)) {
+				logrus.Debugf("finding function for %q (%q)", kv.Keyword(), kv.Keyword().Prefix())
+				spec, ok := registry.Lookup(kv.Keyword())
+				if ok && spec.Volatile {
+					results = append(results, InodeDelta{
+						diff: ErrorDifference,
+						path: pathname,
+						old:  e,
+						keys: []KeyDelta{
+							{
+								diff: ErrorDifference,
+								name: kv.Keyword(),
+								err:  fmt.Errorf("keyword %q cannot be restored", kv.Keyword()),
+							},
+						}})
 					continue
 				}
-				logrus.Debugf("finding function for %q (%q)", kv.Keyword(), kv.Keyword().Prefix())
-				ukFunc, ok := UpdateKeywordFuncs[kv.Keyword().Prefix()]
-				if !ok {
+				ukFunc := spec.Update
+				if !ok || ukFunc == nil {
 					logrus.Debugf("no UpdateKeywordFunc for %s; skipping", kv.Keyword())
 					continue
 				}
 
+				kv, err := mapIDKeyVal(kv, opts.UIDMap, opts.GIDMap)
+				if err != nil {
+					results = append(results, InodeDelta{
+						diff: ErrorDifference,
+						path: pathname,
+						old:  e,
+						keys: []KeyDelta{
+							{
+								diff: ErrorDifference,
+								name: kv.Keyword(),
+								err:  err,
+							},
+						}})
+					continue
+				}
+
+				switch kv.Keyword() {
+				case "atime":
+					atimeUpdates = append(atimeUpdates, pathUpdate{
+						Path: pathname,
+						E:    e,
+						KV:   kv,
+						Func: ukFunc,
+					})
+					continue
+				case "caps":
+					capUpdates = append(capUpdates, pathUpdate{
+						Path: pathname,
+						E:    e,
+						KV:   kv,
+						Func: ukFunc,
+					})
+					continue
+				case "flags":
+					flagUpdates = append(flagUpdates, pathUpdate{
+						Path: pathname,
+						E:    e,
+						KV:   kv,
+						Func: ukFunc,
+					})
+					continue
+				}
+
 				// TODO check for the type=dir of the entry as well
 				if kv.Keyword().Prefix() == "time" && e.IsDir() {
 					heap.Push(h, pathUpdate{
@@ -108,8 +236,14 @@ func Update(root string, dh *DirectoryHierarchy, keywords []Keyword, fs FsEval)
 		}
 	}
 
+	var deferred []pathUpdate
 	for h.Len() > 0 {
-		pu := heap.Pop(h).(pathUpdate)
+		deferred = append(deferred, heap.Pop(h).(pathUpdate))
+	}
+	deferred = append(deferred, atimeUpdates...)
+	deferred = append(deferred, capUpdates...)
+	deferred = append(deferred, flagUpdates...)
+	for _, pu := range deferred {
 		if _, err := pu.Func(pu.Path, pu.KV); err != nil {
 			results = append(results, InodeDelta{
 				diff: ErrorDifference,
@@ -18,6 +18,9 @@ type UpdateKeywordFunc func(path string, kv KeyVal) (os.FileInfo, error)
 
 // UpdateKeywordFuncs is the registered list of functions to update file attributes.
 // Keyed by the keyword as it would show up in the manifest
+//
+// Deprecated: Set KeywordSpec.Update when registering keywords with
+// DefaultKeywordRegistry (or a clone of it) instead.
 var UpdateKeywordFuncs = map[Keyword]UpdateKeywordFunc{
 	"mode":     modeUpdateKeywordFunc,
 	"time":     timeUpdateKeywordFunc,
@@ -26,6 +29,7 @@ var UpdateKeywordFuncs = map[Keyword]UpdateKeywordFunc{
 	"gid":      gidUpdateKeywordFunc,
 	"xattr":    xattrUpdateKeywordFunc,
 	"link":     linkUpdateKeywordFunc,
+	"flags":    flagsUpdateKeywordFunc,
 }
 
 func uidUpdateKeywordFunc(path string, kv KeyVal) (os.FileInfo, error) {
@@ -68,6 +72,30 @@ func gidUpdateKeywordFunc(path string, kv KeyVal) (os.FileInfo, error) {
 	return os.Lstat(path)
 }
 
+// unameUpdateKeywordFunc returns an UpdateKeywordFunc which sets the owner of
+// a file to the user named by a "uname" value, as resolved by res.
+func unameUpdateKeywordFunc(res IDResolver) UpdateKeywordFunc {
+	return func(path string, kv KeyVal) (os.FileInfo, error) {
+		uid, err := res.LookupUser(kv.Value())
+		if err != nil {
+			return nil, err
+		}
+		return uidUpdateKeywordFunc(path, KeyVal(fmt.Sprintf("uid=%d", uid)))
+	}
+}
+
+// gnameUpdateKeywordFunc returns an UpdateKeywordFunc which sets the group of
+// a file to the group named by a "gname" value, as resolved by res.
+func gnameUpdateKeywordFunc(res IDResolver) UpdateKeywordFunc {
+	return func(path string, kv KeyVal) (os.FileInfo, error) {
+		gid, err := res.LookupGroup(kv.Value())
+		if err != nil {
+			return nil, err
+		}
+		return gidUpdateKeywordFunc(path, KeyVal(fmt.Sprintf("gid=%d", gid)))
+	}
+}
+
 func modeUpdateKeywordFunc(path string, kv KeyVal) (os.FileInfo, error) {
 	info, err := os.Lstat(path)
 	if err != nil {
@@ -78,21 +106,16 @@ func modeUpdateKeywordFunc(path string, kv KeyVal) (os.FileInfo, error) {
 	if info.Mode()&os.ModeSymlink != 0 {
 		return info, nil
 	}
-	vmode, err := strconv.ParseInt(kv.Value(), 8, 32)
-	if err != nil {
-		return nil, err
-	}
-
-	stat, err := os.Lstat(path)
+	vmode, err := ParseMode(kv.Value())
 	if err != nil {
 		return nil, err
 	}
-	if stat.Mode() == os.FileMode(vmode) {
-		return stat, nil
+	if info.Mode()&(os.ModePerm|modeSpecial) == vmode {
+		return info, nil
 	}
 
-	logrus.Debugf("path: %q, kv.Value(): %q, vmode: %o", path, kv.Value(), vmode)
-	if err := os.Chmod(path, os.FileMode(vmode)); err != nil {
+	logrus.Debugf("path: %q, kv.Value(): %q, vmode: %s", path, kv.Value(), vmode)
+	if err := os.Chmod(path, vmode); err != nil {
 		return nil, err
 	}
 	return os.Lstat(path)
@@ -15,7 +15,7 @@ func xattrUpdateKeywordFunc(path string, kv KeyVal) (os.FileInfo, error) {
 	if err != nil {
 		return nil, err
 	}
-	if err := xattr.Set(path, kv.Keyword().Suffix(), buf); err != nil {
+	if err := xattr.Lset(path, kv.Keyword().Suffix(), buf); err != nil {
 		return nil, err
 	}
 	return os.Lstat(path)
@@ -10,3 +10,7 @@ import (
 func xattrUpdateKeywordFunc(path string, kv KeyVal) (os.FileInfo, error) {
 	return os.Lstat(path)
 }
+
+func flagsUpdateKeywordFunc(path string, kv KeyVal) (os.FileInfo, error) {
+	return os.Lstat(path)
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"os/user"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
 	"time"
@@ -30,15 +31,76 @@ var defaultSetKeyVals = []KeyVal{"type=file", "nlink=1", "flags=none", "mode=066
 // * `keywords` are the set to collect from the walked paths. The recommended default list is DefaultKeywords.
 // * `fsEval` is the interface to use in evaluating files. If `nil`, then DefaultFsEval is used.
 func Walk(root string, excludes []ExcludeFunc, keywords []Keyword, fsEval FsEval) (*DirectoryHierarchy, error) {
+	return WalkWithOptions(root, &WalkOptions{
+		Excludes: excludes,
+		Keywords: keywords,
+		FsEval:   fsEval,
+	})
+}
+
+// WalkOptions are the parameters for WalkWithOptions.
+type WalkOptions struct {
+	// Excludes are used to skip paths.
+	Excludes []ExcludeFunc
+
+	// Keywords are the set to collect from the walked paths. The recommended
+	// default list is DefaultKeywords.
+	Keywords []Keyword
+
+	// FsEval is the interface to use in evaluating files. If nil, then
+	// DefaultFsEval is used.
+	FsEval FsEval
+
+	// Rules adjust Keywords for the paths they match, so that some keywords
+	// are only collected for (or are omitted from) particular subtrees.
+	Rules KeywordRules
+
+	// Registry is used to find how to collect each keyword. If nil, then
+	// DefaultKeywordRegistry is used.
+	Registry *KeywordRegistry
+
+	// IDResolver is used to find the names for the "uname" and "gname"
+	// keywords, such as NewRootIDResolver(root) to use the names of the
+	// hierarchy rather than the host. If set, it is an error for a uid or gid
+	// to have no name. If nil, the host's names are used, and a uid or gid
+	// with no name has no "uname" or "gname" value.
+	IDResolver IDResolver
+
+	// DirHashPrefix is joined to the names of the files in "dirhash" values,
+	// such as "example.com/mod@v1.0.0" to give the hash of a module in
+	// go.sum.
+	DirHashPrefix string
+}
+
+// WalkWithOptions is like Walk, but takes its parameters from opts. A nil opts
+// is treated the same as a zero WalkOptions.
+func WalkWithOptions(root string, opts *WalkOptions) (*DirectoryHierarchy, error) {
+	if opts == nil {
+		opts = &WalkOptions{}
+	}
+	var (
+		excludes = opts.Excludes
+		keywords = opts.Keywords
+		fsEval   = opts.FsEval
+		registry = opts.Registry
+	)
 	if fsEval == nil {
 		fsEval = DefaultFsEval{}
 	}
+	if registry == nil {
+		registry = DefaultKeywordRegistry
+	}
+	if opts.IDResolver != nil {
+		registry = registry.withIDResolver(opts.IDResolver)
+	}
 	if info, err := os.Stat(root); err == nil {
 		if !info.IsDir() {
 			return nil, fmt.Errorf("%s: Not a directory", filepath.Base(root))
 		}
 	}
 	creator := dhCreator{DH: &DirectoryHierarchy{}, fs: fsEval}
+	digester := newDirDigester(opts.DirHashPrefix)
+	anyDigest, anyHash := selectDirDigests(keywords)
 	// insert signature and metadata comments first (user, machine, tree, date)
 	for _, e := range signatureEntries(root) {
 		e.Pos = len(creator.DH.Entries)
@@ -99,34 +161,15 @@ func Walk(root string, excludes []ExcludeFunc, keywords []Keyword, fsEval FsEval
 					Keywords: keyvalSelector(defaultSetKeyVals, keywords),
 				}
 				for _, keyword := range SetKeywords {
-					err := func() error {
-						var r io.Reader
-						if info.Mode().IsRegular() {
-							fh, err := creator.fs.Open(path)
-							if err != nil {
-								return err
-							}
-							defer fh.Close()
-							r = fh
-						}
-						keyFunc, ok := KeywordFuncs[keyword.Prefix()]
-						if !ok {
-							return fmt.Errorf("unknown keyword %q for file %q", keyword.Prefix(), path)
-						}
-						kvs, err := creator.fs.KeywordFunc(keyFunc)(path, info, r)
-						if err != nil {
-							return err
-						}
-						for _, kv := range kvs {
-							if kv != "" {
-								e.Keywords = append(e.Keywords, kv)
-							}
-						}
-						return nil
-					}()
+					kvs, err := creator.collect(registry, keyword, path, info)
 					if err != nil {
 						return err
 					}
+					for _, kv := range kvs {
+						if kv != "" {
+							e.Keywords = append(e.Keywords, kv)
+						}
+					}
 				}
 				creator.curSet = &e
 				creator.DH.Entries = append(creator.DH.Entries, e)
@@ -134,34 +177,15 @@ func Walk(root string, excludes []ExcludeFunc, keywords []Keyword, fsEval FsEval
 				// check the attributes of the /set keywords and re-set if changed
 				klist := []KeyVal{}
 				for _, keyword := range SetKeywords {
-					err := func() error {
-						var r io.Reader
-						if info.Mode().IsRegular() {
-							fh, err := creator.fs.Open(path)
-							if err != nil {
-								return err
-							}
-							defer fh.Close()
-							r = fh
-						}
-						keyFunc, ok := KeywordFuncs[keyword.Prefix()]
-						if !ok {
-							return fmt.Errorf("unknown keyword %q for file %q", keyword.Prefix(), path)
-						}
-						kvs, err := creator.fs.KeywordFunc(keyFunc)(path, info, r)
-						if err != nil {
-							return err
-						}
-						for _, kv := range kvs {
-							if kv != "" {
-								klist = append(klist, kv)
-							}
-						}
-						return nil
-					}()
+					kvs, err := creator.collect(registry, keyword, path, info)
 					if err != nil {
 						return err
 					}
+					for _, kv := range kvs {
+						if kv != "" {
+							klist = append(klist, kv)
+						}
+					}
 				}
 
 				needNewSet := false
@@ -193,35 +217,63 @@ func Walk(root string, excludes []ExcludeFunc, keywords []Keyword, fsEval FsEval
 			Set:    creator.curSet,
 			Parent: creator.curDir,
 		}
-		for _, keyword := range keywords {
-			err := func() error {
-				var r io.Reader
-				if info.Mode().IsRegular() {
-					fh, err := creator.fs.Open(path)
-					if err != nil {
-						return err
-					}
-					defer fh.Close()
-					r = fh
+		relPath, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+		entryKeywords := keywords
+		if opts.Rules != nil {
+			entryKeywords = opts.Rules.Apply(relPath, keywords)
+		}
+		// Each keyword is collected once, however many selectors (such as
+		// "xattr.user.*" and "xattr.trusted.*") there are for it. Selectors
+		// such as "exec" may also select several keywords registered with a
+		// suffix (such as "exec.<name>").
+		collected := map[Keyword]bool{}
+		for _, keyword := range entryKeywords {
+			if strings.HasPrefix(string(keyword), "!") {
+				continue
+			}
+			names := []Keyword{keyword}
+			if specs := registry.lookupSelector(keyword); len(specs) > 0 {
+				names = names[:0]
+				for _, spec := range specs {
+					names = append(names, spec.Name)
 				}
-				keyFunc, ok := KeywordFuncs[keyword.Prefix()]
-				if !ok {
-					return fmt.Errorf("unknown keyword %q for file %q", keyword.Prefix(), path)
+			}
+			for _, name := range names {
+				if collected[name] {
+					continue
 				}
-				kvs, err := creator.fs.KeywordFunc(keyFunc)(path, info, r)
+				collected[name] = true
+				kvs, err := creator.collect(registry, name, path, info)
 				if err != nil {
 					return err
 				}
 				for _, kv := range kvs {
-					if kv != "" && !inKeyValSlice(kv, creator.curSet.Keywords) {
+					if kv != "" && suffixSelected(kv.Keyword(), entryKeywords) && !inKeyValSlice(kv, creator.curSet.Keywords) {
 						e.Keywords = append(e.Keywords, kv)
 					}
 				}
-				return nil
-			}()
+			}
+		}
+		// Record the path for the "dirdigest" and "dirhash" of its parents,
+		// which are added once the whole tree has been walked.
+		if digest, hash := selectDirDigests(entryKeywords); digest || hash || anyDigest || anyHash {
+			child, err := dirChildOf(e.Keywords, func(fn KeywordFunc) ([]KeyVal, error) {
+				return creator.fs.KeywordFunc(fn)(path, info, nil)
+			}, func() (io.ReadCloser, error) {
+				return creator.fs.Open(path)
+			})
 			if err != nil {
 				return err
 			}
+			digester.add(relPath, child)
+			if info.IsDir() {
+				digester.digestDirs[relPath] = digest
+				digester.hashDirs[relPath] = hash
+			}
 		}
 		if info.IsDir() {
 			if creator.curDir != nil {
@@ -239,7 +291,29 @@ func Walk(root string, excludes []ExcludeFunc, keywords []Keyword, fsEval FsEval
 		creator.DH.Entries = append(creator.DH.Entries, e)
 		return nil
 	})
-	return creator.DH, err
+	if err != nil {
+		return creator.DH, err
+	}
+	return creator.DH, digester.apply(creator.DH)
+}
+
+// collect produces the values of keyword for the file at path, only opening
+// the file if the keyword needs its content.
+func (c *dhCreator) collect(registry *KeywordRegistry, keyword Keyword, path string, info os.FileInfo) ([]KeyVal, error) {
+	spec, ok := registry.Lookup(keyword)
+	if !ok || spec.Collect == nil {
+		return nil, fmt.Errorf("unknown keyword %q for file %q", keyword, path)
+	}
+	var r io.Reader
+	if spec.ConsumesContent && info.Mode().IsRegular() {
+		fh, err := c.fs.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer fh.Close()
+		r = fh
+	}
+	return c.fs.KeywordFunc(spec.Collect)(path, info, r)
 }
 
 // startWalk walks the file tree rooted at root, calling walkFn for each file or
@@ -383,6 +457,11 @@ func signatureEntries(root string) []Entry {
 // keywordEntries returns a slice of entries including a comment of the
 // keywords requested when generating this manifest.
 func keywordEntries(keywords []Keyword) []Entry {
+	// Selectors which remove keywords (such as "!xattr.security.*") are not
+	// keywords of the manifest themselves.
+	keywords = slices.DeleteFunc(slices.Clone(keywords), func(kw Keyword) bool {
+		return strings.HasPrefix(string(kw), "!")
+	})
 	// Convert all of the keywords to zero-value keyvals.
 	return []Entry{
 		{
@@ -2,6 +2,7 @@ package mtree
 
 import (
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -46,3 +47,16 @@ func TestWalkDirectory(t *testing.T) {
 		}
 	}
 }
+
+func TestWalkKeywordsHeader(t *testing.T) {
+	dh, err := Walk(t.TempDir(), nil, []Keyword{"type", "xattr", "!xattr.security.*"}, nil)
+	require.NoError(t, err)
+
+	var header string
+	for _, e := range dh.Entries {
+		if e.Type == CommentType && strings.Contains(e.Raw, "keywords: ") {
+			header = e.Raw
+		}
+	}
+	assert.Equal(t, "#      keywords: type,xattr", header, "removed keywords are not in the header")
+}
@@ -4,40 +4,104 @@
 package xattr
 
 import (
+	"errors"
 	"strings"
-	"syscall"
+
+	"golang.org/x/sys/unix"
 )
 
 // Get returns the extended attributes (xattr) on file `path`, for the given `name`.
 func Get(path, name string) ([]byte, error) {
-	dest := make([]byte, 1024)
-	i, err := syscall.Getxattr(path, name, dest)
-	if err != nil {
-		return nil, err
-	}
-	return dest[:i], nil
+	return getxattr(unix.Getxattr, path, name)
+}
+
+// Lget is like Get, but if `path` is a symlink the extended attribute of the
+// symlink itself is returned.
+func Lget(path, name string) ([]byte, error) {
+	return getxattr(unix.Lgetxattr, path, name)
 }
 
 // Set sets the extended attributes (xattr) on file `path`, for the given `name` and `value`
 func Set(path, name string, value []byte) error {
-	return syscall.Setxattr(path, name, value, 0)
+	return unix.Setxattr(path, name, value, 0)
+}
+
+// Lset is like Set, but if `path` is a symlink the extended attribute is set
+// on the symlink itself.
+func Lset(path, name string, value []byte) error {
+	return unix.Lsetxattr(path, name, value, 0)
+}
+
+// Remove removes the extended attribute (xattr) `name` from file `path`
+func Remove(path, name string) error {
+	return unix.Removexattr(path, name)
+}
+
+// Lremove is like Remove, but if `path` is a symlink the extended attribute is
+// removed from the symlink itself.
+func Lremove(path, name string) error {
+	return unix.Lremovexattr(path, name)
 }
 
 // List returns a list of all the extended attributes (xattr) for file `path`
 func List(path string) ([]string, error) {
-	dest := make([]byte, 1024)
-	i, err := syscall.Listxattr(path, dest)
-	if err != nil {
-		return nil, err
-	}
+	return listxattr(unix.Listxattr, path)
+}
 
-	// If the returned list is empty, return nil instead of []string{""}
-	str := string(dest[:i])
-	if str == "" {
-		return nil, nil
+// Llist is like List, but if `path` is a symlink the extended attributes of
+// the symlink itself are listed.
+func Llist(path string) ([]string, error) {
+	return listxattr(unix.Llistxattr, path)
+}
+
+// getxattr calls get with a buffer large enough for the value, by first asking
+// for its size. The value can grow between the calls, so that is retried.
+func getxattr(get func(path, name string, dest []byte) (int, error), path, name string) ([]byte, error) {
+	for {
+		size, err := get(path, name, nil)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return []byte{}, nil
+		}
+		dest := make([]byte, size)
+		i, err := get(path, name, dest)
+		if errors.Is(err, unix.ERANGE) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return dest[:i], nil
 	}
+}
 
-	return strings.Split(strings.TrimRight(str, nilByte), nilByte), nil
+// listxattr is like getxattr, for the list of extended attribute names.
+func listxattr(list func(path string, dest []byte) (int, error), path string) ([]string, error) {
+	for {
+		size, err := list(path, nil)
+		if err != nil {
+			return nil, err
+		}
+		// If the returned list is empty, return nil instead of []string{""}
+		if size == 0 {
+			return nil, nil
+		}
+		dest := make([]byte, size)
+		i, err := list(path, dest)
+		if errors.Is(err, unix.ERANGE) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		str := string(dest[:i])
+		if str == "" {
+			return nil, nil
+		}
+		return strings.Split(strings.TrimRight(str, nilByte), nilByte), nil
+	}
 }
 
 const nilByte = "\x00"
@@ -4,7 +4,10 @@
 package xattr
 
 import (
+	"bytes"
+	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -36,3 +39,79 @@ func TestXattr(t *testing.T) {
 	require.NoErrorf(t, err, "get user.testing xattr %s", path)
 	assert.Equalf(t, expected, got, "user.testing xattr %s", path)
 }
+
+func TestXattrLarge(t *testing.T) {
+	testDir, present := os.LookupEnv("MTREE_TESTDIR")
+	if present == false {
+		testDir = "."
+	}
+	fh, err := os.CreateTemp(testDir, "xattr.")
+	require.NoError(t, err)
+
+	path := fh.Name()
+	defer os.Remove(path)
+
+	require.NoError(t, fh.Close())
+
+	// Larger than the buffers which used to be used for values and lists.
+	expected := bytes.Repeat([]byte("0123456789abcdef"), 96)
+	if err := Set(path, "user.large", expected); err != nil {
+		t.Skipf("skipping: %q does not support large xattrs: %v", path, err)
+	}
+	names := []string{"user.large"}
+	for i := 0; i < 30; i++ {
+		name := fmt.Sprintf("user.a_rather_long_extended_attribute_name.%d", i)
+		require.NoErrorf(t, Set(path, name, nil), "set %s xattr %s", name, path)
+		names = append(names, name)
+	}
+
+	l, err := List(path)
+	require.NoErrorf(t, err, "list xattr %s", path)
+	assert.Subsetf(t, l, names, "xattr list for %s", path)
+
+	got, err := Get(path, "user.large")
+	require.NoErrorf(t, err, "get user.large xattr %s", path)
+	assert.Equalf(t, expected, got, "user.large xattr %s", path)
+
+	got, err = Get(path, names[1])
+	require.NoErrorf(t, err, "get %s xattr %s", names[1], path)
+	assert.Emptyf(t, got, "%s xattr %s", names[1], path)
+}
+
+func TestXattrSymlink(t *testing.T) {
+	testDir, present := os.LookupEnv("MTREE_TESTDIR")
+	if present == false {
+		testDir = "."
+	}
+	dir, err := os.MkdirTemp(testDir, "xattr.")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	target := filepath.Join(dir, "target")
+	require.NoError(t, os.WriteFile(target, nil, 0o644))
+	link := filepath.Join(dir, "link")
+	require.NoError(t, os.Symlink("target", link))
+
+	// Only privileged namespaces can be set on symlinks.
+	if err := Lset(link, "trusted.testing", []byte("link")); err != nil {
+		t.Skipf("skipping: cannot set trusted xattr on symlink %s: %v", link, err)
+	}
+
+	l, err := Llist(link)
+	require.NoErrorf(t, err, "llist xattr %s", link)
+	assert.Equalf(t, []string{"trusted.testing"}, l, "xattr list for %s", link)
+
+	got, err := Lget(link, "trusted.testing")
+	require.NoErrorf(t, err, "lget trusted.testing xattr %s", link)
+	assert.Equalf(t, []byte("link"), got, "trusted.testing xattr %s", link)
+
+	// The target is left alone.
+	l, err = List(link)
+	require.NoErrorf(t, err, "list xattr %s", link)
+	assert.Emptyf(t, l, "xattr list for %s", target)
+
+	require.NoErrorf(t, Lremove(link, "trusted.testing"), "lremove trusted.testing xattr %s", link)
+	l, err = Llist(link)
+	require.NoErrorf(t, err, "llist xattr %s", link)
+	assert.Emptyf(t, l, "xattr list for %s", link)
+}
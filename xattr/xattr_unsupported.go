@@ -15,8 +15,38 @@ func Set(path, name string, value []byte) error {
 	return nil
 }
 
+// Remove would remove an extended attribute, but this unsupported feature
+// returns nil
+func Remove(path, name string) error {
+	return nil
+}
+
 // List would return the keys of extended attributes, but this unsupported
 // feature returns nil, nil
 func List(path string) ([]string, error) {
 	return nil, nil
 }
+
+// Lget would return the extended attributes of a symlink, but this
+// unsupported feature returns nil, nil
+func Lget(path, name string) ([]byte, error) {
+	return nil, nil
+}
+
+// Lset would set the extended attributes of a symlink, but this unsupported
+// feature returns nil
+func Lset(path, name string, value []byte) error {
+	return nil
+}
+
+// Lremove would remove an extended attribute of a symlink, but this
+// unsupported feature returns nil
+func Lremove(path, name string) error {
+	return nil
+}
+
+// Llist would return the keys of extended attributes of a symlink, but this
+// unsupported feature returns nil, nil
+func Llist(path string) ([]string, error) {
+	return nil, nil
+}